@@ -2,6 +2,10 @@ package bun
 
 import (
 	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,47 +13,171 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/eddmann/buns/internal/cache"
 	"github.com/schollz/progressbar/v3"
 )
 
+const (
+	// ReleasesURL is the base URL for Bun release downloads
+	ReleasesURL = "https://github.com/oven-sh/bun/releases/download"
+
+	// ChecksumFile is written next to each cached binary. It holds, in
+	// sha256sum format, the verified digest of the release archive and the
+	// digest of the extracted binary.
+	ChecksumFile = "bun.sha256"
+
+	// CanaryRefreshInterval is how long a cached canary build is used before
+	// a newer one is fetched
+	CanaryRefreshInterval = 24 * time.Hour
+
+	shasumsFile = "SHASUMS256.txt"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrNoChecksum       = errors.New("no checksum recorded")
+)
+
 // Downloader handles downloading Bun binaries
 type Downloader struct {
 	cacheDir string
+	mirrors  []string
+	variant  Variant
 	verbose  bool
 	quiet    bool
 }
 
-// NewDownloader creates a new downloader
+// NewDownloader creates a new downloader for the build detected for this machine
 func NewDownloader(cacheDir string, verbose, quiet bool) *Downloader {
+	return NewDownloaderWithURL(cacheDir, ReleasesURL, verbose, quiet)
+}
+
+// NewDownloaderWithURL creates a downloader that fetches releases from a custom base URL
+func NewDownloaderWithURL(cacheDir, baseURL string, verbose, quiet bool) *Downloader {
+	return NewDownloaderWithMirrors(cacheDir, []string{baseURL}, DetectVariant(), verbose, quiet)
+}
+
+// NewDownloaderWithMirrors creates a downloader that tries each mirror in turn
+// until one serves a verified release. A mirror is either a base URL laid out
+// like GitHub releases ({base}/{tag}/{file}) or a template using the {tag},
+// {version} and {file} placeholders, e.g. "https://mirror/bun/{version}/{file}".
+// Each variant of a version is cached separately.
+func NewDownloaderWithMirrors(cacheDir string, mirrors []string, variant Variant, verbose, quiet bool) *Downloader {
+	trimmed := make([]string, 0, len(mirrors))
+	for _, m := range mirrors {
+		if m = strings.TrimSuffix(strings.TrimSpace(m), "/"); m != "" {
+			trimmed = append(trimmed, m)
+		}
+	}
+	if len(trimmed) == 0 {
+		trimmed = []string{ReleasesURL}
+	}
+
 	return &Downloader{
 		cacheDir: cacheDir,
+		mirrors:  trimmed,
+		variant:  variant,
 		verbose:  verbose,
 		quiet:    quiet,
 	}
 }
 
-// GetBinary returns the path to the Bun binary, downloading if necessary
+// GetBinary returns the path to the Bun binary, downloading if necessary.
+// A cached binary is re-checked against its recorded digest before use.
+// The canary build is re-fetched once older than CanaryRefreshInterval.
 func (d *Downloader) GetBinary(version *semver.Version) (string, error) {
-	binPath := d.binaryPath(version)
-
 	// Check if already cached
-	if _, err := os.Stat(binPath); err == nil {
-		return binPath, nil
+	if d.IsCached(version) && !d.isStale(version) {
+		return d.CachedBinary(version)
 	}
 
 	// Download
 	if err := d.download(version); err != nil {
+		// Keep running on the previous canary build if it cannot be refreshed
+		if IsCanary(version) && d.IsCached(version) {
+			d.log("Warning: failed to refresh Bun canary, using cached build: %v", err)
+			return d.CachedBinary(version)
+		}
 		return "", err
 	}
 
+	return d.binaryPath(version), nil
+}
+
+// CachedBinary returns the path to an already downloaded binary, without
+// touching the network. The binary is hashed against its recorded digest
+// every time, refusing one that no longer matches.
+func (d *Downloader) CachedBinary(version *semver.Version) (string, error) {
+	if !d.IsCached(version) {
+		return "", fmt.Errorf("bun %s is not cached", VersionName(version))
+	}
+
+	binPath := d.binaryPath(version)
+	if err := VerifyBinary(filepath.Dir(binPath)); err != nil {
+		return "", fmt.Errorf("refusing to run Bun %s: %w (run 'buns cache clean --bun' to re-download)", VersionName(version), err)
+	}
 	return binPath, nil
 }
 
-// download fetches and extracts the Bun binary
+// download fetches and extracts the Bun binary. Concurrent downloads of the same
+// version are serialised, and the binary only appears once fully extracted.
 func (d *Downloader) download(version *semver.Version) error {
-	url := d.downloadURL(version)
+	isDone := func(dir string) bool {
+		return isCachedDir(dir) && !d.isStale(version)
+	}
+
+	return cache.Populate(d.versionDir(version), isDone, func(tmpDir string) error {
+		return d.fetch(version, tmpDir)
+	})
+}
+
+// isStale reports whether a cached canary build is due a refresh. Versioned
+// releases never change, so they are never stale.
+func (d *Downloader) isStale(version *semver.Version) bool {
+	if !IsCanary(version) {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(d.versionDir(version), ChecksumFile))
+	if err != nil {
+		return true
+	}
+	return time.Since(info.ModTime()) > CanaryRefreshInterval
+}
+
+// fetch downloads the release from the first mirror that serves it intact
+func (d *Downloader) fetch(version *semver.Version, destDir string) error {
+	var errs []string
+	for _, mirror := range d.mirrors {
+		err := d.fetchFrom(mirror, version, destDir)
+		if err == nil {
+			return nil
+		}
+		if len(d.mirrors) == 1 {
+			return err
+		}
+		d.log("Warning: %s: %v", mirror, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", mirror, err))
+	}
+
+	return fmt.Errorf("all mirrors failed:\n  - %s", strings.Join(errs, "\n  - "))
+}
+
+// fetchFrom downloads the release archive from a mirror, verifies it against
+// the release's SHASUMS256.txt and extracts the binary into destDir. The
+// checksums come from the same mirror and their signature isn't checked, so
+// this catches corrupt downloads, not a mirror serving a doctored release.
+func (d *Downloader) fetchFrom(mirror string, version *semver.Version, destDir string) error {
+	asset := d.assetName()
+	url := releaseFileURL(mirror, version, asset)
+
+	expected, err := d.fetchChecksum(mirror, version, asset)
+	if err != nil {
+		return err
+	}
 
 	// Create temp file for download
 	tmpFile, err := os.CreateTemp("", "bun-*.zip")
@@ -74,25 +202,129 @@ func (d *Downloader) download(version *semver.Version) error {
 	if !d.quiet {
 		bar := progressbar.DefaultBytes(
 			resp.ContentLength,
-			fmt.Sprintf("Downloading Bun %s", version.Original()),
+			fmt.Sprintf("Downloading Bun %s", VersionName(version)),
 		)
 		reader = io.TeeReader(resp.Body, bar)
 	}
 
-	if _, err := io.Copy(tmpFile, reader); err != nil {
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hash), reader); err != nil {
 		return fmt.Errorf("failed to download Bun: %w", err)
 	}
 
+	archiveDigest := hex.EncodeToString(hash.Sum(nil))
+	if archiveDigest != expected {
+		return fmt.Errorf("failed to verify Bun %s: %w for %s (expected %s, got %s)",
+			VersionName(version), ErrChecksumMismatch, asset, expected, archiveDigest)
+	}
+
 	// Extract
-	if err := d.extract(tmpFile.Name(), version); err != nil {
+	if err := d.extract(tmpFile.Name(), destDir); err != nil {
 		return fmt.Errorf("failed to extract Bun: %w", err)
 	}
 
+	binaryDigest, err := fileDigest(filepath.Join(destDir, "bun"))
+	if err != nil {
+		return err
+	}
+
+	checksums := fmt.Sprintf("%s  %s\n%s  bun\n", archiveDigest, asset, binaryDigest)
+	return os.WriteFile(filepath.Join(destDir, ChecksumFile), []byte(checksums), 0644)
+}
+
+// fetchChecksum downloads the release's SHASUMS256.txt and returns the digest for asset
+func (d *Downloader) fetchChecksum(mirror string, version *semver.Version, asset string) (string, error) {
+	url := releaseFileURL(mirror, version, shasumsFile)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download checksums: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download checksums: HTTP %d", resp.StatusCode)
+	}
+
+	sums, err := parseChecksums(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksums: %w", err)
+	}
+
+	digest, ok := sums[asset]
+	if !ok {
+		return "", fmt.Errorf("no checksum published for %s in Bun %s", asset, VersionName(version))
+	}
+	return digest, nil
+}
+
+// parseChecksums reads sha256sum-formatted lines into a map of file name to digest
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks binary mode with a leading '*'
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+
+	return sums, scanner.Err()
+}
+
+// VerifyBinary checks the binary in a cached version directory against the
+// digest recorded when it was downloaded
+func VerifyBinary(versionDir string) error {
+	f, err := os.Open(filepath.Join(versionDir, ChecksumFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoChecksum
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	sums, err := parseChecksums(f)
+	if err != nil {
+		return err
+	}
+
+	expected, ok := sums["bun"]
+	if !ok {
+		return ErrNoChecksum
+	}
+
+	actual, err := fileDigest(filepath.Join(versionDir, "bun"))
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+	}
 	return nil
 }
 
-// extract unpacks the zip and moves the binary to the cache
-func (d *Downloader) extract(zipPath string, version *semver.Version) error {
+// fileDigest returns the hex-encoded SHA-256 of a file
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// extract unpacks the binary from the zip into destDir
+func (d *Downloader) extract(zipPath, destDir string) error {
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return err
@@ -113,14 +345,8 @@ func (d *Downloader) extract(zipPath string, version *semver.Version) error {
 		return fmt.Errorf("bun binary not found in archive")
 	}
 
-	// Create the cache directory
-	versionDir := filepath.Join(d.cacheDir, version.Original())
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
-		return err
-	}
-
 	// Extract the binary
-	binPath := filepath.Join(versionDir, "bun")
+	binPath := filepath.Join(destDir, "bun")
 	outFile, err := os.OpenFile(binPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
@@ -140,35 +366,59 @@ func (d *Downloader) extract(zipPath string, version *semver.Version) error {
 	return nil
 }
 
-// downloadURL returns the GitHub release URL for the given version
-func (d *Downloader) downloadURL(version *semver.Version) string {
-	os := runtime.GOOS
-	arch := runtime.GOARCH
+// releaseFileURL returns the URL of a release file (archive or checksums) on a mirror
+func releaseFileURL(mirror string, version *semver.Version, file string) string {
+	if strings.Contains(mirror, "{") {
+		return strings.NewReplacer(
+			"{tag}", releaseTag(version),
+			"{version}", VersionName(version),
+			"{file}", file,
+		).Replace(mirror)
+	}
+	return fmt.Sprintf("%s/%s/%s", mirror, releaseTag(version), file)
+}
 
-	// Map Go's arch names to Bun's
-	switch arch {
-	case "amd64":
-		arch = "x64"
-	case "arm64":
-		arch = "aarch64"
+// releaseTag returns the GitHub release tag for a version, e.g. "bun-v1.1.34" or "canary"
+func releaseTag(version *semver.Version) string {
+	if IsCanary(version) {
+		return CanaryChannel
 	}
+	return "bun-v" + version.Original()
+}
+
+// assetName returns the release archive name for the current platform and variant
+func (d *Downloader) assetName() string {
+	return d.variant.assetName(runtime.GOOS, runtime.GOARCH)
+}
 
-	// Bun uses "darwin" for macOS (same as Go)
-	return fmt.Sprintf(
-		"https://github.com/oven-sh/bun/releases/download/bun-v%s/bun-%s-%s.zip",
-		version.Original(),
-		os,
-		arch,
-	)
+// versionDir returns the cache directory for a version (or channel) and variant
+func (d *Downloader) versionDir(version *semver.Version) string {
+	return filepath.Join(d.cacheDir, VersionName(version)+d.variant.cacheSuffix())
 }
 
 // binaryPath returns the expected path to the cached binary
 func (d *Downloader) binaryPath(version *semver.Version) string {
-	return filepath.Join(d.cacheDir, version.Original(), "bun")
+	return filepath.Join(d.versionDir(version), "bun")
 }
 
-// IsCached checks if a version is already downloaded
+// IsCached checks if a version is already downloaded with a recorded digest
 func (d *Downloader) IsCached(version *semver.Version) bool {
-	_, err := os.Stat(d.binaryPath(version))
-	return err == nil
+	return isCachedDir(d.versionDir(version))
+}
+
+// isCachedDir reports whether a version directory holds a binary and its checksums.
+// Binaries cached without checksums are treated as missing and re-downloaded.
+func isCachedDir(dir string) bool {
+	for _, name := range []string{"bun", ChecksumFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Downloader) log(format string, args ...interface{}) {
+	if d.verbose {
+		fmt.Printf("[buns] "+format+"\n", args...)
+	}
 }
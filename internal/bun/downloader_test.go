@@ -0,0 +1,314 @@
+package bun
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// testAsset is the archive name the downloader requests on this machine
+var testAsset = DetectVariant().assetName(runtime.GOOS, runtime.GOARCH)
+
+// releaseServer serves a fake Bun release archive and its SHASUMS256.txt
+func releaseServer(t *testing.T, shasums func(digest string) string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("bun-test/bun")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	_, _ = w.Write([]byte("#!/bin/sh\necho fake bun\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	archive := buf.Bytes()
+
+	sum := sha256.Sum256(archive)
+	digest := hex.EncodeToString(sum[:])
+
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bun-v1.1.34/" + testAsset, "/canary/" + testAsset:
+			downloads.Add(1)
+			_, _ = w.Write(archive)
+		case "/bun-v1.1.34/SHASUMS256.txt", "/canary/SHASUMS256.txt":
+			_, _ = w.Write([]byte(shasums(digest)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &downloads
+}
+
+func TestGetBinary_verifies_and_records_checksum(t *testing.T) {
+	server, downloads := releaseServer(t, func(digest string) string {
+		return fmt.Sprintf("0000  bun-other.zip\n%s  %s\n", digest, testAsset)
+	})
+	cacheDir := t.TempDir()
+	d := NewDownloaderWithURL(cacheDir, server.URL, false, true)
+	version := semver.MustParse("1.1.34")
+
+	binPath, err := d.GetBinary(version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if binPath != filepath.Join(cacheDir, "1.1.34", "bun") {
+		t.Errorf("binary path = %s", binPath)
+	}
+	if err := VerifyBinary(filepath.Dir(binPath)); err != nil {
+		t.Errorf("VerifyBinary() = %v, want nil", err)
+	}
+
+	// Second call uses the cache
+	if _, err := d.GetBinary(version); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("archive downloaded %d times, want 1", n)
+	}
+}
+
+func TestGetBinary_rejects_archive_with_wrong_checksum(t *testing.T) {
+	server, _ := releaseServer(t, func(string) string {
+		return fmt.Sprintf("%064d  %s\n", 0, testAsset)
+	})
+	cacheDir := t.TempDir()
+	d := NewDownloaderWithURL(cacheDir, server.URL, false, true)
+	version := semver.MustParse("1.1.34")
+
+	_, err := d.GetBinary(version)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if d.IsCached(version) {
+		t.Error("binary with a bad checksum should not be cached")
+	}
+}
+
+func TestGetBinary_fails_when_no_checksum_published(t *testing.T) {
+	server, downloads := releaseServer(t, func(digest string) string {
+		return digest + "  bun-other.zip\n"
+	})
+	d := NewDownloaderWithURL(t.TempDir(), server.URL, false, true)
+
+	if _, err := d.GetBinary(semver.MustParse("1.1.34")); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Errorf("archive downloaded %d times, want 0", n)
+	}
+}
+
+func TestGetBinary_refuses_tampered_binary(t *testing.T) {
+	server, _ := releaseServer(t, func(digest string) string {
+		return digest + "  " + testAsset + "\n"
+	})
+	d := NewDownloaderWithURL(t.TempDir(), server.URL, false, true)
+	version := semver.MustParse("1.1.34")
+
+	binPath, err := d.GetBinary(version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(binPath, []byte("#!/bin/sh\necho tampered\n"), 0755); err != nil {
+		t.Fatalf("failed to tamper with binary: %v", err)
+	}
+
+	if _, err := d.GetBinary(version); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestCachedBinary_refuses_a_binary_corrupted_in_place(t *testing.T) {
+	server, _ := releaseServer(t, func(digest string) string {
+		return digest + "  " + testAsset + "\n"
+	})
+	d := NewDownloaderWithURL(t.TempDir(), server.URL, false, true)
+	version := semver.MustParse("1.1.34")
+
+	binPath, err := d.GetBinary(version)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Corrupt the binary but keep its mtime, as touch -r or a restore would
+	info, err := os.Stat(binPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binPath, []byte("corrupt"), 0755); err != nil {
+		t.Fatalf("failed to corrupt binary: %v", err)
+	}
+	if err := os.Chtimes(binPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("failed to restore mtime: %v", err)
+	}
+
+	if _, err := d.CachedBinary(version); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("CachedBinary() = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestVerifyBinary_without_recorded_checksum(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bun"), []byte("bin"), 0755); err != nil {
+		t.Fatalf("failed to write binary: %v", err)
+	}
+
+	if err := VerifyBinary(dir); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("expected ErrNoChecksum, got %v", err)
+	}
+}
+
+func TestGetBinary_refreshes_stale_canary(t *testing.T) {
+	server, downloads := releaseServer(t, func(digest string) string {
+		return digest + "  " + testAsset + "\n"
+	})
+	cacheDir := t.TempDir()
+	d := NewDownloaderWithURL(cacheDir, server.URL, false, true)
+
+	binPath, err := d.GetBinary(Canary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if binPath != filepath.Join(cacheDir, "canary", "bun") {
+		t.Errorf("binary path = %s", binPath)
+	}
+
+	// Fresh canary is reused
+	if _, err := d.GetBinary(Canary); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Fatalf("archive downloaded %d times, want 1", n)
+	}
+
+	// Stale canary is re-fetched
+	old := time.Now().Add(-CanaryRefreshInterval - time.Hour)
+	if err := os.Chtimes(filepath.Join(cacheDir, "canary", ChecksumFile), old, old); err != nil {
+		t.Fatalf("failed to age checksum file: %v", err)
+	}
+	if _, err := d.GetBinary(Canary); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := downloads.Load(); n != 2 {
+		t.Errorf("archive downloaded %d times, want 2", n)
+	}
+
+	// A stale canary is still used when the refresh fails
+	if err := os.Chtimes(filepath.Join(cacheDir, "canary", ChecksumFile), old, old); err != nil {
+		t.Fatalf("failed to age checksum file: %v", err)
+	}
+	server.Close()
+	if _, err := d.GetBinary(Canary); err != nil {
+		t.Errorf("expected cached canary to be used, got %v", err)
+	}
+}
+
+func TestGetBinary_falls_back_through_mirrors(t *testing.T) {
+	server, downloads := releaseServer(t, func(digest string) string {
+		return digest + "  " + testAsset + "\n"
+	})
+
+	var wrongDigest atomic.Int32
+	bad, _ := releaseServer(t, func(string) string {
+		wrongDigest.Add(1)
+		return fmt.Sprintf("%064d  %s\n", 0, testAsset)
+	})
+
+	mirrors := []string{
+		"http://127.0.0.1:1",         // unreachable
+		bad.URL,                      // serves a checksum that does not match
+		server.URL + "/{tag}/{file}", // template form of the good mirror
+	}
+	d := NewDownloaderWithMirrors(t.TempDir(), mirrors, DetectVariant(), false, true)
+	version := semver.MustParse("1.1.34")
+
+	if _, err := d.GetBinary(version); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wrongDigest.Load() != 1 {
+		t.Errorf("bad mirror consulted %d times, want 1", wrongDigest.Load())
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("good mirror served %d downloads, want 1", n)
+	}
+	if err := VerifyBinary(filepath.Dir(d.binaryPath(version))); err != nil {
+		t.Errorf("VerifyBinary() = %v, want nil", err)
+	}
+}
+
+func TestGetBinary_reports_every_failed_mirror(t *testing.T) {
+	d := NewDownloaderWithMirrors(t.TempDir(), []string{"http://127.0.0.1:1", "http://127.0.0.1:2"}, DetectVariant(), false, true)
+
+	_, err := d.GetBinary(semver.MustParse("1.1.34"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, mirror := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		if !strings.Contains(err.Error(), mirror) {
+			t.Errorf("error %q does not mention %s", err, mirror)
+		}
+	}
+}
+
+func TestReleaseFileURL(t *testing.T) {
+	tests := []struct {
+		mirror  string
+		version *semver.Version
+		want    string
+	}{
+		{"https://github.com/oven-sh/bun/releases/download", semver.MustParse("1.1.34"),
+			"https://github.com/oven-sh/bun/releases/download/bun-v1.1.34/SHASUMS256.txt"},
+		{"https://mirror.example/bun/{version}/{file}", semver.MustParse("1.1.34"),
+			"https://mirror.example/bun/1.1.34/SHASUMS256.txt"},
+		{"https://mirror.example/{tag}/{file}", Canary,
+			"https://mirror.example/canary/SHASUMS256.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := releaseFileURL(tt.mirror, tt.version, "SHASUMS256.txt"); got != tt.want {
+			t.Errorf("releaseFileURL(%q) = %q, want %q", tt.mirror, got, tt.want)
+		}
+	}
+}
+
+func TestGetBinary_caches_variants_separately(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	d := NewDownloaderWithMirrors(cacheDir, []string{server.URL}, VariantMusl, false, true)
+	version := semver.MustParse("1.1.34")
+
+	if got := d.binaryPath(version); got != filepath.Join(cacheDir, "1.1.34+musl", "bun") {
+		t.Errorf("binary path = %s", got)
+	}
+
+	_, _ = d.GetBinary(version)
+	if len(requested) == 0 || requested[0] != "/bun-v1.1.34/SHASUMS256.txt" {
+		t.Fatalf("requested = %v", requested)
+	}
+}
@@ -3,12 +3,22 @@ package bun
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/Masterminds/semver/v3"
 )
 
+// CanaryChannel names Bun's rolling canary build
+const CanaryChannel = "canary"
+
 var (
 	ErrNoMatchingVersion = errors.New("no Bun version satisfies constraint")
+
+	// Canary stands in for the canary build wherever a version is expected.
+	// Its cache entry and download URL are keyed on the channel name.
+	Canary = semver.New(0, 0, 0, CanaryChannel, "")
 )
 
 // VersionSource provides available Bun versions
@@ -16,6 +26,31 @@ type VersionSource interface {
 	GetVersions() ([]*semver.Version, error)
 }
 
+// ChannelSource is implemented by version sources that know which release
+// channels (e.g. "canary") are available
+type ChannelSource interface {
+	GetChannels() ([]string, error)
+}
+
+// IsChannel reports whether a constraint names a release channel
+func IsChannel(constraint string) bool {
+	return constraint == CanaryChannel
+}
+
+// IsCanary reports whether v refers to the canary channel
+func IsCanary(v *semver.Version) bool {
+	return v != nil && v.Equal(Canary)
+}
+
+// VersionName returns the name used for a version in cache paths and output:
+// the channel name for canary, otherwise the version as written
+func VersionName(v *semver.Version) string {
+	if IsCanary(v) {
+		return CanaryChannel
+	}
+	return v.Original()
+}
+
 // Resolver handles Bun version resolution
 type Resolver struct {
 	source VersionSource
@@ -26,8 +61,14 @@ func NewResolver(source VersionSource) *Resolver {
 	return &Resolver{source: source}
 }
 
-// Resolve finds the highest Bun version matching the constraint
+// Resolve finds the highest Bun version matching the constraint. A channel
+// name (e.g. "canary") resolves to that channel. Prereleases only match
+// constraints that themselves name a prerelease (e.g. ">=1.2.0-0").
 func (r *Resolver) Resolve(constraint string) (*semver.Version, error) {
+	if IsChannel(constraint) {
+		return r.resolveChannel(constraint)
+	}
+
 	versions, err := r.source.GetVersions()
 	if err != nil {
 		return nil, err
@@ -37,9 +78,14 @@ func (r *Resolver) Resolve(constraint string) (*semver.Version, error) {
 		return nil, errors.New("no Bun versions available")
 	}
 
-	// If no constraint, return the latest version
+	// If no constraint, return the latest stable version
 	if constraint == "" {
-		return versions[0], nil
+		for _, v := range versions {
+			if v.Prerelease() == "" {
+				return v, nil
+			}
+		}
+		return nil, errors.New("no stable Bun versions available")
 	}
 
 	// Parse the constraint
@@ -58,6 +104,28 @@ func (r *Resolver) Resolve(constraint string) (*semver.Version, error) {
 	return nil, fmt.Errorf("%w: '%s'", ErrNoMatchingVersion, constraint)
 }
 
+// resolveChannel checks the channel is available when the source can tell
+func (r *Resolver) resolveChannel(channel string) (*semver.Version, error) {
+	if cs, ok := r.source.(ChannelSource); ok {
+		channels, err := cs.GetChannels()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, c := range channels {
+			if c == channel {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: channel '%s' is not available", ErrNoMatchingVersion, channel)
+		}
+	}
+
+	return Canary, nil
+}
+
 // ResolveExact finds an exact version or returns an error
 func (r *Resolver) ResolveExact(version string) (*semver.Version, error) {
 	v, err := semver.NewVersion(version)
@@ -78,3 +146,59 @@ func (r *Resolver) ResolveExact(version string) (*semver.Version, error) {
 
 	return nil, fmt.Errorf("%w: '%s'", ErrNoMatchingVersion, version)
 }
+
+// CachedSource provides the Bun versions of one variant already downloaded to a cache directory
+type CachedSource struct {
+	cacheDir string
+	variant  Variant
+}
+
+// NewCachedSource creates a version source backed by downloaded binaries of a variant
+func NewCachedSource(cacheDir string, variant Variant) *CachedSource {
+	return &CachedSource{cacheDir: cacheDir, variant: variant}
+}
+
+// GetVersions returns cached versions that have a verified binary, sorted descending
+func (s *CachedSource) GetVersions() ([]*semver.Version, error) {
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []*semver.Version
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !isCachedDir(filepath.Join(s.cacheDir, entry.Name())) {
+			continue
+		}
+		v, err := semver.NewVersion(entry.Name())
+		if err != nil || v.Metadata() != string(s.variant) {
+			continue
+		}
+		// Report the version itself, without the variant suffix
+		bare, err := v.SetMetadata("")
+		if err != nil {
+			continue
+		}
+		versions = append(versions, &bare)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].GreaterThan(versions[j])
+	})
+
+	return versions, nil
+}
+
+// GetChannels returns the channels with a cached build
+func (s *CachedSource) GetChannels() ([]string, error) {
+	if isCachedDir(filepath.Join(s.cacheDir, CanaryChannel+s.variant.cacheSuffix())) {
+		return []string{CanaryChannel}, nil
+	}
+	return nil, nil
+}
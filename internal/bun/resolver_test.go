@@ -2,6 +2,8 @@ package bun
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Masterminds/semver/v3"
@@ -142,3 +144,124 @@ func TestResolver_Resolve_returns_error_when_no_versions_available(t *testing.T)
 		t.Error("expected error, got nil")
 	}
 }
+
+// stubChannelSource is a stub version source that also reports channels
+type stubChannelSource struct {
+	stubVersionSource
+	channels []string
+}
+
+func (s *stubChannelSource) GetChannels() ([]string, error) {
+	return s.channels, nil
+}
+
+func TestResolver_Resolve_prereleases(t *testing.T) {
+	source := &stubVersionSource{
+		versions: []*semver.Version{
+			mustVersion("1.2.0-rc.2"),
+			mustVersion("1.2.0-rc.1"),
+			mustVersion("1.1.34"),
+			mustVersion("1.1.33"),
+		},
+	}
+	resolver := NewResolver(source)
+
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"", "1.1.34"},
+		{">=1.1", "1.1.34"},
+		{"1.2.0-rc.1", "1.2.0-rc.1"},
+		{">=1.2.0-0", "1.2.0-rc.2"},
+		{"~1.2.0-rc.1", "1.2.0-rc.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			got, err := resolver.Resolve(tt.constraint)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Original() != tt.want {
+				t.Errorf("got %s, want %s", got.Original(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_Resolve_canary(t *testing.T) {
+	t.Run("resolves canary when the source lists it", func(t *testing.T) {
+		source := &stubChannelSource{channels: []string{"canary"}}
+		got, err := NewResolver(source).Resolve("canary")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !IsCanary(got) || VersionName(got) != "canary" {
+			t.Errorf("got %s, want canary", VersionName(got))
+		}
+	})
+
+	t.Run("errors when the source does not list canary", func(t *testing.T) {
+		source := &stubChannelSource{}
+		_, err := NewResolver(source).Resolve("canary")
+		if !errors.Is(err, ErrNoMatchingVersion) {
+			t.Errorf("expected ErrNoMatchingVersion, got %v", err)
+		}
+	})
+
+	t.Run("resolves canary when the source cannot list channels", func(t *testing.T) {
+		got, err := NewResolver(&stubVersionSource{}).Resolve("canary")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !IsCanary(got) {
+			t.Errorf("got %s, want canary", got)
+		}
+	})
+}
+
+func TestCachedSource_GetVersions(t *testing.T) {
+	cacheDir := t.TempDir()
+	for _, v := range []string{"1.1.33", "1.1.34", "1.0.0"} {
+		dir := filepath.Join(cacheDir, v)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "bun"), []byte("bin"), 0755); err != nil {
+			t.Fatalf("failed to write binary: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, ChecksumFile), nil, 0644); err != nil {
+			t.Fatalf("failed to write checksums: %v", err)
+		}
+	}
+	// Interrupted download without a binary
+	if err := os.MkdirAll(filepath.Join(cacheDir, "1.2.0"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	resolver := NewResolver(NewCachedSource(cacheDir, VariantDefault))
+
+	got, err := resolver.Resolve("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Original() != "1.1.34" {
+		t.Errorf("got %s, want 1.1.34", got.Original())
+	}
+
+	if _, err := resolver.Resolve(">=1.2.0"); err == nil {
+		t.Error("expected error for version without a cached binary")
+	}
+}
+
+func TestCachedSource_returns_no_versions_for_missing_dir(t *testing.T) {
+	versions, err := NewCachedSource(filepath.Join(t.TempDir(), "missing"), VariantDefault).GetVersions()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("got %v, want none", versions)
+	}
+}
@@ -0,0 +1,96 @@
+package bun
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// Variant selects which build of Bun to download for the platform
+type Variant string
+
+const (
+	VariantDefault      Variant = ""              // glibc (Linux), AVX2-capable CPU
+	VariantBaseline     Variant = "baseline"      // x64 CPUs without AVX2
+	VariantMusl         Variant = "musl"          // musl libc (e.g. Alpine)
+	VariantMuslBaseline Variant = "musl-baseline" // musl libc on x64 CPUs without AVX2
+)
+
+// ParseVariant parses a variant name. "auto" and "" detect the variant for
+// this machine; "default" selects the standard build.
+func ParseVariant(name string) (Variant, error) {
+	switch name {
+	case "", "auto":
+		return DetectVariant(), nil
+	case "default":
+		return VariantDefault, nil
+	case string(VariantBaseline), string(VariantMusl), string(VariantMuslBaseline):
+		return Variant(name), nil
+	}
+	return "", fmt.Errorf("unknown Bun variant '%s' (expected auto, default, baseline, musl or musl-baseline)", name)
+}
+
+// DetectVariant picks the Bun build for this machine: musl on musl-based
+// Linux, and baseline on x64 CPUs without AVX2
+func DetectVariant() Variant {
+	return detectVariant("/", runtime.GOOS, runtime.GOARCH, cpu.X86.HasAVX2)
+}
+
+func detectVariant(root, goos, goarch string, hasAVX2 bool) Variant {
+	musl := goos == "linux" && isMusl(root)
+	baseline := goarch == "amd64" && !hasAVX2
+
+	switch {
+	case musl && baseline:
+		return VariantMuslBaseline
+	case musl:
+		return VariantMusl
+	case baseline:
+		return VariantBaseline
+	}
+	return VariantDefault
+}
+
+// isMusl reports whether the system under root uses musl rather than glibc,
+// judged by which dynamic loader is installed. Some glibc distributions ship
+// the musl loader alongside their own, so glibc wins when both are present.
+func isMusl(root string) bool {
+	for _, pattern := range []string{"lib*/ld-linux-*.so.*", "lib*/ld-linux.so.*"} {
+		if matches, _ := filepath.Glob(filepath.Join(root, pattern)); len(matches) > 0 {
+			return false
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, "lib", "ld-musl-*.so.1"))
+	return len(matches) > 0
+}
+
+// assetName returns the release archive name for the platform, e.g.
+// "bun-linux-x64-musl-baseline.zip"
+func (v Variant) assetName(goos, goarch string) string {
+	// Map Go's arch names to Bun's
+	arch := goarch
+	switch goarch {
+	case "amd64":
+		arch = "x64"
+	case "arm64":
+		arch = "aarch64"
+	}
+
+	// Bun uses "darwin" for macOS (same as Go)
+	name := fmt.Sprintf("bun-%s-%s", goos, arch)
+	if v != VariantDefault {
+		name += "-" + string(v)
+	}
+	return name + ".zip"
+}
+
+// cacheSuffix distinguishes variant builds in the cache, e.g. "1.1.34+musl".
+// Build metadata keeps the directory name a valid semver version.
+func (v Variant) cacheSuffix() string {
+	if v == VariantDefault {
+		return ""
+	}
+	return "+" + string(v)
+}
@@ -0,0 +1,130 @@
+package bun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectVariant(t *testing.T) {
+	rootWith := func(t *testing.T, files ...string) string {
+		t.Helper()
+		root := t.TempDir()
+		for _, f := range files {
+			path := filepath.Join(root, f)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("failed to create dir: %v", err)
+			}
+			if err := os.WriteFile(path, nil, 0755); err != nil {
+				t.Fatalf("failed to write %s: %v", f, err)
+			}
+		}
+		return root
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		goos    string
+		goarch  string
+		hasAVX2 bool
+		want    Variant
+	}{
+		{"glibc with AVX2", []string{"lib64/ld-linux-x86-64.so.2"}, "linux", "amd64", true, VariantDefault},
+		{"glibc without AVX2", []string{"lib64/ld-linux-x86-64.so.2"}, "linux", "amd64", false, VariantBaseline},
+		{"musl with AVX2", []string{"lib/ld-musl-x86_64.so.1"}, "linux", "amd64", true, VariantMusl},
+		{"musl without AVX2", []string{"lib/ld-musl-x86_64.so.1"}, "linux", "amd64", false, VariantMuslBaseline},
+		{"musl on arm64", []string{"lib/ld-musl-aarch64.so.1"}, "linux", "arm64", false, VariantMusl},
+		{"glibc with musl loader installed", []string{"lib/ld-linux-aarch64.so.1", "lib/ld-musl-aarch64.so.1"}, "linux", "arm64", false, VariantDefault},
+		{"macOS on Apple Silicon", nil, "darwin", "arm64", false, VariantDefault},
+		{"macOS on Intel without AVX2", nil, "darwin", "amd64", false, VariantBaseline},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectVariant(rootWith(t, tt.files...), tt.goos, tt.goarch, tt.hasAVX2)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVariant(t *testing.T) {
+	for _, name := range []string{"default", "baseline", "musl", "musl-baseline"} {
+		v, err := ParseVariant(name)
+		if err != nil {
+			t.Errorf("ParseVariant(%q) unexpected error: %v", name, err)
+		}
+		if name != "default" && string(v) != name {
+			t.Errorf("ParseVariant(%q) = %q", name, v)
+		}
+	}
+
+	if v, _ := ParseVariant("default"); v != VariantDefault {
+		t.Errorf("ParseVariant(default) = %q, want default build", v)
+	}
+	if _, err := ParseVariant("glibc-avx512"); err == nil {
+		t.Error("expected error for unknown variant")
+	}
+}
+
+func TestVariant_assetName(t *testing.T) {
+	tests := []struct {
+		variant Variant
+		goos    string
+		goarch  string
+		want    string
+	}{
+		{VariantDefault, "linux", "amd64", "bun-linux-x64.zip"},
+		{VariantDefault, "darwin", "arm64", "bun-darwin-aarch64.zip"},
+		{VariantBaseline, "linux", "amd64", "bun-linux-x64-baseline.zip"},
+		{VariantMusl, "linux", "arm64", "bun-linux-aarch64-musl.zip"},
+		{VariantMuslBaseline, "linux", "amd64", "bun-linux-x64-musl-baseline.zip"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.variant.assetName(tt.goos, tt.goarch); got != tt.want {
+			t.Errorf("%q.assetName(%s, %s) = %s, want %s", tt.variant, tt.goos, tt.goarch, got, tt.want)
+		}
+	}
+}
+
+func TestCachedSource_keeps_variants_separate(t *testing.T) {
+	cacheDir := t.TempDir()
+	for _, name := range []string{"1.1.34", "1.1.33+musl", "canary+musl"} {
+		dir := filepath.Join(cacheDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		for _, f := range []string{"bun", ChecksumFile} {
+			if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", f, err)
+			}
+		}
+	}
+
+	musl := NewCachedSource(cacheDir, VariantMusl)
+	versions, err := musl.GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Original() != "1.1.33" {
+		t.Errorf("musl versions = %v, want [1.1.33]", versions)
+	}
+	if channels, _ := musl.GetChannels(); len(channels) != 1 {
+		t.Errorf("musl channels = %v, want [canary]", channels)
+	}
+
+	glibc := NewCachedSource(cacheDir, VariantDefault)
+	versions, err = glibc.GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Original() != "1.1.34" {
+		t.Errorf("default versions = %v, want [1.1.34]", versions)
+	}
+	if channels, _ := glibc.GetChannels(); len(channels) != 0 {
+		t.Errorf("default channels = %v, want none", channels)
+	}
+}
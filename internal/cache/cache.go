@@ -3,11 +3,13 @@ package cache
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Cache manages the buns cache directory
@@ -80,6 +82,75 @@ func HashPackages(packages []string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// SamePackages reports whether two package lists contain the same specs,
+// ignoring order and case
+func SamePackages(a, b []string) bool {
+	return HashPackages(a) == HashPackages(b)
+}
+
+// DepsManifestFile is the name of the manifest written into each deps directory
+const DepsManifestFile = "buns-deps.json"
+
+// ResolutionTTL is how long a deps directory's resolved versions are reused
+// for the constraints they satisfy before the registry is asked again
+const ResolutionTTL = 24 * time.Hour
+
+// DepsManifest records the package specs a deps directory was installed for,
+// the exact versions they resolved to and when they were last resolved
+type DepsManifest struct {
+	Requested  []string  `json:"requested"`
+	Resolved   []string  `json:"resolved"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// WriteDepsManifest writes the manifest into a deps directory
+func WriteDepsManifest(dir string, m *DepsManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, DepsManifestFile), data, 0644)
+}
+
+// ReadDepsManifest reads the manifest from a deps directory
+func ReadDepsManifest(dir string) (*DepsManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, DepsManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var m DepsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// FindDeps returns the first installed deps directory whose manifest matches.
+// Returns an empty path and nil manifest if none match.
+func (c *Cache) FindDeps(match func(*DepsManifest) bool) (string, *DepsManifest) {
+	hashes, err := c.ListDepsHashes()
+	if err != nil {
+		return "", nil
+	}
+
+	for _, hash := range hashes {
+		if !c.IsDepsHit(hash) {
+			continue
+		}
+		dir := c.DepsDirForHash(hash)
+		m, err := ReadDepsManifest(dir)
+		if err != nil {
+			continue
+		}
+		if match(m) {
+			return dir, m
+		}
+	}
+
+	return "", nil
+}
+
 // IsDepsHit checks if dependencies are cached for the given hash
 func (c *Cache) IsDepsHit(hash string) bool {
 	return IsPackageInstallHit(c.DepsDirForHash(hash))
@@ -159,7 +230,7 @@ func (c *Cache) ListBunVersions() ([]string, error) {
 
 	var versions []string
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && !isTempDir(entry.Name()) {
 			versions = append(versions, entry.Name())
 		}
 	}
@@ -187,13 +258,18 @@ func listDirNames(dir string) ([]string, error) {
 
 	var hashes []string
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && !isTempDir(entry.Name()) {
 			hashes = append(hashes, entry.Name())
 		}
 	}
 	return hashes, nil
 }
 
+// isTempDir reports whether a cache entry is an in-progress install from Populate
+func isTempDir(name string) bool {
+	return strings.HasPrefix(name, ".tmp-")
+}
+
 // Size returns the total size of the cache in bytes
 func (c *Cache) Size() (int64, error) {
 	var size int64
@@ -216,3 +216,41 @@ func TestCache_ListBunVersions(t *testing.T) {
 		t.Errorf("expected 2 versions, got %d", len(versions))
 	}
 }
+
+func TestCache_FindDeps(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := New(tmpDir)
+
+	dir := c.DepsDirForHash(HashPackages([]string{"zod@3.24.1"}))
+	os.MkdirAll(filepath.Join(dir, "node_modules", "zod"), 0755)
+	manifest := &DepsManifest{Requested: []string{"zod@^3.0"}, Resolved: []string{"zod@3.24.1"}}
+	if err := WriteDepsManifest(dir, manifest); err != nil {
+		t.Fatalf("WriteDepsManifest failed: %v", err)
+	}
+
+	t.Run("finds matching manifest", func(t *testing.T) {
+		found, m := c.FindDeps(func(m *DepsManifest) bool {
+			return SamePackages(m.Requested, []string{"ZOD@^3.0"})
+		})
+
+		if m == nil {
+			t.Fatal("expected a match")
+		}
+		if found != dir {
+			t.Errorf("dir = %s, want %s", found, dir)
+		}
+		if m.Resolved[0] != "zod@3.24.1" {
+			t.Errorf("resolved = %v, want [zod@3.24.1]", m.Resolved)
+		}
+	})
+
+	t.Run("returns nil when nothing matches", func(t *testing.T) {
+		_, m := c.FindDeps(func(m *DepsManifest) bool {
+			return SamePackages(m.Requested, []string{"chalk@^5.0"})
+		})
+
+		if m != nil {
+			t.Errorf("got %+v, want nil", m)
+		}
+	})
+}
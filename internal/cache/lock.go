@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock takes an exclusive advisory lock on path (using a sibling path+".lock"
+// file), blocking until it is available. Call the returned function to release it.
+// Locks coordinate separate buns processes sharing the same cache.
+//
+// The lock file is removed on release, so they don't pile up next to every
+// cached directory. A process that was waiting on the removed file retries
+// with a new one, so no two processes hold the lock at once.
+func Lock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	lockPath := path + ".lock"
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := flock(f, syscall.LOCK_EX); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		// The holder before us may have removed the file we locked
+		if !isLockFile(f, lockPath) {
+			_ = f.Close()
+			continue
+		}
+
+		return func() {
+			_ = os.Remove(lockPath)
+			_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+			_ = f.Close()
+		}, nil
+	}
+}
+
+// flock applies how to f, retrying when interrupted by a signal
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// isLockFile reports whether f is still the file at lockPath
+func isLockFile(f *os.File, lockPath string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(lockPath)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// Populate fills dir exactly once, even when several processes race to do it.
+// Under an exclusive lock on dir it returns early if isDone reports dir is
+// already complete. Otherwise fill runs against a fresh temporary sibling
+// directory, which is renamed into place only if fill succeeds, so readers
+// never observe a half-populated dir. A dir being replaced is renamed aside
+// first and removed once the new one is in place.
+func Populate(dir string, isDone func(dir string) bool, fill func(tmpDir string) error) error {
+	unlock, err := Lock(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if isDone(dir) {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-"+filepath.Base(dir)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := fill(tmpDir); err != nil {
+		return err
+	}
+
+	// Move aside what's being replaced, such as a canary build or a
+	// directory left incomplete, rather than removing it before the new one
+	// is in place. Its name marks it as temporary, so listings skip it.
+	old := filepath.Join(filepath.Dir(dir), fmt.Sprintf(".tmp-%s-old-%d", filepath.Base(dir), os.Getpid()))
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(old) }()
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		_ = os.Rename(old, dir)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Helper commands the test binary runs as, to race separate processes
+const (
+	lockHelper     = "__lock"     // __lock <path> <log>
+	populateHelper = "__populate" // __populate <dir> <log>
+)
+
+// TestMain lets the test binary act as the helper commands
+func TestMain(m *testing.M) {
+	if len(os.Args) == 4 {
+		helpers := map[string]func(target, log string) error{
+			lockHelper:     holdLock,
+			populateHelper: populateOnce,
+		}
+		if run, ok := helpers[os.Args[1]]; ok {
+			if err := run(os.Args[2], os.Args[3]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// holdLock appends "+" to log on taking the lock on path and "-" before
+// releasing it, so overlapping holders show up as "++"
+func holdLock(path, log string) error {
+	unlock, err := Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := appendFile(log, "+"); err != nil {
+		return err
+	}
+	time.Sleep(20 * time.Millisecond)
+	return appendFile(log, "-")
+}
+
+// populateOnce populates dir, appending "fill" to log when it fills it
+func populateOnce(dir, log string) error {
+	isDone := func(dir string) bool {
+		_, err := os.Stat(filepath.Join(dir, "done"))
+		return err == nil
+	}
+	return Populate(dir, isDone, func(tmpDir string) error {
+		if err := appendFile(log, "fill\n"); err != nil {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+		return os.WriteFile(filepath.Join(tmpDir, "done"), nil, 0644)
+	})
+}
+
+func appendFile(path, s string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(s)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// raceProcesses runs n copies of the test binary as helper at once and
+// returns what they appended to the log
+func raceProcesses(t *testing.T, n int, helper, target string) string {
+	t.Helper()
+	log := filepath.Join(t.TempDir(), "log")
+
+	cmds := make([]*exec.Cmd, n)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], helper, target, log)
+		cmds[i].Stderr = os.Stderr
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("%s failed: %v", helper, err)
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLock_serializes_processes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deps", "abc")
+
+	got := raceProcesses(t, 8, lockHelper, path)
+	if want := strings.Repeat("+-", 8); got != want {
+		t.Errorf("log = %q, want %q (holders overlapped)", got, want)
+	}
+
+	// Released locks leave no files behind
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind, err=%v", err)
+	}
+}
+
+func TestPopulate_fills_directory_once_across_processes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "deps", "abc")
+
+	if got := raceProcesses(t, 8, populateHelper, dir); got != "fill\n" {
+		t.Errorf("fills = %q, want one", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "done")); err != nil {
+		t.Errorf("directory was not populated: %v", err)
+	}
+}
+
+func TestPopulate_fills_directory_once_under_concurrency(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "deps", "abc")
+	isDone := func(dir string) bool {
+		_, err := os.Stat(filepath.Join(dir, "done"))
+		return err == nil
+	}
+
+	var fills atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Populate(dir, isDone, func(tmpDir string) error {
+				fills.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				return os.WriteFile(filepath.Join(tmpDir, "done"), nil, 0644)
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := fills.Load(); got != 1 {
+		t.Errorf("fill ran %d times, want 1", got)
+	}
+	if !isDone(dir) {
+		t.Error("directory was not populated")
+	}
+}
+
+func TestPopulate_leaves_nothing_behind_on_failure(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "abc")
+
+	err := Populate(dir, func(string) bool { return false }, func(tmpDir string) error {
+		_ = os.WriteFile(filepath.Join(tmpDir, "partial"), nil, 0644)
+		return errors.New("install failed")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory should not exist after failed fill, got err=%v", err)
+	}
+	names, err := listDirNames(parent)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("leftover directories: %v", names)
+	}
+}
+
+func TestPopulate_replaces_incomplete_directory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "abc")
+	if err := os.MkdirAll(filepath.Join(dir, "node_modules"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	err := Populate(dir, IsPackageInstallHit, func(tmpDir string) error {
+		return os.MkdirAll(filepath.Join(tmpDir, "node_modules", "zod"), 0755)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsPackageInstallHit(dir) {
+		t.Error("expected directory to be a package install hit")
+	}
+}
+
+func TestPopulate_swaps_in_a_replacement_directory(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "canary")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bun"), []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Populate(dir, func(string) bool { return false }, func(tmpDir string) error {
+		// The previous build stays usable while the new one is fetched
+		if _, err := os.Stat(filepath.Join(dir, "bun")); err != nil {
+			t.Errorf("old build gone during fill: %v", err)
+		}
+		return os.WriteFile(filepath.Join(tmpDir, "bun"), []byte("new"), 0755)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "bun"))
+	if err != nil || string(data) != "new" {
+		t.Errorf("bun = %q, %v; want the new build", data, err)
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("entries = %v, want only the new directory", names)
+	}
+}
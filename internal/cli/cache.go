@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/eddmann/buns/internal/bun"
 	"github.com/eddmann/buns/internal/cache"
 	"github.com/spf13/cobra"
 )
@@ -55,7 +57,7 @@ var cacheListCmd = &cobra.Command{
 		}
 
 		fmt.Println("\nDependency caches:")
-		printHashes(hashes)
+		printDepsCaches(c, hashes)
 
 		typeHashes, err := c.ListTypecheckHashes()
 		if err != nil && !os.IsNotExist(err) {
@@ -152,6 +154,49 @@ var cacheCleanCmd = &cobra.Command{
 	},
 }
 
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Re-verify cached Bun binaries against their recorded checksums",
+	Long: `Re-compute the SHA-256 of every cached Bun binary and compare it with the
+digest recorded when the release was downloaded and verified, as every run
+does for the binary it uses.
+
+Downloads are verified against the release's SHASUMS256.txt from the same
+mirror, whose signature is not checked. This catches corrupt downloads and
+cached binaries changed on disk, not a mirror serving a doctored release.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := cache.Default()
+		if err != nil {
+			return err
+		}
+
+		versions, err := c.ListBunVersions()
+		if err != nil {
+			return err
+		}
+
+		if len(versions) == 0 {
+			fmt.Println("No cached Bun binaries.")
+			return nil
+		}
+
+		failed := 0
+		for _, v := range versions {
+			if err := bun.VerifyBinary(filepath.Join(c.BunDir(), v)); err != nil {
+				fmt.Printf("  %s: %v\n", v, err)
+				failed++
+				continue
+			}
+			fmt.Printf("  %s: ok\n", v)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d Bun binary(s) failed verification; run 'buns cache clean --bun' to re-download", failed)
+		}
+		return nil
+	},
+}
+
 var cacheDirCmd = &cobra.Command{
 	Use:   "dir",
 	Short: "Print cache directory path",
@@ -174,6 +219,7 @@ func init() {
 
 	cacheCmd.AddCommand(cacheListCmd)
 	cacheCmd.AddCommand(cacheCleanCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
 	cacheCmd.AddCommand(cacheDirCmd)
 	rootCmd.AddCommand(cacheCmd)
 }
@@ -193,6 +239,21 @@ func printHashes(hashes []string) {
 	}
 }
 
+// printDepsCaches prints each deps hash with the exact packages installed in it
+func printDepsCaches(c *cache.Cache, hashes []string) {
+	if len(hashes) == 0 {
+		printHashes(nil)
+		return
+	}
+
+	for _, h := range hashes {
+		printHashes([]string{h})
+		if m, err := cache.ReadDepsManifest(c.DepsDirForHash(h)); err == nil {
+			fmt.Printf("    %s\n", strings.Join(m.Resolved, ", "))
+		}
+	}
+}
+
 func formatSize(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"github.com/eddmann/buns/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// Helper commands that sandboxes run buns as. They aren't meant to be run by
+// hand, and report their own errors.
+
+// landlockCmd restricts itself before starting the script
+var landlockCmd = &cobra.Command{
+	Use:                sandbox.LandlockHelper + " <policy> -- <command>",
+	Hidden:             true,
+	DisableFlagParsing: true,
+	SilenceUsage:       true,
+	SilenceErrors:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sandbox.RunLandlockHelper(args)
+	},
+}
+
+// bridgeCmd relays the proxy into the script's network namespace
+var bridgeCmd = &cobra.Command{
+	Use:                sandbox.BridgeHelper + " <socket> -- <command>",
+	Hidden:             true,
+	DisableFlagParsing: true,
+	SilenceUsage:       true,
+	SilenceErrors:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sandbox.RunBridgeHelper(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(landlockCmd)
+	rootCmd.AddCommand(bridgeCmd)
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/eddmann/buns/internal/cache"
+	"github.com/eddmann/buns/internal/config"
+	"github.com/eddmann/buns/internal/exec"
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock <script.ts>",
+	Short: "Pin a script's Bun version and packages in a lock file",
+	Long: `Resolve the Bun version and every package declared in a script's // buns
+block to exact versions, install them, and write them with the lockfile Bun
+produced, which pins every transitive dependency, to a sidecar lock file
+(script.ts.lock).
+
+When a lock file exists, "buns run" replays Bun's lockfile, so it installs the
+same tree and checks each tarball's integrity, and fails if the script's
+metadata no longer matches the lock. --bun and --packages override the pins.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := cache.Default()
+		if err != nil {
+			return err
+		}
+
+		if err := c.EnsureDirs(); err != nil {
+			return err
+		}
+
+		settings, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		runner, err := exec.NewRunner(c, settings, verbose, quiet)
+		if err != nil {
+			return err
+		}
+
+		l, lockPath, err := runner.Lock(args[0])
+		if err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("Locked bun %s", l.Bun.Version)
+			if len(l.Packages) > 0 {
+				fmt.Printf(" and %d package(s)", len(l.Packages))
+			}
+			fmt.Printf(" in %s\n", lockPath)
+			for _, p := range l.Packages {
+				fmt.Printf("  %s -> %s\n", p.Spec, p.Version)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+}
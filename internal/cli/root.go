@@ -43,7 +43,7 @@ Example:
 			return cmd.Help()
 		}
 		// Default behavior: buns script.ts → buns run script.ts
-		return runScript(args[0], args[1:])
+		return runScript(cmd, args[0], args[1:])
 	},
 }
 
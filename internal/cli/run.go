@@ -1,32 +1,45 @@
 package cli
 
 import (
-	"fmt"
 	"os"
 	"runtime"
 	"strings"
 
 	"github.com/eddmann/buns/internal/cache"
+	"github.com/eddmann/buns/internal/config"
 	"github.com/eddmann/buns/internal/exec"
-	"github.com/eddmann/buns/internal/sandbox"
+	"github.com/eddmann/buns/internal/metadata"
+	"github.com/eddmann/buns/internal/proxy"
 	"github.com/spf13/cobra"
 )
 
 var (
 	bunVersion  string
+	bunVariant  string
 	packagesArg string
 	typeCheck   bool
+	cacheOnly   bool
+	statsFormat string
 
 	// Sandbox flags
 	sandboxEnabled bool
+	sandboxBackend string
 	offline        bool
 	allowHostsArg  string
+	denyHostsArg   string
 	allowReadArg   string
 	allowWriteArg  string
 	allowEnvArg    string
+	promptHosts    bool
+	auditLogPath   string
+	learnPolicy    bool
+	learnOutput    string
 	memoryLimit    int
 	timeoutSecs    int
+	graceSecs      int
 	cpuLimit       int
+	cpuCores       float64
+	maxProcs       int
 )
 
 var runCmd = &cobra.Command{
@@ -46,16 +59,74 @@ The script can declare dependencies in a // buns comment block:
 
 Use "-" to read from stdin.
 
+Use --cache-only (or BUNS_OFFLINE=1) to run without any network access from buns
+itself: Bun is resolved only against downloaded binaries and packages only against
+cached installs. Anything missing is reported before the script runs.
+
+Use --stats to print the time, CPU, memory, disk and network a run used to stderr
+once it finishes, or --stats=json for a JSON object. This helps pick --memory and
+--timeout values.
+
 Security options:
     --sandbox          Enable sandboxing (restricts filesystem access)
+    --sandbox-backend  Sandbox to use on Linux: bwrap, nsjail, landlock or unshare
     --offline          Block all network access
     --allow-host       Allow network to specific hosts (comma-separated)
+    --deny-host        Block hosts even when allowed (comma-separated)
     --allow-read       Allow reading additional paths (comma-separated)
     --allow-write      Allow writing to additional paths (comma-separated)
-    --allow-env        Pass through environment variables (comma-separated)`,
+    --allow-env        Pass through environment variables (comma-separated)
+    --prompt           Ask before allowing other hosts (y = this run, always = save to script)
+    --audit-log        Record network access as JSON lines to a file ("-" for stderr)
+    --learn            Run permissively and print a sandbox policy from what the script used
+    --learn-output     Write the learned policy to a TOML file instead (implies --learn)
+
+A script can declare the same settings in a [sandbox] table of its // buns block:
+
+    // [sandbox]
+    // offline = false
+    // allow-host = ["api.github.com"]
+    // allow-env = ["GITHUB_TOKEN"]
+
+Flags given on the command line take precedence over the script, which takes
+precedence over the defaults. Allow-lists given as flags replace the script's, so
+a script can't grant itself more than the flags allow; deny-lists from both are
+combined.
+
+Host rules are names (api.github.com), wildcards (*.github.com), IPs or CIDR blocks
+(10.0.0.0/8), optionally limited to ports (api.github.com:443, localhost:8000-8999).
+Deny rules win over allow rules. Link-local and cloud metadata addresses, and
+internal addresses reached through an allowed name, are blocked unless an IP or
+CIDR rule allows them.
+
+--learn runs the script once with every host reachable and the whole environment
+passed through, then suggests a [sandbox] policy: the hosts and ports contacted,
+environment variables the script reads and, on Linux with strace installed, the
+paths read and written. Review it before adding it to the script.
+
+On Linux, buns picks bwrap, then nsjail, then landlock for --sandbox, and unshare
+when only the network is restricted. --sandbox-backend picks one instead and fails
+if it isn't available; unshare can't be used with --sandbox. Without user
+namespaces, landlock limits network access by port number only, on any address.
+Run "buns sandbox doctor" to see which backends work on this system and what each
+one enforces.
+
+On Linux with cgroups v2, sandboxed runs are limited by the kernel: --memory,
+--cpus (CPU cores) and --max-procs (processes and threads, by default 64 per CPU
+and at least 256) apply to every process the script starts, and --cpu is
+enforced across them. nsjail also limits --memory and --cpu per process with
+rlimits.
+
+When --timeout expires, the script's processes get SIGTERM and, if still running
+after --grace-period seconds (default 5), SIGKILL. Ctrl-C and SIGTERM sent to
+buns are passed on to every process of the script.
+
+Runs that don't end by themselves exit with a status of their own: 122 when killed
+for exceeding --memory, 123 for exceeding --cpu, 124 when --timeout expires, 125
+when the sandbox can't be set up, and 128+N when killed by signal N.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runScript(args[0], args[1:])
+		return runScript(cmd, args[0], args[1:])
 	},
 }
 
@@ -69,27 +140,40 @@ func init() {
 // `buns script.ts --sandbox` and `buns run script.ts --sandbox`.
 func addRunFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&bunVersion, "bun", "", "bun version constraint (overrides script)")
+	cmd.Flags().StringVar(&bunVariant, "bun-variant", "", "Bun build: auto, default, baseline, musl or musl-baseline")
 	cmd.Flags().StringVar(&packagesArg, "packages", "", "comma-separated packages to add")
 	cmd.Flags().BoolVar(&typeCheck, "typecheck", false, "run TypeScript type checking before execution")
+	cmd.Flags().BoolVar(&cacheOnly, "cache-only", os.Getenv("BUNS_OFFLINE") != "", "resolve Bun and packages from the local cache only (no downloads)")
+	cmd.Flags().StringVar(&statsFormat, "stats", "", "print resources used after the run: text or json (--stats=json)")
+	cmd.Flags().Lookup("stats").NoOptDefVal = exec.StatsText
 
 	// Sandbox flags
 	cmd.Flags().BoolVar(&sandboxEnabled, "sandbox", false, "enable sandboxing")
+	cmd.Flags().StringVar(&sandboxBackend, "sandbox-backend", "", "sandbox backend: bwrap, nsjail, landlock or unshare (default: detect)")
 	cmd.Flags().BoolVar(&offline, "offline", false, "block all network access")
-	cmd.Flags().StringVar(&allowHostsArg, "allow-host", "", "allowed hosts (comma-separated)")
+	cmd.Flags().StringVar(&allowHostsArg, "allow-host", "", "allowed hosts, IPs or CIDR blocks, with optional :ports (comma-separated)")
+	cmd.Flags().StringVar(&denyHostsArg, "deny-host", "", "blocked hosts, IPs or CIDR blocks, with optional :ports (comma-separated)")
 	cmd.Flags().StringVar(&allowReadArg, "allow-read", "", "additional readable paths (comma-separated)")
 	cmd.Flags().StringVar(&allowWriteArg, "allow-write", "", "additional writable paths (comma-separated)")
 	cmd.Flags().StringVar(&allowEnvArg, "allow-env", "", "environment variables to pass (comma-separated)")
-	cmd.Flags().IntVar(&memoryLimit, "memory", 128, "memory limit in MB")
-	cmd.Flags().IntVar(&timeoutSecs, "timeout", 30, "execution timeout in seconds")
+	cmd.Flags().BoolVar(&promptHosts, "prompt", false, "ask on the terminal before allowing hosts outside --allow-host")
+	cmd.Flags().StringVar(&auditLogPath, "audit-log", "", "record network access as JSON lines to a file (\"-\" for stderr)")
+	cmd.Flags().BoolVar(&learnPolicy, "learn", false, "run permissively and suggest a sandbox policy from what the script used")
+	cmd.Flags().StringVar(&learnOutput, "learn-output", "", "write the learned sandbox policy to a TOML file (implies --learn)")
+	cmd.Flags().IntVar(&memoryLimit, "memory", exec.DefaultMemoryMB, "memory limit in MB")
+	cmd.Flags().IntVar(&timeoutSecs, "timeout", exec.DefaultTimeoutSecs, "execution timeout in seconds")
+	cmd.Flags().IntVar(&graceSecs, "grace-period", exec.DefaultGraceSecs, "seconds between SIGTERM and SIGKILL when the timeout expires")
 
-	// CPU limit only available on Linux (requires nsjail for enforcement)
+	// CPU and process limits are enforced through cgroups, which only Linux has
 	if runtime.GOOS == "linux" {
-		cmd.Flags().IntVar(&cpuLimit, "cpu", 30, "CPU time limit in seconds (Linux only)")
+		cmd.Flags().IntVar(&cpuLimit, "cpu", exec.DefaultCPUSeconds, "CPU time limit in seconds (Linux only)")
+		cmd.Flags().Float64Var(&cpuCores, "cpus", 0, "CPU cores the script may use, e.g. 0.5 (Linux only, 0 = unlimited)")
+		cmd.Flags().IntVar(&maxProcs, "max-procs", exec.DefaultMaxProcs(), "processes and threads the script may run at once (Linux only, 0 = unlimited)")
 	}
 }
 
 // runScript executes a script with its dependencies
-func runScript(script string, args []string) error {
+func runScript(cmd *cobra.Command, script string, args []string) error {
 	// Get cache
 	c, err := cache.Default()
 	if err != nil {
@@ -110,60 +194,33 @@ func runScript(script string, args []string) error {
 		}
 	}
 
-	// Parse sandbox options
-	var allowHosts, allowRead, allowWrite, allowEnv []string
-
-	if allowHostsArg != "" {
-		allowHosts = splitAndTrim(allowHostsArg)
-	}
-	if allowReadArg != "" {
-		allowRead = splitAndTrim(allowReadArg)
-	}
-	if allowWriteArg != "" {
-		allowWrite = splitAndTrim(allowWriteArg)
-	}
-	if allowEnvArg != "" {
-		allowEnv = splitAndTrim(allowEnvArg)
-	}
-
-	// Determine sandbox
-	var sb sandbox.Sandbox = &sandbox.None{}
-	if sandboxEnabled {
-		sb = sandbox.Detect(true)
-		if !sb.IsSandboxed() {
-			return fmt.Errorf("--sandbox requested but no sandbox is available on this system")
-		}
-	} else if offline || len(allowHosts) > 0 {
-		sb = sandbox.Detect(false)
-		if !sb.IsSandboxed() {
-			return fmt.Errorf("--offline/--allow-host requires network sandboxing, but no sandbox is available on this system")
-		}
+	settings, err := config.Load()
+	if err != nil {
+		return err
 	}
 
-	// Determine network access
-	network := !offline
-
 	// Create runner
-	runner := exec.NewRunner(c, verbose, quiet)
+	runner, err := exec.NewRunner(c, settings, verbose, quiet)
+	if err != nil {
+		return err
+	}
 
 	// Run the script
 	exitCode, err := runner.Run(exec.RunOptions{
 		Script:        script,
 		Args:          args,
 		BunConstraint: bunVersion,
+		BunVariant:    bunVariant,
 		ExtraPackages: extraPackages,
 		TypeCheck:     typeCheck,
-
-		// Sandbox options
-		Sandbox:     sb,
-		Network:     network,
-		AllowHosts:  allowHosts,
-		AllowRead:   allowRead,
-		AllowWrite:  allowWrite,
-		AllowEnv:    allowEnv,
-		MemoryMB:    memoryLimit,
-		TimeoutSecs: timeoutSecs,
-		CPUSeconds:  cpuLimit,
+		CacheOnly:     cacheOnly,
+		Stats:         statsFormat,
+		Backend:       sandboxBackend,
+		Prompt:        promptHosts,
+		AuditLog:      auditLogPath,
+		Learn:         learnPolicy || learnOutput != "",
+		LearnOutput:   learnOutput,
+		Sandbox:       sandboxFlags(cmd),
 	})
 
 	if err != nil {
@@ -177,6 +234,46 @@ func runScript(script string, args []string) error {
 	return nil
 }
 
+// sandboxFlags collects the sandbox flags given explicitly on the command line.
+// Flags left unset fall back to the script's [sandbox] table.
+func sandboxFlags(cmd *cobra.Command) metadata.Sandbox {
+	flags := cmd.Flags()
+	var sb metadata.Sandbox
+
+	if flags.Changed("sandbox") {
+		sb.Enabled = &sandboxEnabled
+	}
+	if flags.Changed("offline") {
+		sb.Offline = &offline
+	}
+	if flags.Changed("memory") {
+		sb.Memory = &memoryLimit
+	}
+	if flags.Changed("timeout") {
+		sb.Timeout = &timeoutSecs
+	}
+	if flags.Changed("grace-period") {
+		sb.GracePeriod = &graceSecs
+	}
+	if flags.Changed("cpu") {
+		sb.CPU = &cpuLimit
+	}
+	if flags.Changed("cpus") {
+		sb.CPUs = &cpuCores
+	}
+	if flags.Changed("max-procs") {
+		sb.MaxProcs = &maxProcs
+	}
+
+	sb.AllowHost = proxy.SplitRules(allowHostsArg)
+	sb.DenyHost = proxy.SplitRules(denyHostsArg)
+	sb.AllowRead = splitAndTrim(allowReadArg)
+	sb.AllowWrite = splitAndTrim(allowWriteArg)
+	sb.AllowEnv = splitAndTrim(allowEnvArg)
+
+	return sb
+}
+
 // splitAndTrim splits a comma-separated string and trims whitespace
 func splitAndTrim(s string) []string {
 	parts := strings.Split(s, ",")
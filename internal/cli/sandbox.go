@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/eddmann/buns/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+var sandboxCmd = &cobra.Command{
+	Use:   "sandbox",
+	Short: "Inspect the sandbox backends",
+}
+
+var sandboxDoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check which sandbox backends work on this system",
+	Long: `Probe each sandbox backend (bwrap, nsjail, landlock and unshare): whether
+its binary is installed, user namespaces work and cgroups v2 are delegated to
+this user. Then show what each usable backend enforces on this system:
+filesystem, network, memory and CPU isolation.
+
+Pick one with --sandbox-backend.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for i, d := range sandbox.Diagnose() {
+			if i > 0 {
+				fmt.Println()
+			}
+			status := "usable"
+			if !d.Usable {
+				status = "not usable"
+			}
+			fmt.Printf("%s: %s\n", d.Backend, status)
+
+			for _, c := range d.Checks {
+				mark := "ok"
+				if !c.OK {
+					mark = "--"
+				}
+				fmt.Printf("  [%s] %-22s %s\n", mark, c.Name, c.Detail)
+			}
+			if !d.Usable {
+				continue
+			}
+			for _, g := range d.Isolation {
+				enforced := "enforced"
+				if !g.Enforced {
+					enforced = "not enforced"
+				}
+				fmt.Printf("  %-11s %-13s %s\n", g.Kind, enforced, g.Detail)
+			}
+		}
+
+		fmt.Println()
+		fmt.Printf("Used for --sandbox: %s\n", sandbox.Detect(true).Name())
+		fmt.Printf("Used for network restrictions only: %s\n", sandbox.Detect(false).Name())
+		if runtime.GOOS != "linux" {
+			fmt.Println("The backends above are Linux only; other systems use their own sandbox.")
+		}
+		return nil
+	},
+}
+
+func init() {
+	sandboxCmd.AddCommand(sandboxDoctorCmd)
+	rootCmd.AddCommand(sandboxCmd)
+}
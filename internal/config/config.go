@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/eddmann/buns/internal/bun"
+	"github.com/eddmann/buns/internal/index"
+	"github.com/eddmann/buns/internal/npm"
+)
+
+// Config holds user settings from the buns config file
+type Config struct {
+	Bun BunConfig `toml:"bun"`
+	Npm NpmConfig `toml:"npm"`
+}
+
+// BunConfig configures where Bun releases are listed and downloaded from
+type BunConfig struct {
+	// ReleasesURL replaces the GitHub releases API endpoint used to list versions
+	ReleasesURL string `toml:"releases_url"`
+
+	// VersionsURL points at a mirror-hosted JSON version listing, used instead of ReleasesURL
+	VersionsURL string `toml:"versions_url"`
+
+	// DownloadURLs are mirror base URLs or URL templates, tried in order
+	DownloadURLs []string `toml:"download_urls"`
+
+	// Variant selects the Bun build: auto (default), default, baseline, musl or musl-baseline
+	Variant string `toml:"variant"`
+}
+
+// NpmConfig configures the npm registry used to resolve and install packages
+type NpmConfig struct {
+	Registry string `toml:"registry"`
+	Token    string `toml:"token"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// Scopes maps a package scope (e.g. "@ourco") to its own registry
+	Scopes map[string]NpmScope `toml:"scopes"`
+}
+
+// NpmScope configures the registry for a single package scope
+type NpmScope struct {
+	Registry string `toml:"registry"`
+	Token    string `toml:"token"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// Path returns the config file location: $BUNS_CONFIG, or
+// $XDG_CONFIG_HOME/buns/config.toml (default ~/.config/buns/config.toml)
+func Path() (string, error) {
+	if path := os.Getenv("BUNS_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "buns", "config.toml"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "buns", "config.toml"), nil
+}
+
+// Load reads the config file from the default location
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads a config file. Returns an empty config if the file does not exist.
+func LoadFile(path string) (*Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// NpmrcPath returns the user's .npmrc location ($NPM_CONFIG_USERCONFIG or ~/.npmrc)
+func NpmrcPath() (string, error) {
+	if path := os.Getenv("NPM_CONFIG_USERCONFIG"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".npmrc"), nil
+}
+
+// ReleasesURL returns the endpoint used to list Bun releases: $BUNS_RELEASES_URL,
+// then the config file, then the GitHub releases API
+func (c *Config) ReleasesURL() string {
+	if u := os.Getenv("BUNS_RELEASES_URL"); u != "" {
+		return u
+	}
+	if c.Bun.ReleasesURL != "" {
+		return c.Bun.ReleasesURL
+	}
+	return index.GitHubReleasesURL
+}
+
+// VersionsURL returns the mirror-hosted version listing, if any:
+// $BUNS_VERSIONS_URL, then the config file
+func (c *Config) VersionsURL() string {
+	if u := os.Getenv("BUNS_VERSIONS_URL"); u != "" {
+		return u
+	}
+	return c.Bun.VersionsURL
+}
+
+// Index returns the Bun version index, reading from the mirror listing when
+// one is configured and the releases API otherwise
+func (c *Config) Index(cacheDir string) *index.Index {
+	if u := c.VersionsURL(); u != "" {
+		return index.NewWithListing(cacheDir, u)
+	}
+	return index.NewWithURL(cacheDir, c.ReleasesURL())
+}
+
+// DownloadURLs returns the Bun download mirrors in the order they are tried:
+// $BUNS_DOWNLOAD_URL (comma-separated), then the config file, then GitHub releases
+func (c *Config) DownloadURLs() []string {
+	if u := os.Getenv("BUNS_DOWNLOAD_URL"); u != "" {
+		return strings.Split(u, ",")
+	}
+	if len(c.Bun.DownloadURLs) > 0 {
+		return c.Bun.DownloadURLs
+	}
+	return []string{bun.ReleasesURL}
+}
+
+// BunVariant returns the requested Bun build variant: $BUNS_BUN_VARIANT, then
+// the config file. Empty means detect it for this machine.
+func (c *Config) BunVariant() string {
+	if v := os.Getenv("BUNS_BUN_VARIANT"); v != "" {
+		return v
+	}
+	return c.Bun.Variant
+}
+
+// Registry builds the npm registry configuration. Settings come from the
+// public registry defaults, then the given .npmrc, then the buns config file.
+// ${VAR} references in credentials are expanded from the environment.
+func (c *Config) Registry(npmrcPath string) (*npm.Config, error) {
+	result := npm.DefaultConfig()
+
+	if npmrcPath != "" {
+		npmrc, err := npm.LoadNpmrc(npmrcPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", npmrcPath, err)
+		}
+		result.Merge(npmrc)
+	}
+
+	overrides := &npm.Config{
+		Registry: npm.RegistryConfig{
+			URL:      c.Npm.Registry,
+			Token:    os.ExpandEnv(c.Npm.Token),
+			Username: os.ExpandEnv(c.Npm.Username),
+			Password: os.ExpandEnv(c.Npm.Password),
+		},
+		Scopes: map[string]npm.RegistryConfig{},
+	}
+	for scope, s := range c.Npm.Scopes {
+		// Credentials are only ever sent to the registry they're configured with
+		if s.Registry == "" && (s.Token != "" || s.Username != "" || s.Password != "") {
+			return nil, fmt.Errorf("npm scope %s has credentials but no registry", scope)
+		}
+		overrides.Scopes[scope] = npm.RegistryConfig{
+			URL:      s.Registry,
+			Token:    os.ExpandEnv(s.Token),
+			Username: os.ExpandEnv(s.Username),
+			Password: os.ExpandEnv(s.Password),
+		}
+	}
+	result.Merge(overrides)
+
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFile_returns_empty_config_when_missing(t *testing.T) {
+	cfg, err := LoadFile(filepath.Join(t.TempDir(), "missing.toml"))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Npm.Registry != "" {
+		t.Errorf("registry = %q, want empty", cfg.Npm.Registry)
+	}
+}
+
+func TestConfig_Registry(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("OURCO_TOKEN", "from-env")
+
+	npmrcPath := filepath.Join(tmpDir, ".npmrc")
+	npmrc := "registry=https://npmrc.example/\n@other:registry=https://other.example/\n"
+	if err := os.WriteFile(npmrcPath, []byte(npmrc), 0600); err != nil {
+		t.Fatalf("failed to write .npmrc: %v", err)
+	}
+
+	configPath := filepath.Join(tmpDir, "config.toml")
+	configTOML := `
+[npm]
+registry = "https://buns.example/"
+
+[npm.scopes."@ourco"]
+registry = "https://npm.ourco.com/"
+token = "${OURCO_TOKEN}"
+`
+	if err := os.WriteFile(configPath, []byte(configTOML), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFile(configPath)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	registry, err := cfg.Registry(npmrcPath)
+	if err != nil {
+		t.Fatalf("Registry failed: %v", err)
+	}
+
+	if got := registry.ForPackage("zod").URL; got != "https://buns.example/" {
+		t.Errorf("default registry = %q, want buns config to override .npmrc", got)
+	}
+	if got := registry.ForPackage("@other/pkg").URL; got != "https://other.example/" {
+		t.Errorf("@other registry = %q, want value from .npmrc", got)
+	}
+	ourco := registry.ForPackage("@ourco/pkg")
+	if ourco.URL != "https://npm.ourco.com/" || ourco.Token != "from-env" {
+		t.Errorf("@ourco registry = %+v, want buns config with expanded token", ourco)
+	}
+}
+
+func TestConfig_Registry_rejects_scope_credentials_without_registry(t *testing.T) {
+	cfg := &Config{Npm: NpmConfig{Scopes: map[string]NpmScope{"@ourco": {Token: "s3cret"}}}}
+
+	if _, err := cfg.Registry(""); err == nil || !strings.Contains(err.Error(), "@ourco") {
+		t.Errorf("Registry() error = %v, want one naming the scope", err)
+	}
+}
+
+func TestPath_honours_BUNS_CONFIG(t *testing.T) {
+	t.Setenv("BUNS_CONFIG", "/etc/buns.toml")
+
+	path, err := Path()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/etc/buns.toml" {
+		t.Errorf("path = %q, want /etc/buns.toml", path)
+	}
+}
+
+func TestConfig_ReleasesURL(t *testing.T) {
+	t.Setenv("BUNS_RELEASES_URL", "")
+
+	cfg := &Config{}
+	if got := cfg.ReleasesURL(); got != "https://api.github.com/repos/oven-sh/bun/releases" {
+		t.Errorf("default = %q", got)
+	}
+
+	cfg.Bun.ReleasesURL = "https://mirror.example/releases"
+	if got := cfg.ReleasesURL(); got != "https://mirror.example/releases" {
+		t.Errorf("from config = %q", got)
+	}
+
+	t.Setenv("BUNS_RELEASES_URL", "http://127.0.0.1:8080/releases")
+	if got := cfg.ReleasesURL(); got != "http://127.0.0.1:8080/releases" {
+		t.Errorf("from env = %q", got)
+	}
+}
+
+func TestConfig_DownloadURLs(t *testing.T) {
+	t.Setenv("BUNS_DOWNLOAD_URL", "")
+
+	cfg := &Config{}
+	if got := cfg.DownloadURLs(); len(got) != 1 || got[0] != "https://github.com/oven-sh/bun/releases/download" {
+		t.Errorf("default = %v", got)
+	}
+
+	cfg.Bun.DownloadURLs = []string{"https://a.example", "https://b.example/{version}/{file}"}
+	if got := cfg.DownloadURLs(); len(got) != 2 || got[1] != "https://b.example/{version}/{file}" {
+		t.Errorf("from config = %v", got)
+	}
+
+	t.Setenv("BUNS_DOWNLOAD_URL", "https://c.example,https://d.example")
+	if got := cfg.DownloadURLs(); len(got) != 2 || got[0] != "https://c.example" {
+		t.Errorf("from env = %v", got)
+	}
+}
@@ -0,0 +1,461 @@
+package exec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eddmann/buns/internal/metadata"
+	"github.com/eddmann/buns/internal/proxy"
+	"github.com/eddmann/buns/internal/sandbox"
+)
+
+// learner records what a trial run accesses, to suggest a sandbox policy.
+// Hosts come from the proxy; filesystem access comes from strace, when it is
+// available (Linux only).
+type learner struct {
+	mu    sync.Mutex
+	ports map[string]map[int]bool // Ports contacted per host
+
+	traceDir string // strace output, empty when filesystem access isn't traced
+}
+
+// learningPlan relaxes a plan for a learning run: no isolation beyond the
+// proxy, every host reachable and the whole environment passed through
+func learningPlan(plan sandboxPlan, environ []string) sandboxPlan {
+	plan.enabled = false
+	plan.network = true
+	plan.allowHosts = nil
+	plan.denyHosts = nil
+	plan.prompt = false
+	plan.learn = true
+
+	plan.allowEnv = nil
+	for _, env := range environ {
+		if name, _, ok := strings.Cut(env, "="); ok && name != "" {
+			plan.allowEnv = append(plan.allowEnv, name)
+		}
+	}
+	return plan
+}
+
+func newLearner() *learner {
+	return &learner{ports: make(map[string]map[int]bool)}
+}
+
+// observe records a connection allowed by the proxy
+func (l *learner) observe(rec proxy.AuditRecord) {
+	if rec.Decision != proxy.DecisionAllowed || rec.Host == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ports[rec.Host] == nil {
+		l.ports[rec.Host] = make(map[int]bool)
+	}
+	if rec.Port != 0 {
+		l.ports[rec.Host][rec.Port] = true
+	}
+}
+
+// hosts returns allow-host rules for the hosts contacted, with the ports used
+func (l *learner) hosts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var hosts []string
+	for host, ports := range l.ports {
+		if len(ports) == 0 {
+			hosts = append(hosts, host)
+			continue
+		}
+		list := make([]int, 0, len(ports))
+		for port := range ports {
+			list = append(list, port)
+		}
+		sort.Ints(list)
+		parts := make([]string, len(list))
+		for i, port := range list {
+			parts[i] = strconv.Itoa(port)
+		}
+		hosts = append(hosts, net.JoinHostPort(host, strings.Join(parts, ",")))
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// startTrace prepares to trace filesystem access with strace, returning the
+// command to run Bun under. It returns nil when tracing isn't possible.
+func (l *learner) startTrace() ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	strace, err := osexec.LookPath("strace")
+	if err != nil {
+		return nil, nil
+	}
+
+	l.traceDir, err = os.MkdirTemp("", "buns-learn-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+
+	// One output file per process, full-length paths, file syscalls only
+	return []string{strace, "-ff", "-qq", "-s", "4096", "-e", "trace=file", "-o", filepath.Join(l.traceDir, "trace")}, nil
+}
+
+// cleanup removes the trace output
+func (l *learner) cleanup() {
+	if l.traceDir != "" {
+		_ = os.RemoveAll(l.traceDir)
+	}
+}
+
+// fileAccess returns the paths read and written during the run, relative
+// paths resolved against workDir
+func (l *learner) fileAccess(workDir string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(l.traceDir, "trace.*"))
+	if err != nil {
+		return nil, err
+	}
+
+	access := make(map[string]bool)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		err = parseTrace(f, workDir, access)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return access, nil
+}
+
+// traceLine matches a completed strace call: name, arguments and return value
+var traceLine = regexp.MustCompile(`^([a-z0-9_]+)\((.*)\)\s+=\s+(-?\d+)`)
+
+// Syscalls that create or remove directory entries, needing the parent
+// directory to be writable
+var entryCalls = map[string]bool{
+	"mkdir": true, "mkdirat": true, "rmdir": true,
+	"unlink": true, "unlinkat": true,
+	"rename": true, "renameat": true, "renameat2": true,
+	"link": true, "linkat": true, "symlink": true, "symlinkat": true,
+	"mknod": true, "mknodat": true, "creat": true,
+}
+
+// Syscalls that modify a file in place
+var modifyCalls = map[string]bool{
+	"truncate": true, "chmod": true, "fchmodat": true,
+	"chown": true, "lchown": true, "fchownat": true,
+	"utime": true, "utimes": true, "utimensat": true, "futimesat": true,
+	"setxattr": true, "lsetxattr": true, "removexattr": true, "lremovexattr": true,
+}
+
+// Syscalls whose later string arguments are data rather than paths
+var firstPathOnly = map[string]bool{
+	"readlink": true, "readlinkat": true,
+	"getxattr": true, "lgetxattr": true, "setxattr": true, "lsetxattr": true,
+	"listxattr": true, "llistxattr": true, "removexattr": true, "lremovexattr": true,
+}
+
+// parseTrace reads strace output, adding each path accessed to access with
+// whether it was written. Failed calls are skipped. Creating or removing an
+// entry counts as writing its parent directory.
+func parseTrace(r io.Reader, workDir string, access map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		m := traceLine.FindStringSubmatch(scanner.Text())
+		if m == nil || strings.HasPrefix(m[3], "-") {
+			continue
+		}
+		call, args := m[1], splitTraceArgs(m[2])
+
+		paths := tracePaths(call, args, workDir)
+		if len(paths) == 0 {
+			continue
+		}
+
+		switch {
+		case entryCalls[call] || (isOpen(call) && strings.Contains(m[2], "O_CREAT")):
+			for _, path := range paths {
+				access[filepath.Dir(path)] = true
+			}
+		case modifyCalls[call] || (isOpen(call) && hasWriteFlag(m[2])):
+			for _, path := range paths {
+				access[path] = true
+			}
+		default:
+			for _, path := range paths {
+				if !access[path] {
+					access[path] = false
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func isOpen(call string) bool {
+	return call == "open" || call == "openat" || call == "openat2"
+}
+
+func hasWriteFlag(args string) bool {
+	return strings.Contains(args, "O_WRONLY") || strings.Contains(args, "O_RDWR") || strings.Contains(args, "O_TRUNC")
+}
+
+// tracePaths returns the absolute paths among a call's arguments. Paths
+// relative to a directory descriptor other than the working directory are
+// skipped, as the descriptor's path isn't known.
+func tracePaths(call string, args []string, workDir string) []string {
+	var candidates []int
+	for i, arg := range args {
+		if strings.HasPrefix(arg, `"`) {
+			candidates = append(candidates, i)
+		}
+	}
+	switch {
+	case len(candidates) == 0:
+		return nil
+	case firstPathOnly[call]:
+		candidates = candidates[:1]
+	case call == "symlink" || call == "symlinkat":
+		// A symlink's target is stored, not accessed
+		candidates = candidates[len(candidates)-1:]
+	}
+
+	var paths []string
+	for _, i := range candidates {
+		path, err := strconv.Unquote(args[i])
+		if err != nil || path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			if i > 0 {
+				if _, err := strconv.Atoi(args[i-1]); err == nil {
+					continue
+				}
+			}
+			path = filepath.Join(workDir, path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths
+}
+
+// splitTraceArgs splits strace call arguments at top-level commas
+func splitTraceArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	inString := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// learnedPaths reduces traced access to the allow-read and allow-write lists
+// a sandboxed run needs. Paths the sandbox already provides are dropped, as
+// are reads of the working directory and of directories leading to it or to
+// provided paths (e.g. stat of /home).
+func learnedPaths(access map[string]bool, provided []string, workDir string) (reads, writes []string) {
+	for path, written := range access {
+		if within(path, provided) {
+			continue
+		}
+		if written {
+			writes = append(writes, path)
+		} else if path != workDir && !leadsTo(path, append(provided, workDir)) {
+			reads = append(reads, path)
+		}
+	}
+
+	writes = compactPaths(writes)
+	var uncovered []string
+	for _, path := range reads {
+		if !within(path, writes) {
+			uncovered = append(uncovered, path)
+		}
+	}
+	return compactPaths(uncovered), writes
+}
+
+// leadsTo reports whether path is a parent directory of any of dirs
+func leadsTo(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if dir != path && within(dir, []string{path}) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path equals or is inside any of dirs
+func within(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || dir == "/" || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compactPaths sorts paths, dropping duplicates and paths inside another
+func compactPaths(paths []string) []string {
+	sort.Strings(paths)
+	var result []string
+	for _, path := range paths {
+		if !within(path, result) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// providedPaths lists what every sandbox gives a script without being asked:
+// system and temporary directories, Bun, the script and its dependencies
+func providedPaths(bunPath, scriptPath, depsDir, traceDir string) []string {
+	paths := append([]string{"/proc", "/sys", "/dev", "/tmp", os.TempDir()}, sandbox.SystemPaths(true)...)
+	for _, path := range []string{bunPath, scriptPath} {
+		paths = append(paths, filepath.Dir(path))
+		if real, err := sandbox.ResolvePath(path); err == nil {
+			paths = append(paths, filepath.Dir(real))
+		}
+	}
+	for _, dir := range []string{depsDir, traceDir} {
+		if dir != "" {
+			paths = append(paths, dir)
+		}
+	}
+	return paths
+}
+
+// envAccess matches environment variable reads in a script, e.g.
+// process.env.API_KEY, Bun.env["API_KEY"] or import.meta.env.API_KEY
+var envAccess = regexp.MustCompile("(?:process\\.env|Bun\\.env|import\\.meta\\.env)(?:\\.([A-Za-z_][A-Za-z0-9_]*)|\\[\\s*[\"'`]([A-Za-z_][A-Za-z0-9_]*)[\"'`]\\s*\\])")
+
+// envDestructure matches destructuring of the environment, e.g.
+// const { API_KEY, REGION = "eu" } = process.env
+var envDestructure = regexp.MustCompile(`\{([^{}]*)\}\s*=\s*(?:process\.env|Bun\.env|import\.meta\.env)\b`)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// scriptEnv returns the environment variables a script reads that sandboxes
+// don't pass through by default. Variables read by dependencies aren't found.
+func scriptEnv(content []byte) []string {
+	seen := make(map[string]bool)
+	for _, m := range envAccess.FindAllSubmatch(content, -1) {
+		seen[string(m[1])+string(m[2])] = true
+	}
+	for _, m := range envDestructure.FindAllSubmatch(content, -1) {
+		for _, part := range strings.Split(string(m[1]), ",") {
+			name := strings.TrimSpace(part)
+			if i := strings.IndexAny(name, ":="); i != -1 {
+				name = strings.TrimSpace(name[:i])
+			}
+			if identifier.MatchString(name) {
+				seen[name] = true
+			}
+		}
+	}
+
+	var names []string
+	for name := range seen {
+		if !sandbox.IsSafeEnvVar(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// learnedPolicy builds the policy suggested by a learning run. Full isolation
+// is only suggested when filesystem access was traced.
+func learnedPolicy(hosts, reads, writes, env []string, traced bool) metadata.Sandbox {
+	policy := metadata.Sandbox{
+		AllowHost:  hosts,
+		AllowRead:  reads,
+		AllowWrite: writes,
+		AllowEnv:   env,
+	}
+	if traced {
+		enabled := true
+		policy.Enabled = &enabled
+	}
+	if len(hosts) == 0 {
+		offline := true
+		policy.Offline = &offline
+	}
+	return policy
+}
+
+// reportLearned prints the policy learned from a run, or writes it to
+// outputPath as a [sandbox] TOML file
+func (r *Runner) reportLearned(l *learner, bunPath, scriptPath, depsDir, workDir, outputPath string) error {
+	var reads, writes []string
+	traced := l.traceDir != ""
+	if traced {
+		access, err := l.fileAccess(workDir)
+		if err != nil {
+			return fmt.Errorf("failed to read filesystem trace: %w", err)
+		}
+		reads, writes = learnedPaths(access, providedPaths(bunPath, scriptPath, depsDir, l.traceDir), workDir)
+	}
+
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	policy := metadata.FormatSandbox(learnedPolicy(l.hosts(), reads, writes, scriptEnv(content), traced))
+
+	if outputPath != "" {
+		if err := os.WriteFile(outputPath, []byte(policy), 0644); err != nil {
+			return fmt.Errorf("failed to write learned policy: %w", err)
+		}
+		if !r.quiet {
+			fmt.Fprintf(os.Stderr, "[buns] Wrote learned sandbox policy to %s\n", outputPath)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "\n[buns] Learned sandbox policy:\n\n%s\n", metadata.FormatBlock(policy))
+	}
+
+	if !traced && !r.quiet {
+		fmt.Fprintln(os.Stderr, "[buns] Filesystem access was not traced (needs strace on Linux); add allow-read and allow-write before enabling the sandbox")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package exec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eddmann/buns/internal/proxy"
+)
+
+func TestLearningPlan(t *testing.T) {
+	plan := sandboxPlan{
+		enabled:     true,
+		allowHosts:  []string{"a.example"},
+		denyHosts:   []string{"b.example"},
+		allowEnv:    []string{"API_KEY"},
+		prompt:      true,
+		timeoutSecs: 10,
+	}
+
+	got := learningPlan(plan, []string{"HOME=/home/test", "API_KEY=secret", "EMPTY="})
+
+	want := sandboxPlan{
+		network:     true,
+		allowEnv:    []string{"HOME", "API_KEY", "EMPTY"},
+		timeoutSecs: 10,
+		learn:       true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("plan = %+v, want %+v", got, want)
+	}
+}
+
+func TestLearner_hosts(t *testing.T) {
+	l := newLearner()
+	l.observe(proxy.AuditRecord{Host: "api.example.com", Port: 443, Decision: proxy.DecisionAllowed})
+	l.observe(proxy.AuditRecord{Host: "api.example.com", Port: 80, Decision: proxy.DecisionAllowed})
+	l.observe(proxy.AuditRecord{Host: "api.example.com", Port: 443, Decision: proxy.DecisionAllowed})
+	l.observe(proxy.AuditRecord{Host: "::1", Port: 8080, Decision: proxy.DecisionAllowed})
+	l.observe(proxy.AuditRecord{Host: "169.254.169.254", Port: 80, Decision: proxy.DecisionDenied})
+
+	want := []string{"[::1]:8080", "api.example.com:80,443"}
+	if got := l.hosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts() = %v, want %v", got, want)
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	trace := `execve("/opt/bun/bun", ["/opt/bun/bun", "run", "/src/app.ts"], 0x7ffc /* 20 vars */) = 0
+openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+openat(AT_FDCWD, "/data/input.json", O_RDONLY|O_CLOEXEC) = 4
+newfstatat(AT_FDCWD, "/data/input.json", {st_mode=S_IFREG|0644, st_size=12, ...}, 0) = 0
+openat(AT_FDCWD, "/missing.json", O_RDONLY) = -1 ENOENT (No such file or directory)
+openat(AT_FDCWD, "out/report.txt", O_WRONLY|O_CREAT|O_TRUNC|O_CLOEXEC, 0666) = 5
+openat(AT_FDCWD, "/var/log/app.log", O_WRONLY|O_APPEND) = 6
+openat(7, "relative-to-fd", O_RDONLY) = 8
+mkdir("/cache/app", 0777) = 0
+renameat2(AT_FDCWD, "/state/tmp.json", AT_FDCWD, "/state/db.json", 0) = 0
+readlink("/usr/bin/node", "/opt/node/bin/node", 4096) = 18
+symlinkat("/nowhere/target", AT_FDCWD, "/links/current") = 0
+statx(AT_FDCWD, "/with \"quotes\"", AT_STATX_SYNC_AS_STAT, STATX_ALL, {stx_mask=STATX_ALL, ...}) = 0
++++ exited with 0 +++
+`
+
+	access := make(map[string]bool)
+	if err := parseTrace(strings.NewReader(trace), "/work", access); err != nil {
+		t.Fatalf("parseTrace() error = %v", err)
+	}
+
+	want := map[string]bool{
+		"/opt/bun/bun":     false,
+		"/etc/ld.so.cache": false,
+		"/data/input.json": false,
+		"/work/out":        true,
+		"/var/log/app.log": true,
+		"/cache":           true,
+		"/state":           true,
+		"/usr/bin/node":    false,
+		"/links":           true,
+		`/with "quotes"`:   false,
+	}
+	if !reflect.DeepEqual(access, want) {
+		t.Errorf("access = %v, want %v", access, want)
+	}
+}
+
+func TestLearnedPaths(t *testing.T) {
+	access := map[string]bool{
+		"/usr/lib/libc.so":       false, // Provided
+		"/opt/bun/bun":           false, // Provided
+		"/home":                  false, // Leads to the working directory
+		"/home/me/project":       false, // Working directory
+		"/home/me/.config/app":   false,
+		"/home/me/.config/app/x": false, // Inside another read
+		"/data/in.json":          false,
+		"/out":                   true,
+		"/out/sub":               true,  // Inside another write
+		"/out/seen.json":         false, // Readable through a write
+		"/tmp/scratch":           true,  // Provided
+	}
+	provided := []string{"/usr", "/opt/bun", "/tmp"}
+
+	reads, writes := learnedPaths(access, provided, "/home/me/project")
+
+	if want := []string{"/data/in.json", "/home/me/.config/app"}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("reads = %v, want %v", reads, want)
+	}
+	if want := []string{"/out"}; !reflect.DeepEqual(writes, want) {
+		t.Errorf("writes = %v, want %v", writes, want)
+	}
+}
+
+func TestScriptEnv(t *testing.T) {
+	script := []byte(`
+const token = process.env.GITHUB_TOKEN;
+const region = process.env["AWS_REGION"] ?? "eu-west-1";
+const home = process.env.HOME;
+const { DATABASE_URL, PORT: port = "3000", ...rest } = Bun.env;
+console.log(import.meta.env.DEBUG, Bun.env['LOG_LEVEL']);
+`)
+
+	want := []string{"AWS_REGION", "DATABASE_URL", "DEBUG", "GITHUB_TOKEN", "LOG_LEVEL", "PORT"}
+	if got := scriptEnv(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("scriptEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestLearnedPolicy(t *testing.T) {
+	t.Run("traced with hosts", func(t *testing.T) {
+		policy := learnedPolicy([]string{"api.example.com:443"}, []string{"/data"}, nil, []string{"API_KEY"}, true)
+
+		if policy.Enabled == nil || !*policy.Enabled {
+			t.Error("traced runs should suggest full isolation")
+		}
+		if policy.Offline != nil {
+			t.Error("offline should be unset when hosts were contacted")
+		}
+		if !reflect.DeepEqual(policy.AllowHost, []string{"api.example.com:443"}) || !reflect.DeepEqual(policy.AllowRead, []string{"/data"}) {
+			t.Errorf("policy = %+v", policy)
+		}
+	})
+
+	t.Run("untraced without hosts", func(t *testing.T) {
+		policy := learnedPolicy(nil, nil, nil, nil, false)
+
+		if policy.Enabled != nil {
+			t.Error("untraced runs should not suggest full isolation")
+		}
+		if policy.Offline == nil || !*policy.Offline {
+			t.Error("runs without network access should suggest offline")
+		}
+	})
+}
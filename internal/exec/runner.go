@@ -1,19 +1,26 @@
 package exec
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/eddmann/buns/internal/bun"
 	"github.com/eddmann/buns/internal/cache"
+	"github.com/eddmann/buns/internal/config"
 	"github.com/eddmann/buns/internal/index"
+	"github.com/eddmann/buns/internal/lock"
 	"github.com/eddmann/buns/internal/metadata"
+	"github.com/eddmann/buns/internal/npm"
 	"github.com/eddmann/buns/internal/proxy"
 	"github.com/eddmann/buns/internal/sandbox"
 )
@@ -23,20 +30,47 @@ type Runner struct {
 	cache    *cache.Cache
 	index    *index.Index
 	resolver *bun.Resolver
+	registry *npm.Registry
+	mirrors  []string // Bun download mirrors, tried in order
+	variant  bun.Variant
 	verbose  bool
 	quiet    bool
+
+	cacheOnly bool // Never download or install; only use cached artifacts
 }
 
-// NewRunner creates a new script runner
-func NewRunner(c *cache.Cache, verbose, quiet bool) *Runner {
-	idx := index.New(c.IndexDir())
+// NewRunner creates a new script runner using the given user settings
+// (nil for defaults), combined with the user's ~/.npmrc
+func NewRunner(c *cache.Cache, settings *config.Config, verbose, quiet bool) (*Runner, error) {
+	if settings == nil {
+		settings = &config.Config{}
+	}
+
+	npmrcPath, err := config.NpmrcPath()
+	if err != nil {
+		return nil, err
+	}
+	registryConfig, err := settings.Registry(npmrcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := bun.ParseVariant(settings.BunVariant())
+	if err != nil {
+		return nil, err
+	}
+
+	idx := settings.Index(c.IndexDir())
 	return &Runner{
 		cache:    c,
 		index:    idx,
 		resolver: bun.NewResolver(idx),
+		registry: npm.NewRegistryWithConfig(registryConfig),
+		mirrors:  settings.DownloadURLs(),
+		variant:  variant,
 		verbose:  verbose,
 		quiet:    quiet,
-	}
+	}, nil
 }
 
 // RunOptions contains options for running a script
@@ -44,19 +78,20 @@ type RunOptions struct {
 	Script        string
 	Args          []string
 	BunConstraint string   // Override bun version from CLI
+	BunVariant    string   // Override the Bun build variant from CLI
 	ExtraPackages []string // Additional packages from CLI
 	TypeCheck     bool     // Run TypeScript type checking before execution
-
-	// Sandbox options
-	Sandbox     sandbox.Sandbox // Sandbox instance (set by CLI)
-	Network     bool            // Whether network is enabled
-	AllowHosts  []string        // Allowed hosts for network access
-	AllowRead   []string        // Additional readable paths
-	AllowWrite  []string        // Additional writable paths
-	AllowEnv    []string        // Environment variables to pass through
-	MemoryMB    int             // Memory limit in MB
-	TimeoutSecs int             // Execution timeout in seconds
-	CPUSeconds  int             // CPU time limit in seconds
+	CacheOnly     bool     // Resolve Bun and dependencies from the local cache only
+	Prompt        bool     // Ask on the terminal before allowing hosts outside the allow-list
+	AuditLog      string   // Record network access as JSON lines to this file ("-" = stderr)
+	Learn         bool     // Run permissively and suggest a sandbox policy from what the script accessed
+	LearnOutput   string   // Write the learned policy to this file instead of stderr
+	Stats         string   // Print resource usage after the run: StatsText or StatsJSON ("" = don't)
+	Backend       string   // Sandbox backend to use, one of sandbox.Backends ("" = detect)
+
+	// Sandbox settings given on the command line. Unset fields fall back to
+	// the script's [sandbox] table, then the defaults.
+	Sandbox metadata.Sandbox
 }
 
 // Run executes a script with its dependencies
@@ -118,45 +153,125 @@ func (r *Runner) Run(opts RunOptions) (int, error) {
 		r.log("Found: bun=%q, packages=%v", bunConstraint, packages)
 	}
 
-	r.log("Resolving Bun version for constraint '%s'", bunConstraint)
+	if opts.Stats != "" && opts.Stats != StatsText && opts.Stats != StatsJSON {
+		return 1, fmt.Errorf("invalid --stats format '%s' (expected %s or %s)", opts.Stats, StatsText, StatsJSON)
+	}
 
-	version, err := r.resolver.Resolve(bunConstraint)
+	// Work out the sandbox up front so an unavailable one fails before any downloads
+	plan := resolveSandboxPlan(meta.Sandbox, opts.Sandbox)
+	plan.prompt = opts.Prompt
+	plan.auditLog = opts.AuditLog
+	plan.stats = opts.Stats
+	plan.backend = opts.Backend
+	plan.persist = opts.Script != "-"
+	if opts.Learn {
+		plan = learningPlan(plan, os.Environ())
+		plan.learnOutput = opts.LearnOutput
+	}
+	sb, err := selectSandbox(plan, sandbox.Detect)
 	if err != nil {
-		return 1, fmt.Errorf("no Bun version satisfies '%s'", bunConstraint)
+		return 1, err
 	}
 
-	r.log("Matched: %s", version.Original())
+	if opts.BunVariant != "" {
+		r.variant, err = bun.ParseVariant(opts.BunVariant)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	// Use the sidecar lock file when one exists. It's checked against the
+	// script's own metadata: --bun and --packages deliberately override the
+	// pins they cover rather than making the lock out of date.
+	var locked *lock.Lock
+	var pinned *semver.Version
+	if opts.Script != "-" {
+		lockPath := lock.PathFor(scriptPath)
+		locked, err = lock.Load(lockPath)
+		if err != nil {
+			return 1, err
+		}
+		if locked != nil {
+			if err := locked.Check(meta.Bun, meta.Packages); err != nil {
+				return 1, fmt.Errorf("%s: %w\nRun 'buns lock %s' to update it", lockPath, err, opts.Script)
+			}
+			r.log("Using lock file: %s", lockPath)
+
+			if opts.BunConstraint != "" {
+				r.log("Not using the locked Bun version: --bun given")
+			} else {
+				pinned, err = semver.NewVersion(locked.Bun.Version)
+				if err != nil {
+					return 1, fmt.Errorf("invalid Bun version '%s' in lock file: %w", locked.Bun.Version, err)
+				}
+			}
+			if len(opts.ExtraPackages) > 0 {
+				r.log("Not using the locked packages: --packages given")
+				locked = nil
+			} else {
+				packages = locked.PinnedPackages()
+			}
+		}
+	}
+
+	// In cache-only mode, check everything is already cached before doing any work
+	var offline *offlinePlan
+	if opts.CacheOnly {
+		r.cacheOnly = true
+		offline, err = r.planOffline(bunConstraint, pinned, packages, locked, opts.TypeCheck)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	var version *semver.Version
+	if offline != nil {
+		version = offline.version
+		r.log("Cached: bun %s", bun.VersionName(version))
+	} else if pinned != nil {
+		version = pinned
+		r.log("Locked: bun %s", bun.VersionName(version))
+	} else {
+		r.log("Resolving Bun version for constraint '%s'", bunConstraint)
+
+		version, err = r.resolver.Resolve(bunConstraint)
+		if err != nil {
+			return 1, fmt.Errorf("no Bun version satisfies '%s'", bunConstraint)
+		}
+
+		r.log("Matched: %s", bun.VersionName(version))
+	}
 
 	// Get bun binary
-	downloader := bun.NewDownloader(r.cache.BunDir(), r.verbose, r.quiet)
-	bunPath, err := downloader.GetBinary(version)
-	if err != nil {
-		return 1, fmt.Errorf("failed to download Bun: %w", err)
+	downloader := r.downloader()
+	var bunPath string
+	if offline != nil {
+		bunPath, err = downloader.CachedBinary(version)
+		if err != nil {
+			return 1, err
+		}
+	} else {
+		bunPath, err = downloader.GetBinary(version)
+		if err != nil {
+			return 1, fmt.Errorf("failed to download Bun: %w", err)
+		}
 	}
 
 	r.log("Bun binary: %s", bunPath)
 
 	// Handle dependencies
 	var depsDir string
-	if len(packages) > 0 {
-		hash := cache.HashPackages(packages)
-		depsDir = r.cache.DepsDirForHash(hash)
-
-		r.log("Dependencies hash: %s", hash[:12]+"...")
-
-		if r.cache.IsDepsHit(hash) {
-			r.log("Cache hit: %s", depsDir)
-		} else {
-			r.log("Cache miss: %s", depsDir)
-			if err := r.installDeps(bunPath, depsDir, packages); err != nil {
-				return 1, fmt.Errorf("failed to install dependencies: %w", err)
-			}
-			r.log("Dependencies installed")
+	if offline != nil {
+		depsDir = offline.depsDir
+	} else if len(packages) > 0 {
+		depsDir, err = r.prepareDeps(bunPath, packages, locked)
+		if err != nil {
+			return 1, err
 		}
 	}
 
 	if opts.TypeCheck {
-		exitCode, err := r.typeCheckScript(bunPath, scriptPath, packages, version.Original())
+		exitCode, err := r.typeCheckScript(bunPath, scriptPath, packages, typesVersion(version))
 		if err != nil {
 			return 1, err
 		}
@@ -165,20 +280,403 @@ func (r *Runner) Run(opts RunOptions) (int, error) {
 		}
 	}
 
-	// If sandbox is set and provides isolation, use sandboxed execution
-	if opts.Sandbox != nil && opts.Sandbox.IsSandboxed() {
-		return r.execScriptSandboxed(bunPath, scriptPath, opts, depsDir)
+	// If sandbox provides isolation, use sandboxed execution
+	if sb.IsSandboxed() {
+		return r.execScriptSandboxed(sb, plan, bunPath, scriptPath, opts.Args, depsDir)
 	}
 
 	// Execute script normally
 	r.log("Executing: %s run %s", bunPath, scriptPath)
-	return r.execScript(bunPath, scriptPath, opts.Args, depsDir)
+	return r.execScript(bunPath, scriptPath, opts.Args, depsDir, opts.Stats)
 }
 
-// execScriptSandboxed runs the script in a sandbox
-func (r *Runner) execScriptSandboxed(bunPath, scriptPath string, opts RunOptions, depsDir string) (int, error) {
-	sb := opts.Sandbox
+// downloader returns a Bun downloader using the configured mirrors
+func (r *Runner) downloader() *bun.Downloader {
+	return bun.NewDownloaderWithMirrors(r.cache.BunDir(), r.mirrors, r.variant, r.verbose, r.quiet)
+}
+
+// offlinePlan holds the cached artifacts selected for a cache-only run
+type offlinePlan struct {
+	version *semver.Version
+	depsDir string
+}
+
+// planOffline selects a cached Bun binary and deps directory for the run without
+// touching the network, using the pinned Bun version and locked packages when
+// given. It reports everything that is missing in a single error.
+func (r *Runner) planOffline(bunConstraint string, pinned *semver.Version, packages []string, locked *lock.Lock, typeCheck bool) (*offlinePlan, error) {
+	plan := &offlinePlan{}
+	var missing []string
+
+	if pinned != nil {
+		if r.downloader().IsCached(pinned) {
+			plan.version = pinned
+		} else {
+			missing = append(missing, "bun "+pinned.Original())
+		}
+	} else {
+		v, err := bun.NewResolver(bun.NewCachedSource(r.cache.BunDir(), r.variant)).Resolve(bunConstraint)
+		if err == nil {
+			plan.version = v
+		} else if bunConstraint == "" {
+			missing = append(missing, "bun (any version)")
+		} else {
+			missing = append(missing, fmt.Sprintf("bun satisfying '%s'", bunConstraint))
+		}
+	}
+
+	if len(packages) > 0 {
+		if locked != nil {
+			hash := depsHash(packages, locked.Tree)
+			if r.cache.IsDepsHit(hash) {
+				plan.depsDir = r.cache.DepsDirForHash(hash)
+			} else {
+				missing = append(missing, packages...)
+			}
+		} else {
+			dir, unsatisfied := r.findCachedDeps(packages)
+			plan.depsDir = dir
+			missing = append(missing, unsatisfied...)
+		}
+	}
+
+	if typeCheck && plan.version != nil {
+		pinned := buildTypeCheckPackages(packages, typesVersion(plan.version), true)
+		fallback := buildTypeCheckPackages(packages, typesVersion(plan.version), false)
+		if !cache.IsPackageInstallHit(r.cache.TypecheckDirForHash(cache.HashPackages(pinned))) &&
+			!cache.IsPackageInstallHit(r.cache.TypecheckDirForHash(cache.HashPackages(fallback))) {
+			missing = append(missing, "typecheck dependencies ("+typeScriptPackage+", "+bunTypesPackage+")")
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("cache-only mode: not available in the local cache:\n  - %s\nRun once with network access to populate the cache", strings.Join(missing, "\n  - "))
+	}
+
+	return plan, nil
+}
+
+// findCachedDeps finds a cached deps directory whose installed versions satisfy
+// every package spec. If none does, it returns the specs that no cached directory satisfies.
+func (r *Runner) findCachedDeps(packages []string) (string, []string) {
+	// Prefer a directory installed for exactly these constraints
+	if dir, m := r.cache.FindDeps(func(m *cache.DepsManifest) bool {
+		return cache.SamePackages(m.Requested, packages)
+	}); m != nil {
+		return dir, nil
+	}
+
+	if dir, m := r.cache.FindDeps(func(m *cache.DepsManifest) bool {
+		for _, spec := range packages {
+			if !satisfiedBy(spec, m.Resolved) {
+				return false
+			}
+		}
+		return true
+	}); m != nil {
+		return dir, nil
+	}
+
+	var unsatisfied []string
+	for _, spec := range packages {
+		if _, m := r.cache.FindDeps(func(m *cache.DepsManifest) bool {
+			return satisfiedBy(spec, m.Resolved)
+		}); m == nil {
+			unsatisfied = append(unsatisfied, spec)
+		}
+	}
+
+	// Each package is cached somewhere, but never together
+	if len(unsatisfied) == 0 {
+		unsatisfied = append(unsatisfied, strings.Join(packages, " + ")+" (not installed together)")
+	}
+
+	return "", unsatisfied
+}
+
+// satisfiedBy reports whether one of the resolved name@version entries satisfies spec
+func satisfiedBy(spec string, resolved []string) bool {
+	name, constraint := parsePackageSpec(spec)
+
+	for _, entry := range resolved {
+		entryName, entryVersion := parsePackageSpec(entry)
+		if !strings.EqualFold(entryName, name) {
+			continue
+		}
+		if constraint == "" {
+			return true
+		}
+
+		c, err := semver.NewConstraint(constraint)
+		if err != nil {
+			// Dist-tags (e.g. "latest") cannot be checked offline; accept any installed version
+			return true
+		}
+		v, err := semver.NewVersion(entryVersion)
+		if err != nil {
+			return false
+		}
+		return c.Check(v)
+	}
+
+	return false
+}
+
+// prepareDeps resolves packages to concrete versions and ensures they are
+// installed, returning the deps directory. Identical resolved sets share a directory.
+func (r *Runner) prepareDeps(bunPath string, packages []string, locked *lock.Lock) (string, error) {
+	resolved := packages
+	if locked == nil {
+		// Versions resolved recently are reused without asking the registry
+		if dir, m := r.cache.FindDeps(func(m *cache.DepsManifest) bool {
+			return time.Since(m.ResolvedAt) < cache.ResolutionTTL && resolves(packages, m.Resolved)
+		}); m != nil {
+			r.log("Cache hit: %s (resolved %s)", dir, strings.Join(m.Resolved, ", "))
+			return dir, nil
+		}
+
+		var err error
+		resolved, err = r.resolvePackages(packages)
+		if err != nil {
+			// Fall back to a previous install of the same constraints
+			if dir, m := r.cache.FindDeps(func(m *cache.DepsManifest) bool {
+				return cache.SamePackages(m.Requested, packages)
+			}); m != nil {
+				r.log("Warning: %v; reusing cached dependencies %v", err, m.Resolved)
+				return dir, nil
+			}
+			return "", err
+		}
+	}
+
+	var tree *lock.Tree
+	if locked != nil {
+		tree = locked.Tree
+	}
+	hash := depsHash(resolved, tree)
+	depsDir := r.cache.DepsDirForHash(hash)
+
+	r.log("Dependencies hash: %s", hash[:12]+"...")
+
+	if r.cache.IsDepsHit(hash) {
+		r.log("Cache hit: %s", depsDir)
+		if locked == nil {
+			r.markResolved(depsDir)
+		}
+		return depsDir, nil
+	}
+
+	r.log("Cache miss: %s", depsDir)
+	if locked != nil {
+		if err := r.verifyLockedPackages(locked); err != nil {
+			return "", err
+		}
+	}
+
+	// Another buns process may be installing the same set; Populate waits for
+	// it and skips the install if it finished first
+	installed := false
+	err := cache.Populate(depsDir, cache.IsPackageInstallHit, func(tmpDir string) error {
+		if err := r.installDeps(bunPath, tmpDir, resolved, tree); err != nil {
+			return fmt.Errorf("failed to install dependencies: %w", err)
+		}
+
+		manifest := &cache.DepsManifest{Requested: packages, Resolved: resolved, ResolvedAt: time.Now()}
+		if err := cache.WriteDepsManifest(tmpDir, manifest); err != nil {
+			r.log("Warning: failed to write deps manifest: %v", err)
+		}
+		installed = true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if installed {
+		r.log("Dependencies installed: %s", strings.Join(resolved, ", "))
+	} else {
+		r.log("Dependencies installed by another process: %s", depsDir)
+	}
+	return depsDir, nil
+}
+
+// markResolved records that the versions installed in depsDir were just
+// resolved again, so runs within the TTL can skip the registry. It holds
+// depsDir's lock, so a concurrent Populate can't replace the directory while
+// the manifest is rewritten.
+func (r *Runner) markResolved(depsDir string) {
+	unlock, err := cache.Lock(depsDir)
+	if err != nil {
+		r.log("Warning: failed to update deps manifest: %v", err)
+		return
+	}
+	defer unlock()
+
+	m, err := cache.ReadDepsManifest(depsDir)
+	if err != nil {
+		return
+	}
+	m.ResolvedAt = time.Now()
+	if err := cache.WriteDepsManifest(depsDir, m); err != nil {
+		r.log("Warning: failed to update deps manifest: %v", err)
+	}
+}
+
+// resolves reports whether the resolved name@version entries are exactly one
+// version satisfying each of the package specs
+func resolves(packages, resolved []string) bool {
+	if len(packages) != len(resolved) {
+		return false
+	}
+	for _, spec := range packages {
+		if !satisfiedBy(spec, resolved) {
+			return false
+		}
+	}
+	return true
+}
+
+// depsHash returns the cache key of a deps directory: its packages and, when
+// locked, the dependency tree they're installed from
+func depsHash(packages []string, tree *lock.Tree) string {
+	if tree == nil {
+		return cache.HashPackages(packages)
+	}
+	return cache.HashPackages(append(slices.Clone(packages), "tree:"+tree.Digest()))
+}
+
+// resolvePackages resolves each package spec to an exact name@version through the registry
+func (r *Runner) resolvePackages(packages []string) ([]string, error) {
+	resolved := make([]string, 0, len(packages))
+	for _, spec := range packages {
+		pv, err := r.registry.Resolve(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", spec, err)
+		}
+		r.log("Resolved: %s -> %s@%s", spec, pv.Name, pv.Version)
+		resolved = append(resolved, pv.Name+"@"+pv.Version)
+	}
+	return resolved, nil
+}
 
+// Lock resolves a script's Bun constraint and packages to exact versions,
+// installs them to record Bun's lockfile of the whole dependency tree, and
+// writes them to the script's sidecar lock file, returning its path
+func (r *Runner) Lock(script string) (*lock.Lock, string, error) {
+	if script == "-" {
+		return nil, "", fmt.Errorf("cannot lock a script read from stdin")
+	}
+
+	scriptPath, err := filepath.Abs(script)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve script path: %w", err)
+	}
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("script not found: %s", script)
+	}
+
+	meta, err := metadata.Parse(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	r.log("Resolving Bun version for constraint '%s'", meta.Bun)
+	version, err := r.resolver.Resolve(meta.Bun)
+	if err != nil {
+		return nil, "", fmt.Errorf("no Bun version satisfies '%s'", meta.Bun)
+	}
+	if bun.IsCanary(version) {
+		return nil, "", fmt.Errorf("cannot lock the rolling '%s' channel; pin a Bun version instead", bun.CanaryChannel)
+	}
+	r.log("Matched: %s", version.Original())
+
+	l := &lock.Lock{
+		Bun: lock.Bun{
+			Constraint: meta.Bun,
+			Version:    version.Original(),
+		},
+	}
+
+	for _, spec := range meta.Packages {
+		pv, err := r.registry.Resolve(spec)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to resolve %s: %w", spec, err)
+		}
+		r.log("Resolved: %s -> %s@%s", spec, pv.Name, pv.Version)
+
+		l.Packages = append(l.Packages, lock.Package{
+			Spec:      spec,
+			Name:      pv.Name,
+			Version:   pv.Version,
+			Integrity: pv.Integrity(),
+		})
+	}
+
+	if len(l.Packages) > 0 {
+		bunPath, err := r.downloader().GetBinary(version)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to download Bun: %w", err)
+		}
+		l.Tree, err = r.lockTree(bunPath, l.PinnedPackages())
+		if err != nil {
+			return nil, "", err
+		}
+		if err := l.Check(meta.Bun, meta.Packages); err != nil {
+			return nil, "", fmt.Errorf("bun installed different packages than the registry resolved: %w", err)
+		}
+	}
+
+	lockPath := lock.PathFor(scriptPath)
+	if err := l.Save(lockPath); err != nil {
+		return nil, "", fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	return l, lockPath, nil
+}
+
+// lockTree installs packages to a scratch directory and returns the lockfile
+// Bun wrote for them
+func (r *Runner) lockTree(bunPath string, packages []string) (*lock.Tree, error) {
+	tmpDir, err := os.MkdirTemp("", "buns-lock-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := r.installDeps(bunPath, tmpDir, packages, nil); err != nil {
+		return nil, fmt.Errorf("failed to install dependencies: %w", err)
+	}
+	// Bun before 1.2 only writes the binary lockfile
+	for _, name := range []string{lock.TextLockfile, lock.BinaryLockfile} {
+		data, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err == nil {
+			return lock.NewTree(name, data), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("bun install wrote no lockfile")
+}
+
+// verifyLockedPackages checks the registry still serves the locked packages
+// with the integrity recorded in the lock file
+func (r *Runner) verifyLockedPackages(l *lock.Lock) error {
+	for _, p := range l.Packages {
+		pv, err := r.registry.Resolve(p.Name + "@" + p.Version)
+		if err != nil {
+			return fmt.Errorf("failed to verify locked package %s@%s: %w", p.Name, p.Version, err)
+		}
+		if p.Integrity != "" && pv.Integrity() != p.Integrity {
+			return fmt.Errorf("integrity mismatch for %s@%s: lock has %s, registry has %s", p.Name, p.Version, p.Integrity, pv.Integrity())
+		}
+	}
+	return nil
+}
+
+// execScriptSandboxed runs the script in a sandbox
+func (r *Runner) execScriptSandboxed(sb sandbox.Sandbox, plan sandboxPlan, bunPath, scriptPath string, args []string, depsDir string) (int, error) {
 	// Start proxy if network is needed and we're sandboxing
 	var proxyMgr *proxy.Manager
 	var proxyEnv []string
@@ -186,13 +684,60 @@ func (r *Runner) execScriptSandboxed(bunPath, scriptPath string, opts RunOptions
 	var proxyPort int
 	var proxySOCKS5Port int
 
-	needsProxy := sb.IsSandboxed() && opts.Network
+	needsProxy := sb.IsSandboxed() && plan.network
+
+	var learn *learner
+	if plan.learn {
+		learn = newLearner()
+		defer learn.cleanup()
+	}
+
+	var traffic *netCounter
+	if plan.stats != "" {
+		traffic = &netCounter{}
+	}
 
 	if needsProxy {
 		r.log("Starting proxy server...")
+
+		var prompter proxy.Prompter
+		if plan.prompt {
+			// Prompt on the terminal itself, as the script owns stdin and stdout
+			tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+			if err != nil {
+				return 1, fmt.Errorf("--prompt needs a terminal: %w", err)
+			}
+			defer func() { _ = tty.Close() }()
+			prompter = proxy.NewTerminalPrompter(tty, tty)
+		}
+
+		var auditLog *proxy.AuditLog
+		if plan.auditLog != "" || learn != nil || traffic != nil {
+			var w io.Writer
+			if plan.auditLog != "" {
+				var closeLog func()
+				var err error
+				w, closeLog, err = openAuditLog(plan.auditLog)
+				if err != nil {
+					return 1, err
+				}
+				defer closeLog()
+			}
+			auditLog = proxy.NewAuditLog(w)
+			if learn != nil {
+				auditLog.Observe(learn.observe)
+			}
+			if traffic != nil {
+				auditLog.Observe(traffic.observe)
+			}
+		}
+
 		var err error
 		proxyMgr, err = proxy.NewManager(proxy.ManagerConfig{
-			AllowedHosts: opts.AllowHosts,
+			AllowedHosts: plan.allowHosts,
+			DeniedHosts:  plan.denyHosts,
+			Prompter:     prompter,
+			AuditLog:     auditLog,
 			Verbose:      r.verbose,
 		})
 		if err != nil {
@@ -222,27 +767,31 @@ func (r *Runner) execScriptSandboxed(bunPath, scriptPath string, opts RunOptions
 
 	// Build sandbox config
 	cfg := &sandbox.Config{
-		Network:         opts.Network,
-		AllowedHosts:    opts.AllowHosts,
+		Network:         plan.network,
+		AllowedHosts:    plan.allowHosts,
 		ProxySocketPath: proxySocketPath,
 		ProxyPort:       proxyPort,
 		ProxySOCKS5Port: proxySOCKS5Port,
+		InterceptPorts:  proxy.InterceptPorts(plan.allowHosts),
 
-		ReadablePaths: opts.AllowRead,
-		WritablePaths: opts.AllowWrite,
+		ReadablePaths: plan.allowRead,
+		WritablePaths: plan.allowWrite,
 		WorkDir:       workDir,
 
-		MemoryMB:   opts.MemoryMB,
-		Timeout:    time.Duration(opts.TimeoutSecs) * time.Second,
-		CPUSeconds: opts.CPUSeconds,
+		MemoryMB:    plan.memoryMB,
+		Timeout:     time.Duration(plan.timeoutSecs) * time.Second,
+		GracePeriod: time.Duration(plan.graceSecs) * time.Second,
+		CPUSeconds:  plan.cpuSeconds,
+		CPUs:        plan.cpus,
+		MaxProcs:    plan.maxProcs,
 
 		BunBinary:   bunPath,
 		ScriptPath:  scriptPath,
-		ScriptArgs:  opts.Args,
+		ScriptArgs:  args,
 		NodeModules: nodeModules,
 
 		Env:            proxyEnv,
-		AllowedEnvVars: opts.AllowEnv,
+		AllowedEnvVars: plan.allowEnv,
 
 		Stdin:   os.Stdin,
 		Stdout:  os.Stdout,
@@ -250,13 +799,20 @@ func (r *Runner) execScriptSandboxed(bunPath, scriptPath string, opts RunOptions
 		Verbose: r.verbose,
 	}
 
+	if learn != nil {
+		cfg.BunWrapper, err = learn.startTrace()
+		if err != nil {
+			return 1, err
+		}
+	}
+
 	r.log("Using sandbox: %s", sb.Name())
 
 	// Create context with timeout
 	ctx := context.Background()
-	if opts.TimeoutSecs > 0 {
+	if plan.timeoutSecs > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.TimeoutSecs)*time.Second)
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(plan.timeoutSecs)*time.Second)
 		defer cancel()
 	}
 
@@ -264,11 +820,104 @@ func (r *Runner) execScriptSandboxed(bunPath, scriptPath string, opts RunOptions
 
 	result, err := sb.Execute(ctx, cfg)
 	if err != nil {
-		return 1, fmt.Errorf("execution failed: %w", err)
+		fmt.Fprintf(os.Stderr, "[buns] Sandbox could not be set up (%s): %v\n", sb.Name(), err)
+		return ExitSandboxSetup, nil
 	}
 
-	r.log("Exit code: %d", result.ExitCode)
-	return result.ExitCode, nil
+	if proxyMgr != nil && plan.persist {
+		if hosts := proxyMgr.AlwaysAllowed(); len(hosts) > 0 {
+			r.rememberHosts(scriptPath, hosts)
+		}
+	}
+
+	// Stop the proxies first so connections still open are recorded
+	if proxyMgr != nil && (learn != nil || traffic != nil) {
+		proxyMgr.Stop()
+	}
+
+	if learn != nil {
+		if err := r.reportLearned(learn, bunPath, scriptPath, depsDir, workDir, plan.learnOutput); err != nil {
+			return 1, err
+		}
+	}
+
+	exitCode, message := terminationExit(result, plan)
+	if message != "" {
+		fmt.Fprintf(os.Stderr, "[buns] %s\n", message)
+	}
+	r.log("Exit code: %d (%s)", exitCode, result.Termination)
+
+	if plan.stats != "" {
+		if proxyMgr != nil {
+			result.Usage.NetSentBytes = traffic.sent.Load()
+			result.Usage.NetReceivedBytes = traffic.received.Load()
+		}
+		limits := &statsLimits{MemoryMB: plan.memoryMB, TimeoutSecs: plan.timeoutSecs, CPUSecs: plan.cpuSeconds}
+		r.reportStats(plan.stats, newRunStats(sb.Name(), exitCode, result, proxyMgr != nil, limits))
+	}
+
+	return exitCode, nil
+}
+
+// openAuditLog opens the audit log destination: stderr for "-", otherwise a
+// file appended to so that several runs can share one log
+func openAuditLog(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stderr, func() {}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return f, func() { _ = f.Close() }, nil
+}
+
+// rememberHosts adds hosts allowed "always" at a prompt to the script's
+// [sandbox] allow-host, so later runs don't ask again
+func (r *Runner) rememberHosts(scriptPath string, hosts []string) {
+	content, err := os.ReadFile(scriptPath)
+	var updated []byte
+	if err == nil {
+		updated, err = metadata.AddAllowedHosts(content, hosts)
+	}
+	if err == nil && !bytes.Equal(updated, content) {
+		err = replaceFile(scriptPath, updated)
+		if err == nil && !r.quiet {
+			fmt.Fprintf(os.Stderr, "[buns] Added %s to [sandbox] allow-host in %s\n", strings.Join(hosts, ", "), filepath.Base(scriptPath))
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[buns] Warning: could not remember allowed hosts in %s: %v\n", filepath.Base(scriptPath), err)
+	}
+}
+
+// replaceFile replaces the contents of path, keeping its mode. The new
+// contents are written beside it and renamed over it, so the file is never
+// left half written.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 const (
@@ -335,6 +984,15 @@ func (r *Runner) typeCheckScript(bunPath, scriptPath string, packages []string,
 	return exitCode, nil
 }
 
+// typesVersion returns the Bun version to pin @types/bun to. Canary builds have
+// no matching types release, so they use the latest.
+func typesVersion(v *semver.Version) string {
+	if bun.IsCanary(v) {
+		return ""
+	}
+	return v.Original()
+}
+
 func buildTypeCheckPackages(packages []string, bunVersion string, pinBunTypes bool) []string {
 	result := make([]string, 0, len(packages)+2)
 	result = append(result, packages...)
@@ -361,11 +1019,13 @@ func (r *Runner) ensureTypeCheckDeps(bunPath string, packages []string) (string,
 	}
 
 	r.log("Typecheck cache miss: %s", typeCheckDir)
-	if err := os.RemoveAll(typeCheckDir); err != nil {
-		return "", err
+	if r.cacheOnly {
+		return "", fmt.Errorf("typecheck dependencies are not cached")
 	}
-	if err := r.installDeps(bunPath, typeCheckDir, packages); err != nil {
-		_ = os.RemoveAll(typeCheckDir)
+	err := cache.Populate(typeCheckDir, cache.IsPackageInstallHit, func(tmpDir string) error {
+		return r.installDeps(bunPath, tmpDir, packages, nil)
+	})
+	if err != nil {
 		return "", err
 	}
 
@@ -438,8 +1098,10 @@ func (r *Runner) runTypeCheck(bunPath, typeCheckDir, configPath string) (int, er
 	return 0, nil
 }
 
-// installDeps installs packages to the deps directory
-func (r *Runner) installDeps(bunPath, depsDir string, packages []string) error {
+// installDeps installs packages to the deps directory. With a locked tree, Bun
+// installs exactly that tree and fails if it no longer matches the packages or
+// a tarball doesn't match its recorded integrity.
+func (r *Runner) installDeps(bunPath, depsDir string, packages []string, tree *lock.Tree) error {
 	if err := os.MkdirAll(depsDir, 0755); err != nil {
 		return err
 	}
@@ -470,8 +1132,30 @@ func (r *Runner) installDeps(bunPath, depsDir string, packages []string) error {
 		return err
 	}
 
+	// Point bun at the configured registries. The .npmrc may hold credentials,
+	// so it is removed once installed rather than left where sandboxes can read it.
+	if r.registry != nil && !r.registry.Config().IsDefault() {
+		npmrcPath := filepath.Join(depsDir, ".npmrc")
+		if err := os.WriteFile(npmrcPath, r.registry.Config().Npmrc(), 0600); err != nil {
+			return err
+		}
+		defer func() { _ = os.Remove(npmrcPath) }()
+	}
+
+	args := []string{"install"}
+	if tree != nil {
+		data, err := tree.Data()
+		if err != nil {
+			return fmt.Errorf("invalid dependency tree in lock file: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(depsDir, tree.File), data, 0644); err != nil {
+			return err
+		}
+		args = append(args, "--frozen-lockfile")
+	}
+
 	// Run bun install
-	cmd := exec.Command(bunPath, "install")
+	cmd := exec.Command(bunPath, args...)
 	cmd.Dir = depsDir
 	if !r.quiet {
 		cmd.Stdout = os.Stdout
@@ -482,7 +1166,7 @@ func (r *Runner) installDeps(bunPath, depsDir string, packages []string) error {
 }
 
 // execScript runs the script with the bun binary (non-sandboxed)
-func (r *Runner) execScript(bunPath, scriptPath string, args []string, depsDir string) (int, error) {
+func (r *Runner) execScript(bunPath, scriptPath string, args []string, depsDir, stats string) (int, error) {
 	cmdArgs := []string{"run", scriptPath}
 	cmdArgs = append(cmdArgs, args...)
 
@@ -499,16 +1183,37 @@ func (r *Runner) execScript(bunPath, scriptPath string, args []string, depsDir s
 		cmd.Env = env
 	}
 
-	err := cmd.Run()
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		// Relay Ctrl-C and SIGTERM to Bun and anything it started
+		stopForwarding := sandbox.ForwardSignals(sandbox.ProcessTree(cmd.Process.Pid))
+		err = cmd.Wait()
+		stopForwarding()
+	}
+	wall := time.Since(start)
+
+	result := &sandbox.Result{}
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return exitErr.ExitCode(), nil
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 1, err
 		}
-		return 1, err
+		result.ExitCode = exitErr.ExitCode()
 	}
+	result.Termination, result.Signal = sandbox.ProcessTermination(context.Background(), cmd.ProcessState)
+	result.Usage = sandbox.ProcessUsage(cmd.ProcessState, wall)
 
-	r.log("Exit code: 0")
-	return 0, nil
+	exitCode, message := terminationExit(result, sandboxPlan{})
+	if message != "" {
+		fmt.Fprintf(os.Stderr, "[buns] %s\n", message)
+	}
+	r.log("Exit code: %d (%s)", exitCode, result.Termination)
+
+	if stats != "" {
+		r.reportStats(stats, newRunStats("none", exitCode, result, false, nil))
+	}
+	return exitCode, nil
 }
 
 func (r *Runner) log(format string, args ...interface{}) {
@@ -1,12 +1,20 @@
 package exec
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/eddmann/buns/internal/bun"
 	"github.com/eddmann/buns/internal/cache"
+	"github.com/eddmann/buns/internal/lock"
+	"github.com/eddmann/buns/internal/npm"
 )
 
 func TestParsePackageSpec(t *testing.T) {
@@ -74,7 +82,7 @@ touch marker.txt
 
 	// Create a minimal runner and execute the script
 	r := &Runner{verbose: false, quiet: true}
-	exitCode, err := r.execScript(fakeBun, scriptPath, nil, "")
+	exitCode, err := r.execScript(fakeBun, scriptPath, nil, "", "")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -119,7 +127,7 @@ exit 42
 	}
 
 	r := &Runner{verbose: false, quiet: true}
-	exitCode, err := r.execScript(fakeBun, scriptPath, nil, "")
+	exitCode, err := r.execScript(fakeBun, scriptPath, nil, "", "")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -130,6 +138,35 @@ exit 42
 	}
 }
 
+func TestExecScript_reports_signal_as_exit_code(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	fakeBunScript := `#!/bin/sh
+shift
+exec /bin/sh "$@"
+`
+	if err := os.WriteFile(fakeBun, []byte(fakeBunScript), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	scriptPath := filepath.Join(tmpDir, "killed.sh")
+	if err := os.WriteFile(scriptPath, []byte("kill -TERM $$\n"), 0755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	r := &Runner{verbose: false, quiet: true}
+	exitCode, err := r.execScript(fakeBun, scriptPath, nil, "", "")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exitCode != ExitSignalBase+15 {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitSignalBase+15)
+	}
+}
+
 func TestExecScript_passes_arguments_to_script(t *testing.T) {
 	tmpDir := t.TempDir()
 	outputFile := filepath.Join(tmpDir, "args.txt")
@@ -153,7 +190,7 @@ echo "$1" > "` + outputFile + `"
 	}
 
 	r := &Runner{verbose: false, quiet: true}
-	exitCode, err := r.execScript(fakeBun, scriptPath, []string{"test-value"}, "")
+	exitCode, err := r.execScript(fakeBun, scriptPath, []string{"test-value"}, "", "")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -201,7 +238,7 @@ echo "$NODE_PATH" > "` + outputFile + `"
 	}
 
 	r := &Runner{verbose: false, quiet: true}
-	exitCode, err := r.execScript(fakeBun, scriptPath, nil, depsDir)
+	exitCode, err := r.execScript(fakeBun, scriptPath, nil, depsDir, "")
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -383,3 +420,381 @@ func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
+
+func TestPrepareDeps_shares_directory_for_identical_resolved_sets(t *testing.T) {
+	tmpDir := t.TempDir()
+	installs := filepath.Join(tmpDir, "installs.txt")
+	t.Setenv("INSTALL_LOG", installs)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "zod", "dist-tags": {"latest": "3.24.1"}, "versions": {
+			"3.23.8": {"name": "zod", "version": "3.23.8"},
+			"3.24.1": {"name": "zod", "version": "3.24.1"}
+		}}`))
+	}))
+	defer server.Close()
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	fakeBunScript := `#!/bin/sh
+mkdir -p node_modules/zod
+echo install >> "$INSTALL_LOG"
+`
+	if err := os.WriteFile(fakeBun, []byte(fakeBunScript), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	c := cache.New(filepath.Join(tmpDir, "cache"))
+	r := &Runner{cache: c, registry: npm.NewRegistryWithURL(server.URL), quiet: true}
+
+	dir1, err := r.prepareDeps(fakeBun, []string{"zod@^3.0"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir2, err := r.prepareDeps(fakeBun, []string{"zod@^3"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dir1 != dir2 {
+		t.Errorf("deps dirs differ: %s vs %s", dir1, dir2)
+	}
+
+	log, err := os.ReadFile(installs)
+	if err != nil {
+		t.Fatalf("failed to read install log: %v", err)
+	}
+	if strings.Count(string(log), "install") != 1 {
+		t.Errorf("bun install ran %d times, want 1", strings.Count(string(log), "install"))
+	}
+
+	m, err := cache.ReadDepsManifest(dir1)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	if len(m.Resolved) != 1 || m.Resolved[0] != "zod@3.24.1" {
+		t.Errorf("resolved = %v, want [zod@3.24.1]", m.Resolved)
+	}
+}
+
+func TestPrepareDeps_installs_once_when_run_concurrently(t *testing.T) {
+	tmpDir := t.TempDir()
+	installs := filepath.Join(tmpDir, "installs.txt")
+	t.Setenv("INSTALL_LOG", installs)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "zod", "dist-tags": {"latest": "3.24.1"}, "versions": {
+			"3.24.1": {"name": "zod", "version": "3.24.1"}
+		}}`))
+	}))
+	defer server.Close()
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	fakeBunScript := `#!/bin/sh
+echo install >> "$INSTALL_LOG"
+mkdir -p node_modules/zod
+sleep 0.2
+echo "export {}" > node_modules/zod/index.js
+`
+	if err := os.WriteFile(fakeBun, []byte(fakeBunScript), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	c := cache.New(filepath.Join(tmpDir, "cache"))
+
+	const runs = 8
+	dirs := make([]string, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &Runner{cache: c, registry: npm.NewRegistryWithURL(server.URL), quiet: true}
+			dirs[i], errs[i] = r.prepareDeps(fakeBun, []string{"zod@^3.0"}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < runs; i++ {
+		if errs[i] != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, errs[i])
+		}
+		if dirs[i] != dirs[0] {
+			t.Errorf("run %d: deps dir = %s, want %s", i, dirs[i], dirs[0])
+		}
+	}
+
+	log, err := os.ReadFile(installs)
+	if err != nil {
+		t.Fatalf("failed to read install log: %v", err)
+	}
+	if n := strings.Count(string(log), "install"); n != 1 {
+		t.Errorf("bun install ran %d times, want 1", n)
+	}
+
+	if !fileExists(filepath.Join(dirs[0], "node_modules", "zod", "index.js")) {
+		t.Error("expected complete install in deps dir")
+	}
+	if _, err := cache.ReadDepsManifest(dirs[0]); err != nil {
+		t.Errorf("failed to read manifest: %v", err)
+	}
+
+	hashes, err := c.ListDepsHashes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hashes) != 1 {
+		t.Errorf("deps hashes = %v, want exactly one", hashes)
+	}
+}
+
+func TestPrepareDeps_skips_the_registry_while_the_resolution_is_fresh(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"name": "zod", "dist-tags": {"latest": "3.24.1"}, "versions": {"3.24.1": {"name": "zod", "version": "3.24.1"}}}`))
+	}))
+	defer server.Close()
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	if err := os.WriteFile(fakeBun, []byte("#!/bin/sh\nmkdir -p node_modules/zod\n"), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	c := cache.New(filepath.Join(tmpDir, "cache"))
+	r := &Runner{cache: c, registry: npm.NewRegistryWithURL(server.URL), quiet: true}
+
+	dir, err := r.prepareDeps(fakeBun, []string{"zod@^3.0"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.prepareDeps(fakeBun, []string{"zod@^3"}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("registry requests = %d, want 1", got)
+	}
+
+	m, err := cache.ReadDepsManifest(dir)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	m.ResolvedAt = time.Now().Add(-cache.ResolutionTTL)
+	if err := cache.WriteDepsManifest(dir, m); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	if _, err := r.prepareDeps(fakeBun, []string{"zod@^3.0"}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("registry requests = %d, want 2 once the resolution is stale", got)
+	}
+	if m, _ := cache.ReadDepsManifest(dir); m == nil || time.Since(m.ResolvedAt) > time.Minute {
+		t.Errorf("manifest = %+v, want the resolution time refreshed", m)
+	}
+}
+
+func TestPrepareDeps_reuses_cached_install_when_registry_unreachable(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := cache.New(filepath.Join(tmpDir, "cache"))
+
+	dir := c.DepsDirForHash(cache.HashPackages([]string{"zod@3.24.1"}))
+	if err := os.MkdirAll(filepath.Join(dir, "node_modules", "zod"), 0755); err != nil {
+		t.Fatalf("failed to create deps dir: %v", err)
+	}
+	manifest := &cache.DepsManifest{Requested: []string{"zod@^3.0"}, Resolved: []string{"zod@3.24.1"}}
+	if err := cache.WriteDepsManifest(dir, manifest); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	r := &Runner{cache: c, registry: npm.NewRegistryWithURL("http://127.0.0.1:1"), quiet: true}
+
+	got, err := r.prepareDeps("/nonexistent/bun", []string{"zod@^3.0"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != dir {
+		t.Errorf("deps dir = %s, want %s", got, dir)
+	}
+}
+
+func TestInstallDeps_writes_registry_config_for_bun_and_removes_it(t *testing.T) {
+	tmpDir := t.TempDir()
+	seen := filepath.Join(tmpDir, "npmrc-seen.txt")
+	t.Setenv("NPMRC_SEEN", seen)
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	fakeBunScript := `#!/bin/sh
+cp .npmrc "$NPMRC_SEEN"
+mkdir -p node_modules/widgets
+`
+	if err := os.WriteFile(fakeBun, []byte(fakeBunScript), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	cfg := npm.DefaultConfig()
+	cfg.Scopes["@ourco"] = npm.RegistryConfig{URL: "http://127.0.0.1:4873/", Token: "s3cret"}
+	r := &Runner{registry: npm.NewRegistryWithConfig(cfg), quiet: true}
+
+	depsDir := filepath.Join(tmpDir, "deps")
+	if err := r.installDeps(fakeBun, depsDir, []string{"@ourco/widgets@1.0.0"}, nil); err != nil {
+		t.Fatalf("installDeps failed: %v", err)
+	}
+
+	npmrc, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatalf("bun install did not see an .npmrc: %v", err)
+	}
+	for _, want := range []string{"@ourco:registry=http://127.0.0.1:4873/", "//127.0.0.1:4873/:_authToken=s3cret"} {
+		if !strings.Contains(string(npmrc), want) {
+			t.Errorf(".npmrc missing %q:\n%s", want, npmrc)
+		}
+	}
+
+	if fileExists(filepath.Join(depsDir, ".npmrc")) {
+		t.Error(".npmrc with credentials left in deps directory")
+	}
+}
+
+func TestInstallDeps_replays_the_locked_tree(t *testing.T) {
+	tmpDir := t.TempDir()
+	seen := filepath.Join(tmpDir, "seen.txt")
+	t.Setenv("BUN_SEEN", seen)
+
+	fakeBun := filepath.Join(tmpDir, "fakebun")
+	fakeBunScript := `#!/bin/sh
+{ echo "$@"; cat bun.lock; } > "$BUN_SEEN"
+mkdir -p node_modules/zod
+`
+	if err := os.WriteFile(fakeBun, []byte(fakeBunScript), 0755); err != nil {
+		t.Fatalf("failed to write fake bun: %v", err)
+	}
+
+	r := &Runner{quiet: true}
+	tree := lock.NewTree(lock.TextLockfile, []byte(`{"lockfileVersion": 1}`))
+	if err := r.installDeps(fakeBun, filepath.Join(tmpDir, "deps"), []string{"zod@3.24.1"}, tree); err != nil {
+		t.Fatalf("installDeps failed: %v", err)
+	}
+
+	got, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatalf("fake bun did not run: %v", err)
+	}
+	if want := "install --frozen-lockfile\n" + tree.Content; string(got) != want {
+		t.Errorf("bun saw %q, want %q", got, want)
+	}
+}
+
+func TestPlanOffline(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := cache.New(filepath.Join(tmpDir, "cache"))
+
+	bunDir := filepath.Join(c.BunDir(), "1.1.34")
+	if err := os.MkdirAll(bunDir, 0755); err != nil {
+		t.Fatalf("failed to create bun dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bunDir, "bun"), []byte("bin"), 0755); err != nil {
+		t.Fatalf("failed to write bun: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bunDir, bun.ChecksumFile), nil, 0644); err != nil {
+		t.Fatalf("failed to write checksums: %v", err)
+	}
+
+	depsDir := c.DepsDirForHash(cache.HashPackages([]string{"zod@3.24.1", "chalk@5.3.0"}))
+	if err := os.MkdirAll(filepath.Join(depsDir, "node_modules", "zod"), 0755); err != nil {
+		t.Fatalf("failed to create deps dir: %v", err)
+	}
+	manifest := &cache.DepsManifest{
+		Requested: []string{"zod@^3.0", "chalk@^5.0"},
+		Resolved:  []string{"zod@3.24.1", "chalk@5.3.0"},
+	}
+	if err := cache.WriteDepsManifest(depsDir, manifest); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	r := &Runner{cache: c, quiet: true}
+
+	t.Run("reuses cached deps that satisfy the constraints", func(t *testing.T) {
+		plan, err := r.planOffline(">=1.1", nil, []string{"zod@^3.20"}, nil, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.version.Original() != "1.1.34" {
+			t.Errorf("version = %s, want 1.1.34", plan.version.Original())
+		}
+		if plan.depsDir != depsDir {
+			t.Errorf("deps dir = %s, want %s", plan.depsDir, depsDir)
+		}
+	})
+
+	t.Run("lists everything missing up front", func(t *testing.T) {
+		_, err := r.planOffline(">=1.2", nil, []string{"zod@^4.0", "chalk@^5.0", "lodash"}, nil, true)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		msg := err.Error()
+		for _, want := range []string{"bun satisfying '>=1.2'", "zod@^4.0", "lodash"} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("error %q does not mention %q", msg, want)
+			}
+		}
+		if strings.Contains(msg, "chalk") {
+			t.Errorf("error %q should not list cached chalk", msg)
+		}
+	})
+}
+
+func TestSatisfiedBy(t *testing.T) {
+	resolved := []string{"zod@3.24.1", "@types/node@20.11.0"}
+
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"zod", true},
+		{"zod@^3.0", true},
+		{"zod@~3.23.0", false},
+		{"zod@latest", true},
+		{"@types/node@^20", true},
+		{"chalk@^5.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := satisfiedBy(tt.spec, resolved); got != tt.want {
+				t.Errorf("satisfiedBy(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRememberHosts_keeps_the_script_mode(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "script.ts")
+	if err := os.WriteFile(scriptPath, []byte("// buns\n// [sandbox]\n// allow-host = [\"a.example\"]\n\nconsole.log(1)\n"), 0750); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	r := &Runner{quiet: true}
+	r.rememberHosts(scriptPath, []string{"b.example"})
+
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatalf("failed to read script: %v", err)
+	}
+	if !strings.Contains(string(content), `"b.example"`) {
+		t.Errorf("script = %q, want b.example allowed", content)
+	}
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("mode = %o, want 750", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(scriptPath)); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the script", len(entries))
+	}
+}
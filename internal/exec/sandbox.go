@@ -0,0 +1,181 @@
+package exec
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"github.com/eddmann/buns/internal/metadata"
+	"github.com/eddmann/buns/internal/sandbox"
+)
+
+// Sandbox defaults, used when neither the command line nor the script sets a value
+const (
+	DefaultMemoryMB    = 128
+	DefaultTimeoutSecs = 30
+	DefaultGraceSecs   = 5 // Between SIGTERM and SIGKILL once the timeout expires
+	DefaultCPUSeconds  = 30
+)
+
+// DefaultMaxProcs returns the default limit on the processes and threads a
+// sandboxed script runs at once, enforced through cgroups. Bun starts threads
+// in proportion to the CPUs, so the limit is 256, or 64 per CPU on machines
+// with more than four.
+func DefaultMaxProcs() int {
+	return max(256, 64*runtime.NumCPU())
+}
+
+// Exit codes for runs that didn't end by themselves, so wrappers can tell
+// them apart from the script failing
+const (
+	ExitMemoryLimit  = 122 // Killed for exceeding the memory limit
+	ExitCPULimit     = 123 // Killed for exceeding the CPU time limit
+	ExitTimeout      = 124 // Killed when the timeout expired
+	ExitSandboxSetup = 125 // The sandbox couldn't be set up
+	ExitSignalBase   = 128 // Plus N for a script killed by signal N, as shells do
+)
+
+// sandboxPlan is the effective sandbox configuration for a run
+type sandboxPlan struct {
+	enabled     bool // Full filesystem + process isolation
+	network     bool
+	allowHosts  []string
+	denyHosts   []string
+	allowRead   []string
+	allowWrite  []string
+	allowEnv    []string
+	memoryMB    int
+	timeoutSecs int
+	graceSecs   int
+	cpuSeconds  int
+	cpus        float64 // CPU bandwidth in cores (0 = unlimited)
+	maxProcs    int     // Processes and threads (0 = unlimited)
+
+	prompt      bool   // Ask on the terminal about hosts outside allowHosts
+	persist     bool   // Write hosts allowed "always" back to the script
+	auditLog    string // Record network access as JSON lines to this file ("-" = stderr)
+	learn       bool   // Record what the script accesses to suggest a policy
+	learnOutput string // Write the learned policy to this file instead of stderr
+	stats       string // Print resource usage after the run: StatsText or StatsJSON ("" = don't)
+	backend     string // Sandbox backend to use, one of sandbox.Backends ("" = detect)
+}
+
+// resolveSandboxPlan combines the script's [sandbox] table with command line
+// flags. Flags take precedence over the script, which takes precedence over
+// the defaults; allow-lists given as flags replace the script's, and
+// deny-lists from both are combined.
+func resolveSandboxPlan(script, flags metadata.Sandbox) sandboxPlan {
+	policy := script.Merge(flags)
+
+	plan := sandboxPlan{
+		network:     true,
+		allowHosts:  policy.AllowHost,
+		denyHosts:   policy.DenyHost,
+		allowRead:   policy.AllowRead,
+		allowWrite:  policy.AllowWrite,
+		allowEnv:    policy.AllowEnv,
+		memoryMB:    DefaultMemoryMB,
+		timeoutSecs: DefaultTimeoutSecs,
+		graceSecs:   DefaultGraceSecs,
+		cpuSeconds:  DefaultCPUSeconds,
+		maxProcs:    DefaultMaxProcs(),
+	}
+
+	if policy.Enabled != nil {
+		plan.enabled = *policy.Enabled
+	}
+	if policy.Offline != nil {
+		plan.network = !*policy.Offline
+	}
+	if policy.Memory != nil {
+		plan.memoryMB = *policy.Memory
+	}
+	if policy.Timeout != nil {
+		plan.timeoutSecs = *policy.Timeout
+	}
+	if policy.GracePeriod != nil {
+		plan.graceSecs = *policy.GracePeriod
+	}
+	if policy.CPU != nil {
+		plan.cpuSeconds = *policy.CPU
+	}
+	if policy.CPUs != nil {
+		plan.cpus = *policy.CPUs
+	}
+	if policy.MaxProcs != nil {
+		plan.maxProcs = *policy.MaxProcs
+	}
+
+	return plan
+}
+
+// selectSandbox picks the sandbox that provides the plan's isolation.
+// Network restrictions and auditing alone only need a network sandbox, which
+// routes all traffic through the proxy.
+func selectSandbox(plan sandboxPlan, detect func(fullSandbox bool) sandbox.Sandbox) (sandbox.Sandbox, error) {
+	if plan.backend != "" {
+		return chooseBackend(plan)
+	}
+
+	if plan.enabled {
+		sb := detect(true)
+		if !sb.IsSandboxed() {
+			return nil, fmt.Errorf("sandboxing requested (--sandbox or [sandbox] enabled) but no sandbox is available on this system")
+		}
+		return sb, nil
+	}
+
+	if plan.needsNetworkSandbox() {
+		sb := detect(false)
+		if !sb.IsSandboxed() {
+			return nil, fmt.Errorf("offline/allow-host/deny-host/prompt/audit-log/learn requires network sandboxing, but no sandbox is available on this system")
+		}
+		return sb, nil
+	}
+
+	return &sandbox.None{}, nil
+}
+
+// chooseBackend returns the backend picked with --sandbox-backend for runs
+// that need a sandbox. A full sandbox backend is used even when only network
+// isolation is needed.
+func chooseBackend(plan sandboxPlan) (sandbox.Sandbox, error) {
+	sb, err := sandbox.Backend(plan.backend)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.enabled && !plan.needsNetworkSandbox() {
+		return &sandbox.None{}, nil
+	}
+	if plan.enabled && !sandbox.FullIsolation(sb) {
+		return nil, fmt.Errorf("the %s sandbox backend only isolates the network, but sandboxing was requested (--sandbox or [sandbox] enabled)", plan.backend)
+	}
+	if !sb.Available() {
+		return nil, fmt.Errorf("the %s sandbox backend is not available on this system (run 'buns sandbox doctor' to see why)", plan.backend)
+	}
+	return sb, nil
+}
+
+// needsNetworkSandbox reports whether the plan restricts or watches the
+// network, which needs all traffic to go through the proxy
+func (p sandboxPlan) needsNetworkSandbox() bool {
+	return !p.network || len(p.allowHosts) > 0 || len(p.denyHosts) > 0 || p.prompt || p.auditLog != "" || p.learn
+}
+
+// terminationExit maps how a run ended to buns' exit code, with a message
+// explaining runs that didn't end by themselves
+func terminationExit(result *sandbox.Result, plan sandboxPlan) (int, string) {
+	switch result.Termination {
+	case sandbox.TerminationMemoryLimit:
+		return ExitMemoryLimit, fmt.Sprintf("Script was killed for exceeding the memory limit (%d MB); raise it with --memory", plan.memoryMB)
+	case sandbox.TerminationCPULimit:
+		return ExitCPULimit, fmt.Sprintf("Script was killed for exceeding the CPU time limit (%ds); raise it with --cpu", plan.cpuSeconds)
+	case sandbox.TerminationTimeout:
+		return ExitTimeout, fmt.Sprintf("Script timed out after %ds; raise it with --timeout", plan.timeoutSecs)
+	case sandbox.TerminationSignal:
+		return ExitSignalBase + result.Signal, fmt.Sprintf("Script was killed by signal %d (%s)", result.Signal, syscall.Signal(result.Signal))
+	case sandbox.TerminationSetupFailed:
+		return ExitSandboxSetup, "Sandbox could not be set up"
+	}
+	return result.ExitCode, ""
+}
@@ -0,0 +1,189 @@
+package exec
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eddmann/buns/internal/metadata"
+	"github.com/eddmann/buns/internal/sandbox"
+)
+
+// stubSandbox records nothing and reports a fixed isolation level
+type stubSandbox struct {
+	name      string
+	sandboxed bool
+}
+
+func (s *stubSandbox) Name() string      { return s.name }
+func (s *stubSandbox) IsSandboxed() bool { return s.sandboxed }
+func (s *stubSandbox) Available() bool   { return s.sandboxed }
+func (s *stubSandbox) Execute(ctx context.Context, cfg *sandbox.Config) (*sandbox.Result, error) {
+	return &sandbox.Result{}, nil
+}
+
+func TestResolveSandboxPlan(t *testing.T) {
+	yes := true
+	memory, timeout := 512, 5
+
+	t.Run("defaults with no script table or flags", func(t *testing.T) {
+		plan := resolveSandboxPlan(metadata.Sandbox{}, metadata.Sandbox{})
+
+		want := sandboxPlan{
+			network:     true,
+			memoryMB:    DefaultMemoryMB,
+			timeoutSecs: DefaultTimeoutSecs,
+			graceSecs:   DefaultGraceSecs,
+			cpuSeconds:  DefaultCPUSeconds,
+			maxProcs:    DefaultMaxProcs(),
+		}
+		if !reflect.DeepEqual(plan, want) {
+			t.Errorf("plan = %+v, want %+v", plan, want)
+		}
+	})
+
+	t.Run("script table applies when flags are unset", func(t *testing.T) {
+		script := metadata.Sandbox{
+			Offline:  &yes,
+			AllowEnv: []string{"HOME"},
+			Memory:   &memory,
+		}
+		plan := resolveSandboxPlan(script, metadata.Sandbox{})
+
+		if plan.network {
+			t.Error("expected network to be disabled by the script")
+		}
+		if plan.memoryMB != 512 {
+			t.Errorf("memoryMB = %d, want 512", plan.memoryMB)
+		}
+		if plan.timeoutSecs != DefaultTimeoutSecs {
+			t.Errorf("timeoutSecs = %d, want default", plan.timeoutSecs)
+		}
+		if !reflect.DeepEqual(plan.allowEnv, []string{"HOME"}) {
+			t.Errorf("allowEnv = %v", plan.allowEnv)
+		}
+	})
+
+	t.Run("flags override the script", func(t *testing.T) {
+		script := metadata.Sandbox{Timeout: &timeout, AllowHost: []string{"a.example"}}
+		cliTimeout := 60
+		flags := metadata.Sandbox{Timeout: &cliTimeout, AllowHost: []string{"b.example"}}
+
+		plan := resolveSandboxPlan(script, flags)
+
+		if plan.timeoutSecs != 60 {
+			t.Errorf("timeoutSecs = %d, want 60", plan.timeoutSecs)
+		}
+		if !reflect.DeepEqual(plan.allowHosts, []string{"b.example"}) {
+			t.Errorf("allowHosts = %v", plan.allowHosts)
+		}
+	})
+
+	t.Run("grace period of zero from the flags", func(t *testing.T) {
+		grace, none := 10, 0
+		plan := resolveSandboxPlan(metadata.Sandbox{GracePeriod: &grace}, metadata.Sandbox{GracePeriod: &none})
+
+		if plan.graceSecs != 0 {
+			t.Errorf("graceSecs = %d, want 0", plan.graceSecs)
+		}
+	})
+
+	t.Run("cpus from the script", func(t *testing.T) {
+		cpus := 1.5
+		plan := resolveSandboxPlan(metadata.Sandbox{CPUs: &cpus}, metadata.Sandbox{})
+
+		if plan.cpus != 1.5 {
+			t.Errorf("cpus = %v, want 1.5", plan.cpus)
+		}
+	})
+
+	t.Run("max-procs from the flags", func(t *testing.T) {
+		procs, unlimited := 64, 0
+		plan := resolveSandboxPlan(metadata.Sandbox{MaxProcs: &procs}, metadata.Sandbox{MaxProcs: &unlimited})
+
+		if plan.maxProcs != 0 {
+			t.Errorf("maxProcs = %d, want 0", plan.maxProcs)
+		}
+	})
+}
+
+func TestSelectSandbox(t *testing.T) {
+	full := &stubSandbox{name: "full", sandboxed: true}
+	network := &stubSandbox{name: "network", sandboxed: true}
+	detect := func(fullSandbox bool) sandbox.Sandbox {
+		if fullSandbox {
+			return full
+		}
+		return network
+	}
+	unavailable := func(bool) sandbox.Sandbox { return &sandbox.None{} }
+
+	tests := []struct {
+		name    string
+		plan    sandboxPlan
+		detect  func(bool) sandbox.Sandbox
+		want    string
+		wantErr bool
+	}{
+		{"no restrictions", sandboxPlan{network: true}, detect, "none", false},
+		{"enabled", sandboxPlan{enabled: true, network: true}, detect, "full", false},
+		{"offline only", sandboxPlan{}, detect, "network", false},
+		{"allow-host only", sandboxPlan{network: true, allowHosts: []string{"a.example"}}, detect, "network", false},
+		{"prompt only", sandboxPlan{network: true, prompt: true}, detect, "network", false},
+		{"audit log only", sandboxPlan{network: true, auditLog: "-"}, detect, "network", false},
+		{"learn only", sandboxPlan{network: true, learn: true}, detect, "network", false},
+		{"enabled but unavailable", sandboxPlan{enabled: true, network: true}, unavailable, "", true},
+		{"offline but unavailable", sandboxPlan{}, unavailable, "", true},
+		{"backend without restrictions", sandboxPlan{network: true, backend: "bwrap"}, detect, "none", false},
+		{"unknown backend", sandboxPlan{enabled: true, backend: "docker"}, detect, "", true},
+		{"network-only backend when enabled", sandboxPlan{enabled: true, network: true, backend: "unshare"}, detect, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb, err := selectSandbox(tt.plan, tt.detect)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sb.Name() != tt.want {
+				t.Errorf("sandbox = %s, want %s", sb.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminationExit(t *testing.T) {
+	plan := sandboxPlan{memoryMB: 64, timeoutSecs: 10, cpuSeconds: 5}
+	tests := []struct {
+		name        string
+		result      sandbox.Result
+		wantCode    int
+		wantMessage string
+	}{
+		{"normal", sandbox.Result{ExitCode: 3}, 3, ""},
+		{"memory limit", sandbox.Result{ExitCode: -1, Termination: sandbox.TerminationMemoryLimit}, ExitMemoryLimit, "memory limit (64 MB)"},
+		{"cpu limit", sandbox.Result{ExitCode: -1, Termination: sandbox.TerminationCPULimit}, ExitCPULimit, "CPU time limit (5s)"},
+		{"timeout", sandbox.Result{ExitCode: -1, Termination: sandbox.TerminationTimeout}, ExitTimeout, "timed out after 10s"},
+		{"signal", sandbox.Result{ExitCode: -1, Termination: sandbox.TerminationSignal, Signal: 15}, 143, "signal 15 (terminated)"},
+		{"setup failed", sandbox.Result{ExitCode: -1, Termination: sandbox.TerminationSetupFailed}, ExitSandboxSetup, "could not be set up"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := terminationExit(&tt.result, plan)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if !strings.Contains(message, tt.wantMessage) || (tt.wantMessage == "") != (message == "") {
+				t.Errorf("message = %q, want it to contain %q", message, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eddmann/buns/internal/proxy"
+	"github.com/eddmann/buns/internal/sandbox"
+)
+
+// Formats for --stats
+const (
+	StatsText = "text"
+	StatsJSON = "json"
+)
+
+// runStats is the --stats report of a run
+type runStats struct {
+	Sandbox          string       `json:"sandbox"`
+	ExitCode         int          `json:"exit_code"`
+	Termination      string       `json:"termination"` // Why the run ended, e.g. "normal" or "timeout"
+	WallMS           int64        `json:"wall_ms"`
+	UserCPUMS        int64        `json:"user_cpu_ms"`
+	SystemCPUMS      int64        `json:"system_cpu_ms"`
+	PeakRSSBytes     int64        `json:"peak_rss_bytes"`
+	ReadBytes        int64        `json:"read_bytes"`
+	WrittenBytes     int64        `json:"written_bytes"`
+	NetSentBytes     *int64       `json:"net_sent_bytes,omitempty"` // Only measured through the proxy
+	NetReceivedBytes *int64       `json:"net_received_bytes,omitempty"`
+	Limits           *statsLimits `json:"limits,omitempty"` // Only for sandboxed runs
+}
+
+// statsLimits are the limits a sandboxed run had, to compare usage against
+type statsLimits struct {
+	MemoryMB    int `json:"memory_mb"`
+	TimeoutSecs int `json:"timeout_secs"`
+	CPUSecs     int `json:"cpu_secs"`
+}
+
+func newRunStats(sandboxName string, exitCode int, result *sandbox.Result, network bool, limits *statsLimits) runStats {
+	usage := result.Usage
+	stats := runStats{
+		Sandbox:      sandboxName,
+		ExitCode:     exitCode,
+		Termination:  result.Termination.String(),
+		WallMS:       usage.WallTime.Milliseconds(),
+		UserCPUMS:    usage.UserCPU.Milliseconds(),
+		SystemCPUMS:  usage.SystemCPU.Milliseconds(),
+		PeakRSSBytes: usage.PeakRSS,
+		ReadBytes:    usage.ReadBytes,
+		WrittenBytes: usage.WrittenBytes,
+		Limits:       limits,
+	}
+	if network {
+		stats.NetSentBytes = &usage.NetSentBytes
+		stats.NetReceivedBytes = &usage.NetReceivedBytes
+	}
+	return stats
+}
+
+// write prints the report in format (StatsText or StatsJSON)
+func (s runStats) write(w io.Writer, format string) error {
+	if format == StatsJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+	_, err := io.WriteString(w, s.text())
+	return err
+}
+
+func (s runStats) text() string {
+	var timeout, cpuLimit, memoryLimit string
+	if s.Limits != nil {
+		timeout = limitNote(s.Limits.TimeoutSecs, "%ds")
+		cpuLimit = limitNote(s.Limits.CPUSecs, "%ds")
+		memoryLimit = limitNote(s.Limits.MemoryMB, "%d MB")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[buns] Run stats (%s):\n", s.Sandbox)
+	fmt.Fprintf(&b, "  wall time    %s%s\n", formatMillis(s.WallMS), timeout)
+	fmt.Fprintf(&b, "  cpu time     %s user, %s system%s\n", formatMillis(s.UserCPUMS), formatMillis(s.SystemCPUMS), cpuLimit)
+	fmt.Fprintf(&b, "  peak memory  %s%s\n", formatBytes(s.PeakRSSBytes), memoryLimit)
+	fmt.Fprintf(&b, "  disk         %s read, %s written\n", formatBytes(s.ReadBytes), formatBytes(s.WrittenBytes))
+	if s.NetSentBytes != nil {
+		fmt.Fprintf(&b, "  network      %s sent, %s received\n", formatBytes(*s.NetSentBytes), formatBytes(*s.NetReceivedBytes))
+	}
+	fmt.Fprintf(&b, "  exit code    %d (%s)\n", s.ExitCode, s.Termination)
+	return b.String()
+}
+
+// limitNote describes a limit after a figure, or nothing when it is unlimited
+func limitNote(value int, format string) string {
+	if value <= 0 {
+		return ""
+	}
+	return " (limit " + fmt.Sprintf(format, value) + ")"
+}
+
+// reportStats prints the --stats report to stderr
+func (r *Runner) reportStats(format string, stats runStats) {
+	if err := stats.write(os.Stderr, format); err != nil {
+		fmt.Fprintf(os.Stderr, "[buns] Warning: could not write stats: %v\n", err)
+	}
+}
+
+// netCounter totals the bytes of connections through the proxy
+type netCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (c *netCounter) observe(rec proxy.AuditRecord) {
+	c.sent.Add(rec.BytesSent)
+	c.received.Add(rec.BytesReceived)
+}
+
+func formatMillis(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
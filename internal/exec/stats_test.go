@@ -0,0 +1,96 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eddmann/buns/internal/proxy"
+	"github.com/eddmann/buns/internal/sandbox"
+)
+
+func TestRunStats_text(t *testing.T) {
+	usage := sandbox.Usage{
+		WallTime:         1500 * time.Millisecond,
+		UserCPU:          800 * time.Millisecond,
+		SystemCPU:        120 * time.Millisecond,
+		PeakRSS:          48 * 1024 * 1024,
+		ReadBytes:        2048,
+		WrittenBytes:     100,
+		NetSentBytes:     512,
+		NetReceivedBytes: 3 * 1024 * 1024,
+	}
+	stats := newRunStats("bubblewrap", 0, &sandbox.Result{Usage: usage}, true, &statsLimits{MemoryMB: 128, TimeoutSecs: 30})
+
+	var buf bytes.Buffer
+	if err := stats.write(&buf, StatsText); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	want := `[buns] Run stats (bubblewrap):
+  wall time    1.5s (limit 30s)
+  cpu time     800ms user, 120ms system
+  peak memory  48.0 MB (limit 128 MB)
+  disk         2.0 KB read, 100 B written
+  network      512 B sent, 3.0 MB received
+  exit code    0 (normal)
+`
+	if buf.String() != want {
+		t.Errorf("text =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// Without the proxy there's no network line, and unsandboxed runs have no limits
+	stats = newRunStats("none", 1, &sandbox.Result{ExitCode: 1, Usage: usage}, false, nil)
+	text := stats.text()
+	if strings.Contains(text, "network") || strings.Contains(text, "limit") {
+		t.Errorf("unexpected network or limit in:\n%s", text)
+	}
+}
+
+func TestRunStats_json(t *testing.T) {
+	usage := sandbox.Usage{WallTime: 2 * time.Second, PeakRSS: 1024, NetSentBytes: 10}
+	var buf bytes.Buffer
+	result := &sandbox.Result{Termination: sandbox.TerminationTimeout, Usage: usage}
+	if err := newRunStats("nsjail", ExitTimeout, result, true, &statsLimits{MemoryMB: 64}).write(&buf, StatsJSON); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	checks := map[string]any{
+		"sandbox":            "nsjail",
+		"exit_code":          float64(ExitTimeout),
+		"termination":        "timeout",
+		"wall_ms":            float64(2000),
+		"peak_rss_bytes":     float64(1024),
+		"net_sent_bytes":     float64(10),
+		"net_received_bytes": float64(0),
+	}
+	for key, want := range checks {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if limits, _ := got["limits"].(map[string]any); limits["memory_mb"] != float64(64) {
+		t.Errorf("limits = %v", got["limits"])
+	}
+
+	// Network figures are left out rather than reported as zero when not measured
+	buf.Reset()
+	_ = newRunStats("none", 0, &sandbox.Result{Usage: usage}, false, nil).write(&buf, StatsJSON)
+	if strings.Contains(buf.String(), "net_") || strings.Contains(buf.String(), "limits") {
+		t.Errorf("unexpected fields in %s", buf.String())
+	}
+}
+
+func TestNetCounter(t *testing.T) {
+	var c netCounter
+	c.observe(proxy.AuditRecord{BytesSent: 100, BytesReceived: 2000})
+	c.observe(proxy.AuditRecord{BytesSent: 5})
+	if c.sent.Load() != 105 || c.received.Load() != 2000 {
+		t.Errorf("sent = %d, received = %d", c.sent.Load(), c.received.Load())
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -17,16 +20,25 @@ import (
 const (
 	GitHubReleasesURL = "https://api.github.com/repos/oven-sh/bun/releases"
 	CacheTTL          = 24 * time.Hour
+
+	githubAPIHost = "api.github.com"
+	perPage       = 100
+	maxPages      = 50
 )
 
 var (
 	ErrNoCache   = errors.New("no cached index available")
-	versionRegex = regexp.MustCompile(`^bun-v(\d+\.\d+\.\d+)$`)
+	versionRegex = regexp.MustCompile(`^bun-v(\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)$`)
+
+	// Channels are the rolling release tags tracked alongside versioned releases
+	Channels = []string{"canary"}
 )
 
 // Index manages the cached Bun version index
 type Index struct {
-	cacheDir string
+	cacheDir    string
+	releasesURL string
+	listing     bool // releasesURL serves a plain version listing rather than the GitHub API
 }
 
 // GitHubRelease represents a release from GitHub API
@@ -38,63 +50,183 @@ type GitHubRelease struct {
 
 // New creates a new Index with the given cache directory
 func New(cacheDir string) *Index {
-	return &Index{cacheDir: cacheDir}
+	return NewWithURL(cacheDir, GitHubReleasesURL)
 }
 
-// GetVersions returns available Bun versions, fetching from GitHub if cache is stale
+// NewWithURL creates an Index that lists releases from a custom URL serving
+// the GitHub releases API format (e.g. a mirror or fixture server)
+func NewWithURL(cacheDir, releasesURL string) *Index {
+	return &Index{cacheDir: cacheDir, releasesURL: releasesURL}
+}
+
+// NewWithListing creates an Index that reads versions from a mirror-hosted
+// listing: a JSON array of version strings and channel names, e.g.
+// ["1.1.34", "1.1.33", "canary"]. The index's own bun-versions.json cache
+// file has this format, so it can be published as is.
+func NewWithListing(cacheDir, listingURL string) *Index {
+	return &Index{cacheDir: cacheDir, releasesURL: listingURL, listing: true}
+}
+
+// GetVersions returns available Bun versions, including prereleases, sorted
+// descending. Fetches from GitHub if the cache is stale.
 func (idx *Index) GetVersions() ([]*semver.Version, error) {
-	versions, err := idx.loadCachedVersions()
-	if err == nil && !idx.isCacheStale() {
-		return versions, nil
+	entries, err := idx.getEntries()
+	if err != nil {
+		return nil, err
 	}
+	return parseVersions(entries), nil
+}
 
-	// Fetch from GitHub
-	versions, err = idx.fetchVersions()
+// GetChannels returns the release channels (e.g. "canary") currently published
+func (idx *Index) GetChannels() ([]string, error) {
+	entries, err := idx.getEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []string
+	for _, entry := range entries {
+		if isChannel(entry) {
+			channels = append(channels, entry)
+		}
+	}
+	return channels, nil
+}
+
+// getEntries returns the cached index entries (versions and channel names),
+// refreshing them from GitHub if the cache is stale
+func (idx *Index) getEntries() ([]string, error) {
+	entries, err := idx.loadCachedEntries()
+	source := idx.loadSource()
+	fromSameURL := err == nil && source.URL == idx.releasesURL
+	if fromSameURL && !idx.isCacheStale() {
+		return entries, nil
+	}
+
+	// Revalidate the cached copy when we have one, then fetch from GitHub
+	if fromSameURL && idx.unchanged(source.Pages) {
+		_ = idx.cacheEntries(entries, source.Pages)
+		return entries, nil
+	}
+	fetched, pages, err := idx.fetchEntries()
 	if err != nil {
 		// If fetch fails but we have cached versions, use them
-		if cached, cacheErr := idx.loadCachedVersions(); cacheErr == nil {
+		if cached, cacheErr := idx.loadCachedEntries(); cacheErr == nil {
 			return cached, nil
 		}
-		return nil, fmt.Errorf("failed to fetch Bun index from GitHub: %w\nRun with network access to initialize the index cache", err)
+		return nil, fmt.Errorf("failed to fetch Bun index from %s: %w\nRun with network access to initialize the index cache", idx.releasesURL, err)
 	}
 
-	// Cache the versions (non-fatal if it fails)
-	_ = idx.cacheVersions(versions)
+	// Cache the entries (non-fatal if it fails)
+	_ = idx.cacheEntries(fetched, pages)
+
+	return fetched, nil
+}
 
-	return versions, nil
+// unchanged reports whether every page the cached index was fetched from is
+// unchanged, by revalidating each with its ETag. A release added or removed
+// anywhere in the listing changes at least one page.
+func (idx *Index) unchanged(pages []sourcePage) bool {
+	if len(pages) == 0 {
+		return false
+	}
+	for _, page := range pages {
+		if page.ETag == "" {
+			return false
+		}
+		resp, err := idx.get(page.URL, page.ETag)
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			return false
+		}
+	}
+	return true
 }
 
-// fetchVersions fetches available versions from GitHub releases
-func (idx *Index) fetchVersions() ([]*semver.Version, error) {
-	req, err := http.NewRequest("GET", GitHubReleasesURL, nil)
+// fetchEntries fetches release versions and channel tags from every page of
+// GitHub releases, along with the URL and ETag of each page. Versions come
+// first, sorted descending, followed by channel names.
+func (idx *Index) fetchEntries() ([]string, []sourcePage, error) {
+	if idx.listing {
+		return idx.fetchListing()
+	}
+
+	next, err := withPerPage(idx.releasesURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "buns-cli")
 
-	resp, err := http.DefaultClient.Do(req)
+	var releases []GitHubRelease
+	var pages []sourcePage
+	for page := 0; next != ""; page++ {
+		if page == maxPages {
+			return nil, nil, fmt.Errorf("GitHub releases exceed %d pages", maxPages)
+		}
+
+		result, err := idx.fetchPage(next)
+		if err != nil {
+			return nil, nil, err
+		}
+		pages = append(pages, sourcePage{URL: next, ETag: result.etag})
+		releases = append(releases, result.releases...)
+		next = result.next
+	}
+
+	var tags []string
+	for _, release := range releases {
+		if !release.Draft {
+			tags = append(tags, release.TagName)
+		}
+	}
+
+	return sortEntries(tags), pages, nil
+}
+
+// fetchListing fetches a mirror-hosted JSON version listing
+func (idx *Index) fetchListing() ([]string, []sourcePage, error) {
+	resp, err := idx.get(idx.releasesURL, "")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("version listing returned %d", resp.StatusCode)
 	}
 
-	var releases []GitHubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
-		return nil, err
+	var listed []string
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		return nil, nil, fmt.Errorf("invalid version listing: %w", err)
 	}
 
+	// Accept release tags as well as bare versions
+	tags := make([]string, 0, len(listed))
+	for _, entry := range listed {
+		if !isChannel(entry) && !strings.HasPrefix(entry, "bun-v") {
+			entry = "bun-v" + entry
+		}
+		tags = append(tags, entry)
+	}
+
+	pages := []sourcePage{{URL: idx.releasesURL, ETag: resp.Header.Get("ETag")}}
+	return sortEntries(tags), pages, nil
+}
+
+// sortEntries turns release tags into index entries: versions sorted
+// descending, followed by channel names. Unrecognised tags are dropped.
+func sortEntries(tags []string) []string {
 	var versions []*semver.Version
-	for _, release := range releases {
-		if release.Draft || release.Prerelease {
+	var channels []string
+	for _, tag := range tags {
+		if isChannel(tag) {
+			channels = append(channels, tag)
 			continue
 		}
 
-		matches := versionRegex.FindStringSubmatch(release.TagName)
+		matches := versionRegex.FindStringSubmatch(tag)
 		if len(matches) != 2 {
 			continue
 		}
@@ -111,45 +243,124 @@ func (idx *Index) fetchVersions() ([]*semver.Version, error) {
 		return versions[i].GreaterThan(versions[j])
 	})
 
-	return versions, nil
+	entries := make([]string, 0, len(versions)+len(channels))
+	for _, v := range versions {
+		entries = append(entries, v.Original())
+	}
+	return append(entries, channels...)
+}
+
+// releasePage is one page of the GitHub releases listing
+type releasePage struct {
+	releases []GitHubRelease
+	etag     string
+	next     string // URL of the next page, empty on the last page
 }
 
-// loadCachedVersions loads versions from the cache file
-func (idx *Index) loadCachedVersions() ([]*semver.Version, error) {
-	data, err := os.ReadFile(idx.versionsFile())
+// get requests a page of the index, conditionally if ifNoneMatch is set
+func (idx *Index) get(pageURL, ifNoneMatch string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
 	if err != nil {
-		return nil, ErrNoCache
+		return nil, err
+	}
+	if !idx.listing {
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+	}
+	req.Header.Set("User-Agent", "buns-cli")
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
 	}
 
-	var versionStrings []string
-	if err := json.Unmarshal(data, &versionStrings); err != nil {
+	// Only send the token to GitHub itself, never to a mirror
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && req.URL.Host == githubAPIHost {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// fetchPage fetches a single page of releases
+func (idx *Index) fetchPage(pageURL string) (*releasePage, error) {
+	resp, err := idx.get(pageURL, "")
+	if err != nil {
 		return nil, err
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	var versions []*semver.Version
-	for _, vs := range versionStrings {
-		v, err := semver.NewVersion(vs)
-		if err != nil {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.Header.Get("X-RateLimit-Remaining") == "0":
+		return nil, fmt.Errorf("GitHub API rate limit exceeded; set GITHUB_TOKEN to raise it")
+	default:
+		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+	}
+
+	var releases []GitHubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+
+	return &releasePage{
+		releases: releases,
+		etag:     resp.Header.Get("ETag"),
+		next:     nextPageURL(resp.Header.Get("Link")),
+	}, nil
+}
+
+// withPerPage requests the largest page size unless the URL already sets one
+func withPerPage(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid releases URL '%s': %w", rawURL, err)
+	}
+
+	q := u.Query()
+	if q.Get("per_page") == "" {
+		q.Set("per_page", strconv.Itoa(perPage))
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// nextPageURL extracts the rel="next" URL from a Link header
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(part, ";")
+		if !ok {
 			continue
 		}
-		versions = append(versions, v)
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// loadCachedEntries loads versions and channel names from the cache file
+func (idx *Index) loadCachedEntries() ([]string, error) {
+	data, err := os.ReadFile(idx.versionsFile())
+	if err != nil {
+		return nil, ErrNoCache
 	}
 
-	return versions, nil
+	var entries []string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
 }
 
-// cacheVersions saves versions to the cache file
-func (idx *Index) cacheVersions(versions []*semver.Version) error {
+// cacheEntries saves versions and channel names to the cache file, along with
+// the URL they came from and the pages it served them on
+func (idx *Index) cacheEntries(entries []string, pages []sourcePage) error {
 	if err := os.MkdirAll(idx.cacheDir, 0755); err != nil {
 		return err
 	}
 
-	var versionStrings []string
-	for _, v := range versions {
-		versionStrings = append(versionStrings, v.Original())
-	}
-
-	data, err := json.Marshal(versionStrings)
+	data, err := json.Marshal(entries)
 	if err != nil {
 		return err
 	}
@@ -158,10 +369,41 @@ func (idx *Index) cacheVersions(versions []*semver.Version) error {
 		return err
 	}
 
+	source, err := json.Marshal(indexSource{URL: idx.releasesURL, Pages: pages})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(idx.sourceFile(), source, 0644); err != nil {
+		return err
+	}
+
 	// Update timestamp
 	return os.WriteFile(idx.timestampFile(), []byte(time.Now().Format(time.RFC3339)), 0644)
 }
 
+// indexSource records where the cached index was fetched from
+type indexSource struct {
+	URL   string       `json:"url"`
+	Pages []sourcePage `json:"pages,omitempty"`
+}
+
+// sourcePage is one page of the releases listing the cached index was
+// fetched from, with its ETag for revalidation
+type sourcePage struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+}
+
+// loadSource reads the cached index's source. Caches written before sources
+// were recorded are assumed to come from GitHub.
+func (idx *Index) loadSource() indexSource {
+	source := indexSource{URL: GitHubReleasesURL}
+	if data, err := os.ReadFile(idx.sourceFile()); err == nil {
+		_ = json.Unmarshal(data, &source)
+	}
+	return source
+}
+
 // isCacheStale checks if the cache is older than CacheTTL
 func (idx *Index) isCacheStale() bool {
 	data, err := os.ReadFile(idx.timestampFile())
@@ -177,10 +419,37 @@ func (idx *Index) isCacheStale() bool {
 	return time.Since(t) > CacheTTL
 }
 
+// parseVersions returns the entries that are semver versions, skipping channel names
+func parseVersions(entries []string) []*semver.Version {
+	var versions []*semver.Version
+	for _, entry := range entries {
+		v, err := semver.NewVersion(entry)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// isChannel reports whether a release tag is a tracked rolling channel
+func isChannel(tag string) bool {
+	for _, c := range Channels {
+		if tag == c {
+			return true
+		}
+	}
+	return false
+}
+
 func (idx *Index) versionsFile() string {
 	return filepath.Join(idx.cacheDir, "bun-versions.json")
 }
 
+func (idx *Index) sourceFile() string {
+	return filepath.Join(idx.cacheDir, "source.json")
+}
+
 func (idx *Index) timestampFile() string {
 	return filepath.Join(idx.cacheDir, "fetched_at")
 }
@@ -2,10 +2,12 @@ package index
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,6 +20,8 @@ func TestIndex_GetVersions(t *testing.T) {
 		{TagName: "bun-v1.2.0-canary.1", Prerelease: true, Draft: false},
 		{TagName: "bun-v1.1.32", Prerelease: false, Draft: false},
 		{TagName: "bun-v1.0.0", Prerelease: false, Draft: false},
+		{TagName: "canary", Prerelease: true, Draft: false},
+		{TagName: "bun-v1.3.0", Prerelease: false, Draft: true},
 	}
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -28,53 +32,35 @@ func TestIndex_GetVersions(t *testing.T) {
 	// Create temp cache dir
 	tmpDir := t.TempDir()
 
-	// Create index with mock server (we'll need to patch the URL for real testing)
-	idx := New(tmpDir)
-
-	// Test fetchVersions directly with the mock server
-	t.Run("fetchVersions excludes prereleases", func(t *testing.T) {
-		// For this test, we'll manually test the filtering logic
-		// since we can't easily override the URL
-
-		// The regex should match stable versions
-		matches := versionRegex.FindStringSubmatch("bun-v1.1.34")
-		if len(matches) != 2 || matches[1] != "1.1.34" {
-			t.Errorf("version regex failed: %v", matches)
+	t.Run("includes prereleases", func(t *testing.T) {
+		versions, err := NewWithURL(tmpDir, server.URL).GetVersions()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
 
-		// Canary versions should not match the clean format
-		// (they have extra stuff after the version)
-		matches = versionRegex.FindStringSubmatch("bun-v1.2.0-canary.1")
-		if len(matches) == 2 {
-			t.Errorf("version regex should not match canary versions")
+		var got []string
+		for _, v := range versions {
+			got = append(got, v.Original())
 		}
-	})
-
-	t.Run("cacheVersions and loadCachedVersions", func(t *testing.T) {
-		versions, err := idx.fetchVersions()
-		if err != nil {
-			// Skip if no network
-			t.Skip("Network unavailable")
+		want := []string{"1.2.0-canary.1", "1.1.34", "1.1.33", "1.1.32", "1.0.0"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("versions = %v, want %v", got, want)
 		}
+	})
 
-		if len(versions) == 0 {
-			t.Error("expected some versions")
-		}
+	t.Run("cached versions outlive the server", func(t *testing.T) {
+		server.Close()
 
-		// Cache should work
-		err = idx.cacheVersions(versions)
-		if err != nil {
-			t.Errorf("cacheVersions failed: %v", err)
-		}
+		// Stale and unreachable: the cached copy is used
+		oldTime := time.Now().Add(-25 * time.Hour)
+		os.WriteFile(filepath.Join(tmpDir, "fetched_at"), []byte(oldTime.Format(time.RFC3339)), 0644)
 
-		// Load from cache
-		cached, err := idx.loadCachedVersions()
+		versions, err := NewWithURL(tmpDir, server.URL).GetVersions()
 		if err != nil {
-			t.Errorf("loadCachedVersions failed: %v", err)
+			t.Fatalf("unexpected error: %v", err)
 		}
-
-		if len(cached) != len(versions) {
-			t.Errorf("cached count mismatch: got %d, want %d", len(cached), len(versions))
+		if len(versions) != 5 {
+			t.Errorf("versions = %v, want the 5 cached", versions)
 		}
 	})
 }
@@ -117,7 +103,9 @@ func TestVersionRegex(t *testing.T) {
 		{"bun-v1.1.34", "1.1.34", true},
 		{"bun-v1.0.0", "1.0.0", true},
 		{"bun-v2.0.0", "2.0.0", true},
-		{"bun-v1.2.0-canary.1", "", false},
+		{"bun-v1.2.0-canary.1", "1.2.0-canary.1", true},
+		{"bun-v1.2.0-rc.2", "1.2.0-rc.2", true},
+		{"canary", "", false},
 		{"v1.1.34", "", false},
 		{"bun-1.1.34", "", false},
 		{"bun-v1.1", "", false},
@@ -142,3 +130,234 @@ func TestVersionRegex(t *testing.T) {
 		})
 	}
 }
+
+func TestIndex_GetChannels(t *testing.T) {
+	releases := []GitHubRelease{
+		{TagName: "canary", Prerelease: true},
+		{TagName: "bun-v1.1.34"},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(releases)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	idx := NewWithURL(tmpDir, server.URL)
+
+	channels, err := idx.GetChannels()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(channels) != 1 || channels[0] != "canary" {
+		t.Errorf("channels = %v, want [canary]", channels)
+	}
+
+	// Versions are read back from the same cache entry
+	server.Close()
+	versions, err := NewWithURL(tmpDir, server.URL).GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Original() != "1.1.34" {
+		t.Errorf("versions = %v, want [1.1.34]", versions)
+	}
+}
+
+func TestIndex_GetVersions_follows_pagination(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("per_page") != "100" {
+			t.Errorf("per_page = %q, want 100", r.URL.Query().Get("per_page"))
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s?per_page=100&page=2>; rel="next", <%s?per_page=100&page=2>; rel="last"`, server.URL, server.URL))
+			json.NewEncoder(w).Encode([]GitHubRelease{{TagName: "bun-v1.1.34"}})
+		case "2":
+			json.NewEncoder(w).Encode([]GitHubRelease{{TagName: "bun-v1.0.0"}})
+		default:
+			t.Errorf("unexpected page %q", r.URL.Query().Get("page"))
+		}
+	}))
+	defer server.Close()
+
+	versions, err := NewWithURL(t.TempDir(), server.URL).GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 2 || versions[1].Original() != "1.0.0" {
+		t.Errorf("versions = %v, want [1.1.34 1.0.0]", versions)
+	}
+}
+
+func TestIndex_GetVersions_revalidates_with_etag(t *testing.T) {
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode([]GitHubRelease{{TagName: "bun-v1.1.34"}})
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	idx := NewWithURL(tmpDir, server.URL)
+
+	if _, err := idx.GetVersions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fresh cache: no request
+	if _, err := idx.GetVersions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("requests = %d, want 1", requests)
+	}
+
+	// Stale cache: conditional request, cached versions kept
+	oldTime := time.Now().Add(-25 * time.Hour)
+	os.WriteFile(filepath.Join(tmpDir, "fetched_at"), []byte(oldTime.Format(time.RFC3339)), 0644)
+
+	versions, err := idx.GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notModified != 1 {
+		t.Errorf("conditional requests = %d, want 1", notModified)
+	}
+	if len(versions) != 1 || versions[0].Original() != "1.1.34" {
+		t.Errorf("versions = %v, want [1.1.34]", versions)
+	}
+	if idx.isCacheStale() {
+		t.Error("expected a 304 to refresh the cache timestamp")
+	}
+}
+
+func TestIndex_GetVersions_revalidates_every_page(t *testing.T) {
+	// Two pages, each with its own ETag. The second page's release is
+	// deleted, leaving the first page unchanged.
+	lastPage := "bun-v1.0.0"
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag, etag := "bun-v1.1.34", `"page1"`
+		if r.URL.Query().Get("page") == "2" {
+			tag, etag = lastPage, `"page2-`+lastPage+`"`
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?per_page=100&page=2>; rel="next"`, server.URL))
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode([]GitHubRelease{{TagName: tag}})
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	idx := NewWithURL(tmpDir, server.URL)
+	if _, err := idx.GetVersions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lastPage = "bun-v0.9.0"
+	oldTime := time.Now().Add(-25 * time.Hour)
+	os.WriteFile(filepath.Join(tmpDir, "fetched_at"), []byte(oldTime.Format(time.RFC3339)), 0644)
+
+	versions, err := idx.GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 2 || versions[1].Original() != "0.9.0" {
+		t.Errorf("versions = %v, want [1.1.34 0.9.0]", versions)
+	}
+}
+
+func TestIndex_GetVersions_refetches_when_url_changes(t *testing.T) {
+	serve := func(tag string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]GitHubRelease{{TagName: tag}})
+		}))
+	}
+	first := serve("bun-v1.1.34")
+	defer first.Close()
+	second := serve("bun-v1.0.0")
+	defer second.Close()
+
+	tmpDir := t.TempDir()
+	if _, err := NewWithURL(tmpDir, first.URL).GetVersions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	versions, err := NewWithURL(tmpDir, second.URL).GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Original() != "1.0.0" {
+		t.Errorf("versions = %v, want [1.0.0]", versions)
+	}
+}
+
+func TestIndex_does_not_send_github_token_to_other_hosts(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("Authorization header sent to mirror: %q", auth)
+		}
+		json.NewEncoder(w).Encode([]GitHubRelease{})
+	}))
+	defer server.Close()
+
+	if _, err := NewWithURL(t.TempDir(), server.URL).GetVersions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"", ""},
+		{`<https://api.github.com/x?page=2>; rel="next", <https://api.github.com/x?page=5>; rel="last"`, "https://api.github.com/x?page=2"},
+		{`<https://api.github.com/x?page=1>; rel="prev", <https://api.github.com/x?page=1>; rel="first"`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := nextPageURL(tt.link); got != tt.want {
+			t.Errorf("nextPageURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestIndex_GetVersions_from_mirror_listing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"1.0.0", "bun-v1.1.34", "canary", "not-a-version"})
+	}))
+	defer server.Close()
+
+	idx := NewWithListing(t.TempDir(), server.URL)
+
+	versions, err := idx.GetVersions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Original() != "1.1.34" || versions[1].Original() != "1.0.0" {
+		t.Errorf("versions = %v, want [1.1.34 1.0.0]", versions)
+	}
+
+	channels, err := idx.GetChannels()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(channels) != 1 || channels[0] != "canary" {
+		t.Errorf("channels = %v, want [canary]", channels)
+	}
+}
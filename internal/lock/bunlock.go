@@ -0,0 +1,99 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// textLockfile is the part of Bun's text lockfile (bun.lock) buns reads
+type textLockfile struct {
+	// Installed packages keyed by their path in node_modules ("zod",
+	// "chalk/ansi-styles"). Registry packages are recorded as
+	// ["name@version", registry, {metadata}, integrity].
+	Packages map[string][]json.RawMessage `json:"packages"`
+}
+
+// lockedPackage is a package as Bun's text lockfile records it
+type lockedPackage struct {
+	ID        string // name@version
+	Integrity string
+}
+
+// parseTextLockfile reads the top-level packages of a bun.lock, which are
+// the ones the script's package specs resolve to
+func parseTextLockfile(data []byte) (map[string]lockedPackage, error) {
+	var lockfile textLockfile
+	if err := json.Unmarshal(stripJSONC(data), &lockfile); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", TextLockfile, err)
+	}
+
+	packages := make(map[string]lockedPackage, len(lockfile.Packages))
+	for key, entry := range lockfile.Packages {
+		var p lockedPackage
+		if len(entry) > 0 {
+			_ = json.Unmarshal(entry[0], &p.ID)
+		}
+		if len(entry) > 3 {
+			_ = json.Unmarshal(entry[3], &p.Integrity)
+		}
+		packages[key] = p
+	}
+	return packages, nil
+}
+
+// stripJSONC turns JSONC, as Bun writes its text lockfile, into JSON by
+// dropping comments and trailing commas
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			switch c {
+			case '\\':
+				if i+1 < len(data) {
+					i++
+					out = append(out, data[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && (data[i] != '*' || data[i+1] != '/') {
+				i++
+			}
+			i++
+		case c == ']' || c == '}':
+			// Drop a comma before the closing bracket, skipping whitespace
+			j := len(out) - 1
+			for j >= 0 && isJSONSpace(out[j]) {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,197 @@
+package lock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FormatVersion is the current lock file format version
+const FormatVersion = 1
+
+// header is written at the top of every lock file
+const header = "# This file is generated by `buns lock`. Do not edit it by hand.\n\n"
+
+var (
+	ErrOutOfDate = errors.New("lock file is out of date")
+)
+
+// Lock pins the exact Bun version and packages a script was resolved to
+type Lock struct {
+	Version  int       `toml:"version"`
+	Bun      Bun       `toml:"bun"`
+	Packages []Package `toml:"package"`
+	Tree     *Tree     `toml:"tree,omitempty"` // Set when there are packages
+}
+
+// Bun records the resolved Bun version and the constraint it came from
+type Bun struct {
+	Constraint string `toml:"constraint"`
+	Version    string `toml:"version"`
+}
+
+// Package records a resolved npm package and the spec it came from
+type Package struct {
+	Spec      string `toml:"spec"`
+	Name      string `toml:"name"`
+	Version   string `toml:"version"`
+	Integrity string `toml:"integrity"`
+}
+
+// Tree is Bun's own lockfile from installing the packages, pinning every
+// transitive dependency and the integrity of its tarball. Installs replay it,
+// and Bun checks each tarball it downloads against it.
+type Tree struct {
+	File    string `toml:"file"`    // TextLockfile, or BinaryLockfile from Bun before 1.2
+	Content string `toml:"content"` // Base64 for BinaryLockfile
+}
+
+// Bun's lockfile names
+const (
+	TextLockfile   = "bun.lock"
+	BinaryLockfile = "bun.lockb"
+)
+
+// NewTree records the contents of the Bun lockfile named file
+func NewTree(file string, data []byte) *Tree {
+	if file == BinaryLockfile {
+		return &Tree{File: file, Content: base64.StdEncoding.EncodeToString(data)}
+	}
+	return &Tree{File: file, Content: string(data)}
+}
+
+// Data returns the contents of the Bun lockfile
+func (t *Tree) Data() ([]byte, error) {
+	if t.File == BinaryLockfile {
+		return base64.StdEncoding.DecodeString(t.Content)
+	}
+	return []byte(t.Content), nil
+}
+
+// Digest returns a hash of the tree, which tells installs of it apart
+func (t *Tree) Digest() string {
+	sum := sha256.Sum256([]byte(t.File + "\n" + t.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// PathFor returns the sidecar lock file path for a script
+func PathFor(scriptPath string) string {
+	return scriptPath + ".lock"
+}
+
+// Load reads a lock file. Returns nil without error if the file does not exist.
+func Load(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var l Lock
+	if _, err := toml.Decode(string(data), &l); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+
+	if l.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported lock file version %d in %s", l.Version, path)
+	}
+
+	return &l, nil
+}
+
+// Save writes the lock file to disk
+func (l *Lock) Save(path string) error {
+	l.Version = FormatVersion
+
+	sort.Slice(l.Packages, func(i, j int) bool {
+		return l.Packages[i].Name < l.Packages[j].Name
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	if err := toml.NewEncoder(&buf).Encode(l); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Check verifies the lock was generated from the given Bun constraint and
+// package specs, and that its tree holds the packages' integrity. Returns an
+// error wrapping ErrOutOfDate describing the drift.
+func (l *Lock) Check(bunConstraint string, packages []string) error {
+	var problems []string
+
+	if len(l.Packages) > 0 && l.Tree == nil {
+		problems = append(problems, "the dependency tree is not locked")
+	}
+	// The text lockfile installs each package at its name, recording its
+	// version and integrity as the registry gives them
+	if l.Tree != nil && l.Tree.File == TextLockfile {
+		problems = append(problems, l.checkTree()...)
+	}
+
+	if l.Bun.Constraint != bunConstraint {
+		problems = append(problems, fmt.Sprintf("bun constraint changed from %q to %q", l.Bun.Constraint, bunConstraint))
+	}
+
+	locked := make(map[string]bool, len(l.Packages))
+	for _, p := range l.Packages {
+		locked[p.Spec] = true
+	}
+	requested := make(map[string]bool, len(packages))
+	for _, spec := range packages {
+		requested[spec] = true
+		if !locked[spec] {
+			problems = append(problems, fmt.Sprintf("%s is not locked", spec))
+		}
+	}
+	for _, p := range l.Packages {
+		if !requested[p.Spec] {
+			problems = append(problems, fmt.Sprintf("%s is locked but no longer requested", p.Spec))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrOutOfDate, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// checkTree reports the packages the text lockfile tree doesn't install at
+// their locked version and integrity
+func (l *Lock) checkTree() []string {
+	tree, err := parseTextLockfile([]byte(l.Tree.Content))
+	if err != nil {
+		return []string{fmt.Sprintf("the dependency tree can't be read: %v", err)}
+	}
+
+	var problems []string
+	for _, p := range l.Packages {
+		installed, ok := tree[p.Name]
+		if !ok || installed.ID != p.Name+"@"+p.Version || p.Integrity != "" && installed.Integrity != p.Integrity {
+			problems = append(problems, fmt.Sprintf("%s@%s doesn't match the dependency tree", p.Name, p.Version))
+		}
+	}
+	return problems
+}
+
+// PinnedPackages returns the locked packages as exact name@version specs
+func (l *Lock) PinnedPackages() []string {
+	pinned := make([]string, 0, len(l.Packages))
+	for _, p := range l.Packages {
+		pinned = append(pinned, p.Name+"@"+p.Version)
+	}
+	return pinned
+}
@@ -0,0 +1,211 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLock_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.ts.lock")
+
+	l := &Lock{
+		Bun: Bun{Constraint: ">=1.1", Version: "1.1.34"},
+		Packages: []Package{
+			{Spec: "zod@^3.0", Name: "zod", Version: "3.24.1", Integrity: "sha512-zod"},
+			{Spec: "chalk@^5.0", Name: "chalk", Version: "5.3.0", Integrity: "sha512-chalk"},
+		},
+		Tree: NewTree(TextLockfile, []byte("{\n  \"lockfileVersion\": 1,\n}\n")),
+	}
+
+	if err := l.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read lock file: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# This file is generated by `buns lock`") {
+		t.Errorf("lock file missing header: %s", data)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loaded.Version != FormatVersion {
+		t.Errorf("version = %d, want %d", loaded.Version, FormatVersion)
+	}
+	if loaded.Bun != l.Bun {
+		t.Errorf("bun = %+v, want %+v", loaded.Bun, l.Bun)
+	}
+	// Packages are sorted by name on save
+	if loaded.Packages[0].Name != "chalk" || loaded.Packages[1].Name != "zod" {
+		t.Errorf("packages = %+v, want sorted by name", loaded.Packages)
+	}
+	if *loaded.Tree != *l.Tree {
+		t.Errorf("tree = %+v, want %+v", loaded.Tree, l.Tree)
+	}
+}
+
+func TestTree_Data(t *testing.T) {
+	for _, file := range []string{TextLockfile, BinaryLockfile} {
+		data := []byte("#!/usr/bin/env bun\nbun-lockfile-format-v0\n\x00\xff")
+		got, err := NewTree(file, data).Data()
+
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", file, err)
+		}
+		if string(got) != string(data) {
+			t.Errorf("%s: Data() = %q, want %q", file, got, data)
+		}
+	}
+}
+
+func TestLoad_returns_nil_when_missing(t *testing.T) {
+	l, err := Load(filepath.Join(t.TempDir(), "missing.lock"))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l != nil {
+		t.Errorf("got %+v, want nil", l)
+	}
+}
+
+func TestLoad_rejects_unknown_format_version(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.ts.lock")
+	if err := os.WriteFile(path, []byte("version = 99\n"), 0644); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+}
+
+func TestLock_Check(t *testing.T) {
+	l := &Lock{
+		Bun: Bun{Constraint: ">=1.1", Version: "1.1.34"},
+		Packages: []Package{
+			{Spec: "zod@^3.0", Name: "zod", Version: "3.24.1"},
+		},
+		Tree: NewTree(TextLockfile, []byte(`{"packages": {"zod": ["zod@3.24.1"]}}`)),
+	}
+
+	tests := []struct {
+		name       string
+		constraint string
+		packages   []string
+		wantErr    bool
+	}{
+		{"matches", ">=1.1", []string{"zod@^3.0"}, false},
+		{"bun constraint changed", ">=1.2", []string{"zod@^3.0"}, true},
+		{"package added", ">=1.1", []string{"zod@^3.0", "chalk@^5.0"}, true},
+		{"package removed", ">=1.1", nil, true},
+		{"package constraint changed", ">=1.1", []string{"zod@^3.1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := l.Check(tt.constraint, tt.packages)
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrOutOfDate) {
+					t.Errorf("got %v, want ErrOutOfDate", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLock_Check_tree(t *testing.T) {
+	tests := []struct {
+		name    string
+		tree    *Tree
+		wantErr bool
+	}{
+		{"holds the integrity", textTree(`"zod": ["zod@3.24.1", "", {}, "sha512-zod"],`), false},
+		{"missing", nil, true},
+		{"other integrity", textTree(`"zod": ["zod@3.24.1", "", {}, "sha512-other"],`), true},
+		{"other version", textTree(`"zod": ["zod@3.24.2", "", {}, "sha512-zod"],`), true},
+		{"integrity under another package", textTree(`"zod": ["zod@3.24.1", "", {}, "sha512-other"],
+    "other": ["other@1.0.0", "", {}, "sha512-zod"],`), true},
+		{"integrity nested under another package", textTree(`"other/zod": ["zod@3.24.1", "", {}, "sha512-zod"],`), true},
+		{"unreadable", NewTree(TextLockfile, []byte(`{"packages": `)), true},
+		{"binary", NewTree(BinaryLockfile, []byte{0, 1, 2}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lock{
+				Bun:      Bun{Constraint: ">=1.1", Version: "1.1.34"},
+				Packages: []Package{{Spec: "zod@^3.0", Name: "zod", Version: "3.24.1", Integrity: "sha512-zod"}},
+				Tree:     tt.tree,
+			}
+
+			err := l.Check(">=1.1", []string{"zod@^3.0"})
+
+			if tt.wantErr != errors.Is(err, ErrOutOfDate) {
+				t.Errorf("Check() = %v, want ErrOutOfDate: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// textTree returns a bun.lock tree with the given packages entries, written
+// as Bun writes it, with trailing commas
+func textTree(packages string) *Tree {
+	return NewTree(TextLockfile, []byte(`{
+  "lockfileVersion": 1,
+  // Comments are allowed too
+  "workspaces": {
+    "": {
+      "dependencies": {
+        "zod": "3.24.1",
+      },
+    },
+  },
+  "packages": {
+    `+packages+`
+  }
+}
+`))
+}
+
+func TestStripJSONC(t *testing.T) {
+	got := string(stripJSONC([]byte(`{"a": ["x, ]", "/* not a comment */",], /* gone */ "b": "\"//",
+// gone too
+}`)))
+	want := `{"a": ["x, ]", "/* not a comment */"],  "b": "\"//"
+
+}`
+	if got != want {
+		t.Errorf("stripJSONC() = %q, want %q", got, want)
+	}
+}
+
+func TestLock_PinnedPackages(t *testing.T) {
+	l := &Lock{
+		Packages: []Package{
+			{Spec: "zod@^3.0", Name: "zod", Version: "3.24.1"},
+			{Spec: "@types/node", Name: "@types/node", Version: "20.11.0"},
+		},
+	}
+
+	got := l.PinnedPackages()
+	want := []string{"zod@3.24.1", "@types/node@20.11.0"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PinnedPackages() = %v, want %v", got, want)
+	}
+}
@@ -12,6 +12,7 @@ import (
 type Metadata struct {
 	Bun      string   `toml:"bun"`
 	Packages []string `toml:"packages"`
+	Sandbox  Sandbox  `toml:"sandbox"`
 }
 
 // Parse extracts metadata from a script's // buns comment block
@@ -60,5 +61,9 @@ func Parse(content []byte) (*Metadata, error) {
 		return nil, err
 	}
 
+	if err := meta.Sandbox.validate(); err != nil {
+		return nil, err
+	}
+
 	return &meta, nil
 }
@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -136,3 +137,147 @@ code here
 		})
 	}
 }
+
+func TestParse_sandbox_table(t *testing.T) {
+	content := `#!/usr/bin/env buns
+// buns
+// packages = ["zod@^3.0"]
+//
+// [sandbox]
+// enabled = true
+// allow-host = ["api.github.com", "10.0.0.0/8:443"]
+// deny-host = ["169.254.0.0/16"]
+// allow-env = ["GITHUB_TOKEN"]
+// memory = 256
+
+console.log("hi");
+`
+
+	meta, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sb := meta.Sandbox
+	if sb.Enabled == nil || !*sb.Enabled {
+		t.Errorf("enabled = %v, want true", sb.Enabled)
+	}
+	if sb.Offline != nil {
+		t.Errorf("offline = %v, want unset", *sb.Offline)
+	}
+	if !reflect.DeepEqual(sb.AllowHost, []string{"api.github.com", "10.0.0.0/8:443"}) {
+		t.Errorf("allow-host = %v", sb.AllowHost)
+	}
+	if !reflect.DeepEqual(sb.DenyHost, []string{"169.254.0.0/16"}) {
+		t.Errorf("deny-host = %v", sb.DenyHost)
+	}
+	if !reflect.DeepEqual(sb.AllowEnv, []string{"GITHUB_TOKEN"}) {
+		t.Errorf("allow-env = %v", sb.AllowEnv)
+	}
+	if sb.Memory == nil || *sb.Memory != 256 {
+		t.Errorf("memory = %v, want 256", sb.Memory)
+	}
+	if sb.Timeout != nil || sb.CPU != nil {
+		t.Error("timeout and cpu should be unset")
+	}
+}
+
+func TestParse_rejects_negative_sandbox_limits(t *testing.T) {
+	content := `// buns
+// [sandbox]
+// timeout = -1
+`
+	if _, err := Parse([]byte(content)); err == nil {
+		t.Error("expected error for negative timeout")
+	}
+}
+
+func TestSandbox_Merge(t *testing.T) {
+	yes, no := true, false
+	memory, cliMemory := 256, 64
+
+	script := Sandbox{
+		Enabled:   &yes,
+		Offline:   &yes,
+		AllowHost: []string{"api.github.com"},
+		DenyHost:  []string{"10.0.0.0/8"},
+		Memory:    &memory,
+	}
+	flags := Sandbox{
+		Offline:   &no,
+		AllowHost: []string{"registry.npmjs.org", "api.github.com"},
+		Memory:    &cliMemory,
+	}
+
+	got := script.Merge(flags)
+
+	if got.Enabled == nil || !*got.Enabled {
+		t.Error("enabled should come from the script when no flag is given")
+	}
+	if got.Offline == nil || *got.Offline {
+		t.Error("offline flag should override the script")
+	}
+	if *got.Memory != 64 {
+		t.Errorf("memory = %d, want 64", *got.Memory)
+	}
+	if !reflect.DeepEqual(got.AllowHost, []string{"registry.npmjs.org", "api.github.com"}) {
+		t.Errorf("allow-host = %v", got.AllowHost)
+	}
+	if !reflect.DeepEqual(got.DenyHost, []string{"10.0.0.0/8"}) {
+		t.Errorf("deny-host = %v", got.DenyHost)
+	}
+}
+
+func TestSandbox_Merge_script_cannot_widen_flags(t *testing.T) {
+	script := Sandbox{
+		AllowHost:  []string{"*"},
+		DenyHost:   []string{"admin.example.com"},
+		AllowRead:  []string{"/"},
+		AllowWrite: []string{"/home"},
+		AllowEnv:   []string{"AWS_SECRET_ACCESS_KEY"},
+	}
+	flags := Sandbox{
+		AllowHost:  []string{"api.example.com"},
+		DenyHost:   []string{"api.example.com:80"},
+		AllowRead:  []string{"/data"},
+		AllowWrite: []string{"/tmp/out"},
+		AllowEnv:   []string{"HOME"},
+	}
+
+	got := script.Merge(flags)
+
+	if !reflect.DeepEqual(got.AllowHost, []string{"api.example.com"}) {
+		t.Errorf("allow-host = %v, want only the flag's", got.AllowHost)
+	}
+	if !reflect.DeepEqual(got.AllowRead, []string{"/data"}) || !reflect.DeepEqual(got.AllowWrite, []string{"/tmp/out"}) {
+		t.Errorf("allow-read = %v, allow-write = %v, want only the flags'", got.AllowRead, got.AllowWrite)
+	}
+	if !reflect.DeepEqual(got.AllowEnv, []string{"HOME"}) {
+		t.Errorf("allow-env = %v, want only the flag's", got.AllowEnv)
+	}
+	if !reflect.DeepEqual(got.DenyHost, []string{"admin.example.com", "api.example.com:80"}) {
+		t.Errorf("deny-host = %v, want both", got.DenyHost)
+	}
+
+	// Without allow flags, the script's lists apply
+	if got := script.Merge(Sandbox{}); !reflect.DeepEqual(got.AllowHost, []string{"*"}) {
+		t.Errorf("allow-host = %v, want the script's", got.AllowHost)
+	}
+}
+
+func TestParse_sandbox_cpus(t *testing.T) {
+	for _, value := range []string{"2", "0.5"} {
+		content := "// buns\n// [sandbox]\n// cpus = " + value + "\n"
+		meta, err := Parse([]byte(content))
+		if err != nil {
+			t.Fatalf("cpus = %s: %v", value, err)
+		}
+		if meta.Sandbox.CPUs == nil || strconv.FormatFloat(*meta.Sandbox.CPUs, 'f', -1, 64) != value {
+			t.Errorf("cpus = %v, want %s", meta.Sandbox.CPUs, value)
+		}
+	}
+
+	if _, err := Parse([]byte("// buns\n// [sandbox]\n// cpus = -1\n")); err == nil {
+		t.Error("expected error for negative cpus")
+	}
+}
@@ -0,0 +1,112 @@
+package metadata
+
+import "fmt"
+
+// Sandbox is the [sandbox] table of a // buns block: the isolation and
+// permissions a script needs. Nil fields are unset and fall back to the
+// command line, then the defaults.
+type Sandbox struct {
+	Enabled     *bool    `toml:"enabled"`      // Full filesystem + process isolation
+	Offline     *bool    `toml:"offline"`      // Block all network access
+	AllowHost   []string `toml:"allow-host"`   // Hosts reachable through the proxy
+	DenyHost    []string `toml:"deny-host"`    // Hosts blocked even when allowed
+	AllowRead   []string `toml:"allow-read"`   // Additional readable paths
+	AllowWrite  []string `toml:"allow-write"`  // Additional writable paths
+	AllowEnv    []string `toml:"allow-env"`    // Host environment variables to pass through
+	Memory      *int     `toml:"memory"`       // Memory limit in MB
+	Timeout     *int     `toml:"timeout"`      // Execution timeout in seconds
+	GracePeriod *int     `toml:"grace-period"` // Seconds between SIGTERM and SIGKILL on timeout
+	CPU         *int     `toml:"cpu"`          // CPU time limit in seconds
+	CPUs        *float64 `toml:"cpus"`         // CPU cores the script may use, e.g. 0.5
+	MaxProcs    *int     `toml:"max-procs"`    // Processes and threads the script may run at once
+}
+
+// Merge overlays overrides on s. Settings set in overrides replace those in s,
+// allow-lists included, so a script can't widen the access the command line
+// grants. Deny-lists are combined, as they only take access away.
+func (s Sandbox) Merge(overrides Sandbox) Sandbox {
+	merged := s
+
+	if overrides.Enabled != nil {
+		merged.Enabled = overrides.Enabled
+	}
+	if overrides.Offline != nil {
+		merged.Offline = overrides.Offline
+	}
+	if overrides.Memory != nil {
+		merged.Memory = overrides.Memory
+	}
+	if overrides.Timeout != nil {
+		merged.Timeout = overrides.Timeout
+	}
+	if overrides.GracePeriod != nil {
+		merged.GracePeriod = overrides.GracePeriod
+	}
+	if overrides.CPU != nil {
+		merged.CPU = overrides.CPU
+	}
+	if overrides.CPUs != nil {
+		merged.CPUs = overrides.CPUs
+	}
+	if overrides.MaxProcs != nil {
+		merged.MaxProcs = overrides.MaxProcs
+	}
+
+	if len(overrides.AllowHost) > 0 {
+		merged.AllowHost = overrides.AllowHost
+	}
+	if len(overrides.AllowRead) > 0 {
+		merged.AllowRead = overrides.AllowRead
+	}
+	if len(overrides.AllowWrite) > 0 {
+		merged.AllowWrite = overrides.AllowWrite
+	}
+	if len(overrides.AllowEnv) > 0 {
+		merged.AllowEnv = overrides.AllowEnv
+	}
+	merged.DenyHost = union(s.DenyHost, overrides.DenyHost)
+
+	return merged
+}
+
+// validate rejects negative limits
+func (s Sandbox) validate() error {
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"memory", s.Memory},
+		{"timeout", s.Timeout},
+		{"grace-period", s.GracePeriod},
+		{"cpu", s.CPU},
+		{"max-procs", s.MaxProcs},
+	}
+	for _, l := range limits {
+		if l.value != nil && *l.value < 0 {
+			return fmt.Errorf("[sandbox] %s must not be negative", l.name)
+		}
+	}
+	if s.CPUs != nil && *s.CPUs < 0 {
+		return fmt.Errorf("[sandbox] cpus must not be negative")
+	}
+	return nil
+}
+
+// union returns the items of a followed by those of b not already present
+func union(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+
+	seen := make(map[string]bool, len(a)+len(b))
+	result := make([]string, 0, len(a)+len(b))
+	for _, list := range [][]string{a, b} {
+		for _, item := range list {
+			if !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var allowHostKey = regexp.MustCompile(`^allow-host\s*=`)
+
+// AddAllowedHosts returns content with hosts added to the [sandbox] allow-host
+// list of its // buns block, creating the block or table when missing.
+// Hosts already listed are skipped; if none are new, content is returned unchanged.
+func AddAllowedHosts(content []byte, hosts []string) ([]byte, error) {
+	meta, err := Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := union(meta.Sandbox.AllowHost, hosts)
+	if len(merged) == len(meta.Sandbox.AllowHost) {
+		return content, nil
+	}
+
+	lines := strings.Split(string(content), "\n")
+	eol := ""
+	if strings.HasSuffix(lines[0], "\r") {
+		eol = "\r"
+	}
+
+	start, end := findBlock(lines)
+	var updated []string
+	if start == -1 {
+		// No block yet - add one after any shebang, ended by a blank line
+		at := 0
+		if strings.HasPrefix(lines[0], "#!") {
+			at = 1
+		}
+		block := []string{"// buns" + eol, "// [sandbox]" + eol, "// " + allowHostLine(merged) + eol, eol}
+		updated = splice(lines, at, at, block)
+	} else {
+		indent := lines[start][:len(lines[start])-len(strings.TrimLeft(lines[start], " \t"))]
+		line := indent + "// " + allowHostLine(merged) + eol
+
+		header, keyStart, keyEnd := findAllowHost(lines[start+1 : end])
+		switch {
+		case keyStart != -1:
+			updated = splice(lines, start+1+keyStart, start+1+keyEnd+1, []string{line})
+		case header != -1:
+			updated = splice(lines, start+1+header+1, start+1+header+1, []string{line})
+		default:
+			updated = splice(lines, end, end, []string{indent + "//" + eol, indent + "// [sandbox]" + eol, line})
+		}
+	}
+
+	result := []byte(strings.Join(updated, "\n"))
+
+	// The block may define [sandbox] in a form we don't edit (e.g. an inline table)
+	check, err := Parse(result)
+	if err != nil || len(check.Sandbox.AllowHost) != len(merged) {
+		return nil, fmt.Errorf("could not add %s to the // buns block; add it to [sandbox] allow-host by hand", strings.Join(hosts, ", "))
+	}
+
+	return result, nil
+}
+
+// findBlock returns the line of the // buns marker and the line after the
+// block's last comment line, or -1 when there is no block
+func findBlock(lines []string) (int, int) {
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "// buns" {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[end]), "//") {
+			end++
+		}
+		return i, end
+	}
+	return -1, -1
+}
+
+// findAllowHost locates the [sandbox] header and the first and last lines of
+// its allow-host key within block lines, using -1 for those not found
+func findAllowHost(block []string) (header, keyStart, keyEnd int) {
+	header, keyStart, keyEnd = -1, -1, -1
+	table := ""
+
+	for i := 0; i < len(block); i++ {
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(block[i]), "//"))
+
+		if strings.HasPrefix(text, "[") && !strings.HasPrefix(text, "[[") {
+			table = strings.TrimSpace(strings.Trim(text, "[]"))
+			if table == "sandbox" {
+				header = i
+			}
+			continue
+		}
+
+		if table != "sandbox" || !allowHostKey.MatchString(text) {
+			continue
+		}
+
+		// The array may span several lines
+		keyStart = i
+		depth := bracketDepth(text)
+		for depth > 0 && i+1 < len(block) {
+			i++
+			depth += bracketDepth(strings.TrimPrefix(strings.TrimSpace(block[i]), "//"))
+		}
+		keyEnd = i
+		return header, keyStart, keyEnd
+	}
+
+	return header, keyStart, keyEnd
+}
+
+// bracketDepth returns the change in array nesting across a line of TOML,
+// ignoring brackets inside strings and comments
+func bracketDepth(text string) int {
+	depth := 0
+	var quote rune
+	for _, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return depth
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// allowHostLine renders the allow-host key as TOML
+func allowHostLine(hosts []string) string {
+	return listLine("allow-host", hosts)
+}
+
+// listLine renders a key holding a list of strings as TOML
+func listLine(key string, items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return key + " = [" + strings.Join(quoted, ", ") + "]"
+}
+
+// FormatSandbox renders the set fields of s as a [sandbox] TOML table, one
+// key per line in the order of the Sandbox struct
+func FormatSandbox(s Sandbox) string {
+	lines := []string{"[sandbox]"}
+
+	flag := func(key string, value *bool) {
+		if value != nil {
+			lines = append(lines, fmt.Sprintf("%s = %t", key, *value))
+		}
+	}
+	list := func(key string, items []string) {
+		if len(items) > 0 {
+			lines = append(lines, listLine(key, items))
+		}
+	}
+	limit := func(key string, value *int) {
+		if value != nil {
+			lines = append(lines, fmt.Sprintf("%s = %d", key, *value))
+		}
+	}
+
+	flag("enabled", s.Enabled)
+	flag("offline", s.Offline)
+	list("allow-host", s.AllowHost)
+	list("deny-host", s.DenyHost)
+	list("allow-read", s.AllowRead)
+	list("allow-write", s.AllowWrite)
+	list("allow-env", s.AllowEnv)
+	limit("memory", s.Memory)
+	limit("timeout", s.Timeout)
+	limit("grace-period", s.GracePeriod)
+	limit("cpu", s.CPU)
+	if s.CPUs != nil {
+		lines = append(lines, "cpus = "+strconv.FormatFloat(*s.CPUs, 'f', -1, 64))
+	}
+	limit("max-procs", s.MaxProcs)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// FormatBlock renders TOML as a // buns comment block
+func FormatBlock(toml string) string {
+	var b strings.Builder
+	b.WriteString("// buns\n")
+	for _, line := range strings.Split(strings.TrimRight(toml, "\n"), "\n") {
+		if line == "" {
+			b.WriteString("//\n")
+		} else {
+			b.WriteString("// " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// splice replaces lines[from:to] with insert
+func splice(lines []string, from, to int, insert []string) []string {
+	result := make([]string, 0, len(lines)-(to-from)+len(insert))
+	result = append(result, lines[:from]...)
+	result = append(result, insert...)
+	return append(result, lines[to:]...)
+}
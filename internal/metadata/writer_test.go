@@ -0,0 +1,200 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddAllowedHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		hosts   []string
+		want    string
+	}{
+		{
+			name: "creates block after shebang",
+			content: `#!/usr/bin/env buns
+// Fetches the weather
+console.log("hi");
+`,
+			hosts: []string{"api.example.com"},
+			want: `#!/usr/bin/env buns
+// buns
+// [sandbox]
+// allow-host = ["api.example.com"]
+
+// Fetches the weather
+console.log("hi");
+`,
+		},
+		{
+			name: "appends sandbox table to block",
+			content: `// buns
+// packages = ["zod@^3.0"]
+
+console.log("hi");
+`,
+			hosts: []string{"api.example.com"},
+			want: `// buns
+// packages = ["zod@^3.0"]
+//
+// [sandbox]
+// allow-host = ["api.example.com"]
+
+console.log("hi");
+`,
+		},
+		{
+			name: "adds key under existing sandbox table",
+			content: `// buns
+// [sandbox]
+// enabled = true
+console.log("hi");
+`,
+			hosts: []string{"api.example.com"},
+			want: `// buns
+// [sandbox]
+// allow-host = ["api.example.com"]
+// enabled = true
+console.log("hi");
+`,
+		},
+		{
+			name: "extends existing list",
+			content: `  // buns
+  // [sandbox]
+  // allow-host = ["a.example"]
+  // timeout = 5
+`,
+			hosts: []string{"b.example", "a.example"},
+			want: `  // buns
+  // [sandbox]
+  // allow-host = ["a.example", "b.example"]
+  // timeout = 5
+`,
+		},
+		{
+			name: "replaces multi-line list",
+			content: `// buns
+// [sandbox]
+// allow-host = [
+//   "a.example", # the API
+//   "[::1]",
+// ]
+// timeout = 5
+`,
+			hosts: []string{"b.example"},
+			want: `// buns
+// [sandbox]
+// allow-host = ["a.example", "[::1]", "b.example"]
+// timeout = 5
+`,
+		},
+		{
+			name:    "keeps CRLF line endings",
+			content: "// buns\r\n// bun = \"1.1\"\r\nconsole.log(1);\r\n",
+			hosts:   []string{"a.example"},
+			want:    "// buns\r\n// bun = \"1.1\"\r\n//\r\n// [sandbox]\r\n// allow-host = [\"a.example\"]\r\nconsole.log(1);\r\n",
+		},
+		{
+			name: "unchanged when already allowed",
+			content: `// buns
+// [sandbox]
+// allow-host = ["a.example"]
+`,
+			hosts: []string{"a.example"},
+			want: `// buns
+// [sandbox]
+// allow-host = ["a.example"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddAllowedHosts([]byte(tt.content), tt.hosts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddAllowedHosts_result_parses(t *testing.T) {
+	content := `// buns
+// bun = ">=1.1"
+// [sandbox]
+// allow-env = ["HOME"]
+`
+	got, err := AddAllowedHosts([]byte(content), []string{"*.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	meta, err := Parse(got)
+	if err != nil {
+		t.Fatalf("updated block does not parse: %v", err)
+	}
+	if !reflect.DeepEqual(meta.Sandbox.AllowHost, []string{"*.example.com"}) {
+		t.Errorf("AllowHost = %v", meta.Sandbox.AllowHost)
+	}
+	if meta.Bun != ">=1.1" || !reflect.DeepEqual(meta.Sandbox.AllowEnv, []string{"HOME"}) {
+		t.Errorf("other settings changed: %+v", meta)
+	}
+}
+
+func TestAddAllowedHosts_rejects_unsupported_layout(t *testing.T) {
+	content := `// buns
+// sandbox = { allow-host = ["a.example"] }
+`
+	if _, err := AddAllowedHosts([]byte(content), []string{"b.example"}); err == nil {
+		t.Error("expected error for an inline sandbox table")
+	}
+}
+
+func TestFormatSandbox(t *testing.T) {
+	enabled := true
+	memory, grace, procs := 256, 10, 512
+	s := Sandbox{
+		Enabled:     &enabled,
+		AllowHost:   []string{"api.example.com:443"},
+		AllowRead:   []string{"/data/in"},
+		AllowEnv:    []string{"API_KEY"},
+		Memory:      &memory,
+		GracePeriod: &grace,
+		MaxProcs:    &procs,
+	}
+
+	want := `[sandbox]
+enabled = true
+allow-host = ["api.example.com:443"]
+allow-read = ["/data/in"]
+allow-env = ["API_KEY"]
+memory = 256
+grace-period = 10
+max-procs = 512
+`
+	got := FormatSandbox(s)
+	if got != want {
+		t.Errorf("FormatSandbox() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The block form parses back to the same policy
+	meta, err := Parse([]byte(FormatBlock(got) + "\nconsole.log(1);\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(meta.Sandbox, s) {
+		t.Errorf("parsed %+v, want %+v", meta.Sandbox, s)
+	}
+}
+
+func TestFormatSandbox_empty(t *testing.T) {
+	if got := FormatSandbox(Sandbox{}); got != "[sandbox]\n" {
+		t.Errorf("FormatSandbox() = %q", got)
+	}
+}
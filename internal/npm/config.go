@@ -0,0 +1,260 @@
+package npm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var envVarRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// RegistryConfig describes a registry endpoint and its optional credentials
+type RegistryConfig struct {
+	URL      string
+	Token    string // Bearer token (_authToken)
+	Username string // Basic auth username
+	Password string // Basic auth password (plain text)
+}
+
+// Config holds the default registry, per-scope registries and credentials
+type Config struct {
+	Registry RegistryConfig
+	Scopes   map[string]RegistryConfig // Keyed by scope, e.g. "@ourco"
+
+	// Auth holds credentials keyed by "nerf-darted" registry URL
+	// (scheme stripped, e.g. "//npm.ourco.com/"), as in .npmrc
+	Auth map[string]RegistryConfig
+}
+
+// DefaultConfig returns a config pointing at the public npm registry
+func DefaultConfig() *Config {
+	return &Config{
+		Registry: RegistryConfig{URL: RegistryURL},
+		Scopes:   map[string]RegistryConfig{},
+		Auth:     map[string]RegistryConfig{},
+	}
+}
+
+// ForPackage returns the registry (with credentials) that serves a package.
+// A scope without a URL is ignored, so its credentials never go to another
+// registry.
+func (c *Config) ForPackage(name string) RegistryConfig {
+	rc := c.Registry
+	if strings.HasPrefix(name, "@") {
+		if idx := strings.Index(name, "/"); idx > 0 {
+			if scoped, ok := c.Scopes[name[:idx]]; ok && scoped.URL != "" {
+				rc = scoped
+			}
+		}
+	}
+	if rc.URL == "" {
+		rc.URL = RegistryURL
+	}
+	return c.withAuth(rc)
+}
+
+// withAuth fills in credentials from the Auth map when none are set inline
+func (c *Config) withAuth(rc RegistryConfig) RegistryConfig {
+	if rc.Token != "" || rc.Username != "" {
+		return rc
+	}
+
+	// Longest matching nerf-dart prefix wins. Both end in "/", so a key
+	// matches whole host and path segments only: //npm.ourco.com doesn't
+	// match //npm.ourco.com.evil.io/.
+	target := nerfDart(rc.URL)
+	best := ""
+	for key := range c.Auth {
+		if strings.HasPrefix(target, nerfDart(key)) && len(key) > len(best) {
+			best = key
+		}
+	}
+	if best != "" {
+		auth := c.Auth[best]
+		rc.Token = auth.Token
+		rc.Username = auth.Username
+		rc.Password = auth.Password
+	}
+	return rc
+}
+
+// Merge overlays other on top of c; values set in other take precedence
+func (c *Config) Merge(other *Config) {
+	if other == nil {
+		return
+	}
+	if other.Registry.URL != "" {
+		c.Registry.URL = other.Registry.URL
+	}
+	if other.Registry.Token != "" || other.Registry.Username != "" {
+		c.Registry.Token = other.Registry.Token
+		c.Registry.Username = other.Registry.Username
+		c.Registry.Password = other.Registry.Password
+	}
+	for scope, rc := range other.Scopes {
+		c.Scopes[scope] = rc
+	}
+	for key, rc := range other.Auth {
+		c.Auth[key] = rc
+	}
+}
+
+// IsDefault reports whether the config is just the public registry without credentials
+func (c *Config) IsDefault() bool {
+	return strings.TrimSuffix(c.Registry.URL, "/") == RegistryURL &&
+		c.Registry.Token == "" && c.Registry.Username == "" &&
+		len(c.Scopes) == 0 && len(c.Auth) == 0
+}
+
+// AuthHeader returns the Authorization header value for the registry, if any
+func (rc RegistryConfig) AuthHeader() string {
+	if rc.Token != "" {
+		return "Bearer " + rc.Token
+	}
+	if rc.Username != "" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(rc.Username+":"+rc.Password))
+	}
+	return ""
+}
+
+// LoadNpmrc reads an .npmrc file. Returns an empty config if the file does not exist.
+func LoadNpmrc(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{Scopes: map[string]RegistryConfig{}, Auth: map[string]RegistryConfig{}}, nil
+		}
+		return nil, err
+	}
+	return ParseNpmrc(data)
+}
+
+// ParseNpmrc parses the registry-related settings of an .npmrc file:
+// registry, @scope:registry and per-registry _authToken, _auth, username and _password.
+// ${VAR} references are expanded from the environment.
+func ParseNpmrc(data []byte) (*Config, error) {
+	cfg := &Config{
+		Scopes: map[string]RegistryConfig{},
+		Auth:   map[string]RegistryConfig{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = expandEnv(strings.Trim(strings.TrimSpace(value), `"'`))
+
+		switch {
+		case key == "registry":
+			cfg.Registry.URL = value
+
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry"):
+			scope := strings.TrimSuffix(key, ":registry")
+			cfg.Scopes[scope] = RegistryConfig{URL: value}
+
+		case strings.HasPrefix(key, "//"):
+			idx := strings.LastIndex(key, ":")
+			if idx < 0 {
+				continue
+			}
+			prefix, setting := key[:idx], key[idx+1:]
+			auth := cfg.Auth[prefix]
+			switch setting {
+			case "_authToken":
+				auth.Token = value
+			case "_auth":
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid _auth for %s: %w", prefix, err)
+				}
+				auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+			case "username":
+				auth.Username = value
+			case "_password":
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid _password for %s: %w", prefix, err)
+				}
+				auth.Password = string(decoded)
+			default:
+				continue
+			}
+			cfg.Auth[prefix] = auth
+		}
+	}
+
+	return cfg, scanner.Err()
+}
+
+// Npmrc renders the config as an .npmrc file that bun install understands
+func (c *Config) Npmrc() []byte {
+	var buf bytes.Buffer
+
+	registries := []RegistryConfig{c.ForPackage("")}
+	if c.Registry.URL != "" {
+		fmt.Fprintf(&buf, "registry=%s\n", c.Registry.URL)
+	}
+
+	scopes := make([]string, 0, len(c.Scopes))
+	for scope := range c.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		if c.Scopes[scope].URL == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s:registry=%s\n", scope, c.Scopes[scope].URL)
+		registries = append(registries, c.withAuth(c.Scopes[scope]))
+	}
+
+	seen := map[string]bool{}
+	for _, rc := range registries {
+		prefix := nerfDart(rc.URL)
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+
+		if rc.Token != "" {
+			fmt.Fprintf(&buf, "%s:_authToken=%s\n", prefix, rc.Token)
+		} else if rc.Username != "" {
+			auth := base64.StdEncoding.EncodeToString([]byte(rc.Username + ":" + rc.Password))
+			fmt.Fprintf(&buf, "%s:_auth=%s\n", prefix, auth)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// nerfDart strips the scheme from a registry URL and ensures a trailing slash,
+// e.g. "https://npm.ourco.com/repo" -> "//npm.ourco.com/repo/"
+func nerfDart(url string) string {
+	if idx := strings.Index(url, "://"); idx >= 0 {
+		url = url[idx+1:]
+	}
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return url
+}
+
+// expandEnv replaces ${VAR} references with environment values
+func expandEnv(s string) string {
+	return envVarRegex.ReplaceAllStringFunc(s, func(m string) string {
+		return os.Getenv(m[2 : len(m)-1])
+	})
+}
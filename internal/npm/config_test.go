@@ -0,0 +1,163 @@
+package npm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseNpmrc(t *testing.T) {
+	t.Setenv("OURCO_TOKEN", "secret-token")
+
+	cfg, err := ParseNpmrc([]byte(`
+; comment
+registry=https://npm.mirror.example/
+@ourco:registry=https://npm.ourco.com/repo/
+//npm.ourco.com/repo/:_authToken=${OURCO_TOKEN}
+//npm.mirror.example/:_auth=dXNlcjpwYXNz
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Registry.URL != "https://npm.mirror.example/" {
+		t.Errorf("registry = %q", cfg.Registry.URL)
+	}
+
+	scoped := cfg.ForPackage("@ourco/widgets")
+	if scoped.URL != "https://npm.ourco.com/repo/" {
+		t.Errorf("scoped registry = %q", scoped.URL)
+	}
+	if scoped.Token != "secret-token" {
+		t.Errorf("scoped token = %q, want expanded env var", scoped.Token)
+	}
+
+	unscoped := cfg.ForPackage("zod")
+	if unscoped.Username != "user" || unscoped.Password != "pass" {
+		t.Errorf("unscoped auth = %q:%q, want user:pass", unscoped.Username, unscoped.Password)
+	}
+}
+
+func TestConfig_Merge(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Merge(&Config{
+		Scopes: map[string]RegistryConfig{"@ourco": {URL: "https://a.example/"}},
+	})
+	cfg.Merge(&Config{
+		Registry: RegistryConfig{URL: "https://b.example/"},
+		Scopes:   map[string]RegistryConfig{"@ourco": {URL: "https://c.example/", Token: "t"}},
+	})
+
+	if cfg.Registry.URL != "https://b.example/" {
+		t.Errorf("registry = %q, want override", cfg.Registry.URL)
+	}
+	if got := cfg.ForPackage("@ourco/x"); got.URL != "https://c.example/" || got.Token != "t" {
+		t.Errorf("scope = %+v, want later config to win", got)
+	}
+	if cfg.IsDefault() {
+		t.Error("merged config should not be default")
+	}
+	if !DefaultConfig().IsDefault() {
+		t.Error("DefaultConfig should be default")
+	}
+}
+
+func TestConfig_Npmrc(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Scopes["@ourco"] = RegistryConfig{URL: "https://npm.ourco.com/", Token: "abc"}
+
+	npmrc := string(cfg.Npmrc())
+
+	for _, want := range []string{
+		"registry=https://registry.npmjs.org\n",
+		"@ourco:registry=https://npm.ourco.com/\n",
+		"//npm.ourco.com/:_authToken=abc\n",
+	} {
+		if !strings.Contains(npmrc, want) {
+			t.Errorf(".npmrc missing %q:\n%s", want, npmrc)
+		}
+	}
+}
+
+func TestConfig_ForPackage_ignores_scope_without_url(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Scopes["@ourco"] = RegistryConfig{Token: "s3cret"}
+
+	rc := cfg.ForPackage("@ourco/widgets")
+
+	if rc.URL != RegistryURL || rc.Token != "" {
+		t.Errorf("ForPackage() = %+v, want the default registry without the scope's token", rc)
+	}
+	if npmrc := string(cfg.Npmrc()); strings.Contains(npmrc, "s3cret") || strings.Contains(npmrc, "@ourco") {
+		t.Errorf(".npmrc has the scope without a URL:\n%s", npmrc)
+	}
+}
+
+func TestConfig_ForPackage_auth_matches_whole_hosts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth["//npm.ourco.com"] = RegistryConfig{Token: "s3cret"}
+	cfg.Auth["//npm.ourco.com/private/"] = RegistryConfig{Token: "private"}
+
+	tests := []struct {
+		url   string
+		token string
+	}{
+		{"https://npm.ourco.com", "s3cret"},
+		{"https://npm.ourco.com/", "s3cret"},
+		{"https://npm.ourco.com/team/", "s3cret"},
+		{"https://npm.ourco.com/private", "private"},
+		{"https://npm.ourco.com.evil.io/", ""},
+		{"https://npm.ourco.com-mirror.net/", ""},
+		{"https://npm.ourco.com:8443/", ""},
+		{"https://npm.ourco.com/private-eye/", "s3cret"},
+	}
+	for _, tt := range tests {
+		cfg.Scopes["@ourco"] = RegistryConfig{URL: tt.url}
+		if rc := cfg.ForPackage("@ourco/widgets"); rc.Token != tt.token {
+			t.Errorf("%s: token = %q, want %q", tt.url, rc.Token, tt.token)
+		}
+	}
+}
+
+func TestRegistry_uses_scoped_registry_with_auth(t *testing.T) {
+	var gotAuth string
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		if gotAuth != "Bearer private-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/@ourco%2fwidgets" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "@ourco/widgets", "dist-tags": {"latest": "2.0.0"}, "versions": {"2.0.0": {"version": "2.0.0"}}}`))
+	}))
+	defer private.Close()
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("public registry should not be queried for scoped package, got %s", r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer public.Close()
+
+	cfg := DefaultConfig()
+	cfg.Registry.URL = public.URL
+	cfg.Scopes["@ourco"] = RegistryConfig{URL: private.URL}
+	cfg.Auth[nerfDart(private.URL)] = RegistryConfig{Token: "private-token"}
+
+	r := NewRegistryWithConfig(cfg)
+	pv, err := r.Resolve("@ourco/widgets@^2.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pv.Name != "@ourco/widgets" || pv.Version != "2.0.0" {
+		t.Errorf("resolved %s@%s, want @ourco/widgets@2.0.0", pv.Name, pv.Version)
+	}
+
+	cfg.Auth[nerfDart(private.URL)] = RegistryConfig{Token: "wrong"}
+	if _, err := r.Resolve("@ourco/widgets"); err == nil || !strings.Contains(err.Error(), "denied access") {
+		t.Errorf("got %v, want access denied error", err)
+	}
+}
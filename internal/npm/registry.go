@@ -1,17 +1,28 @@
 package npm
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 )
 
 const (
 	RegistryURL = "https://registry.npmjs.org"
+
+	// RequestTimeout bounds each registry request, so a stalled registry
+	// fails the run rather than hanging it
+	RequestTimeout = 30 * time.Second
+
+	// installAccept asks for the abbreviated metadata npm clients install
+	// from, which is a fraction of the size of the full document
+	installAccept = "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8"
 )
 
 // PackageInfo represents npm package metadata
@@ -23,33 +34,92 @@ type PackageInfo struct {
 
 // PackageVersion represents a specific version's metadata
 type PackageVersion struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Dist    PackageDist `json:"dist"`
+}
+
+// PackageDist describes where a version's tarball lives and how to verify it
+type PackageDist struct {
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+	Shasum    string `json:"shasum"`
 }
 
 // Registry handles npm registry lookups
-type Registry struct{}
+type Registry struct {
+	config *Config
+	client *http.Client
+}
 
-// NewRegistry creates a new npm registry client
+// NewRegistry creates a new npm registry client for the public registry
 func NewRegistry() *Registry {
-	return &Registry{}
+	return NewRegistryWithConfig(DefaultConfig())
+}
+
+// NewRegistryWithURL creates a registry client for a custom registry URL
+func NewRegistryWithURL(url string) *Registry {
+	cfg := DefaultConfig()
+	cfg.Registry.URL = url
+	return NewRegistryWithConfig(cfg)
+}
+
+// NewRegistryWithConfig creates a registry client using scoped registries and credentials
+func NewRegistryWithConfig(cfg *Config) *Registry {
+	return &Registry{config: cfg, client: &http.Client{Timeout: RequestTimeout}}
+}
+
+// Config returns the registry configuration
+func (r *Registry) Config() *Config {
+	return r.config
 }
 
 // ResolveVersion resolves a package spec (name@constraint) to a concrete version
 func (r *Registry) ResolveVersion(packageSpec string) (string, string, error) {
+	pv, err := r.Resolve(packageSpec)
+	if err != nil {
+		return "", "", err
+	}
+	return pv.Name, pv.Version, nil
+}
+
+// Resolve resolves a package spec (name@constraint) to the metadata of a concrete version
+func (r *Registry) Resolve(packageSpec string) (*PackageVersion, error) {
 	name, constraint := parsePackageSpec(packageSpec)
 
 	info, err := r.fetchPackage(name)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	version, err := r.resolveConstraint(info, constraint)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+
+	pv := info.Versions[version]
+	if pv.Name == "" {
+		pv.Name = name
+	}
+	if pv.Version == "" {
+		pv.Version = version
 	}
 
-	return name, version, nil
+	return &pv, nil
+}
+
+// Integrity returns the Subresource Integrity string for the version,
+// falling back to the legacy sha1 shasum for old packages
+func (v *PackageVersion) Integrity() string {
+	if v.Dist.Integrity != "" {
+		return v.Dist.Integrity
+	}
+	if v.Dist.Shasum != "" {
+		if raw, err := hex.DecodeString(v.Dist.Shasum); err == nil {
+			return "sha1-" + base64.StdEncoding.EncodeToString(raw)
+		}
+	}
+	return ""
 }
 
 // ValidatePackage checks if a package exists
@@ -60,9 +130,19 @@ func (r *Registry) ValidatePackage(name string) error {
 
 // fetchPackage retrieves package info from npm registry
 func (r *Registry) fetchPackage(name string) (*PackageInfo, error) {
-	url := fmt.Sprintf("%s/%s", RegistryURL, name)
+	rc := r.config.ForPackage(name)
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(rc.URL, "/"), strings.Replace(name, "/", "%2f", 1))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", installAccept)
+	if auth := rc.AuthHeader(); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
 
-	resp, err := http.Get(url)
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch package %s: %w", name, err)
 	}
@@ -72,6 +152,10 @@ func (r *Registry) fetchPackage(name string) (*PackageInfo, error) {
 		return nil, fmt.Errorf("package not found: %s", name)
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("npm registry %s denied access to %s (HTTP %d); check your registry credentials", rc.URL, name, resp.StatusCode)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("npm registry returned %d for %s", resp.StatusCode, name)
 	}
@@ -97,10 +181,13 @@ func (r *Registry) resolveConstraint(info *PackageInfo, constraint string) (stri
 	// Try to parse as semver constraint
 	c, err := semver.NewConstraint(constraint)
 	if err != nil {
-		// Maybe it's an exact version
+		// Maybe it's an exact version or a dist-tag (e.g. "latest", "next")
 		if _, ok := info.Versions[constraint]; ok {
 			return constraint, nil
 		}
+		if tagged, ok := info.DistTags[constraint]; ok {
+			return tagged, nil
+		}
 		return "", fmt.Errorf("invalid version constraint '%s': %w", constraint, err)
 	}
 
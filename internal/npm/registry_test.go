@@ -1,6 +1,9 @@
 package npm
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -84,3 +87,61 @@ func TestRegistry_ResolveVersion(t *testing.T) {
 		}
 	})
 }
+
+func TestRegistry_Resolve_with_stub_registry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zod" {
+			http.NotFound(w, r)
+			return
+		}
+		// Only the abbreviated metadata is needed
+		if !strings.HasPrefix(r.Header.Get("Accept"), "application/vnd.npm.install-v1+json") {
+			http.Error(w, "full document requested", http.StatusNotAcceptable)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"name": "zod",
+			"dist-tags": {"latest": "3.24.1", "next": "4.0.0-beta.1"},
+			"versions": {
+				"3.23.8": {"name": "zod", "version": "3.23.8", "dist": {"integrity": "sha512-old"}},
+				"3.24.1": {"name": "zod", "version": "3.24.1", "dist": {"integrity": "sha512-new"}},
+				"4.0.0-beta.1": {"name": "zod", "version": "4.0.0-beta.1", "dist": {"shasum": "0123456789abcdef0123456789abcdef01234567"}}
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	r := NewRegistryWithURL(server.URL)
+
+	t.Run("resolves constraint with integrity", func(t *testing.T) {
+		pv, err := r.Resolve("zod@^3.0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pv.Version != "3.24.1" {
+			t.Errorf("version = %s, want 3.24.1", pv.Version)
+		}
+		if pv.Integrity() != "sha512-new" {
+			t.Errorf("integrity = %s, want sha512-new", pv.Integrity())
+		}
+	})
+
+	t.Run("resolves dist-tag", func(t *testing.T) {
+		pv, err := r.Resolve("zod@next")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pv.Version != "4.0.0-beta.1" {
+			t.Errorf("version = %s, want 4.0.0-beta.1", pv.Version)
+		}
+		if !strings.HasPrefix(pv.Integrity(), "sha1-") {
+			t.Errorf("integrity = %s, want sha1 fallback", pv.Integrity())
+		}
+	})
+
+	t.Run("returns error for unknown package", func(t *testing.T) {
+		if _, err := r.Resolve("missing"); err == nil {
+			t.Error("expected error for unknown package")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Audit decisions
+const (
+	DecisionAllowed = "allowed"
+	DecisionDenied  = "denied"
+)
+
+// Audit protocols
+const (
+	ProtocolHTTP        = "http"        // Plain HTTP request through the HTTP proxy
+	ProtocolConnect     = "connect"     // CONNECT tunnel (usually HTTPS) through the HTTP proxy
+	ProtocolSOCKS5      = "socks5"      // SOCKS5 CONNECT
+	ProtocolTransparent = "transparent" // Connection intercepted in the script's network namespace
+)
+
+// AuditRecord describes one connection attempt through the proxies
+type AuditRecord struct {
+	Time          time.Time `json:"time"` // When the attempt started
+	Protocol      string    `json:"protocol"`
+	Host          string    `json:"host"`
+	Port          int       `json:"port"`
+	Decision      string    `json:"decision"`
+	Reason        string    `json:"reason,omitempty"` // Why it was denied
+	Error         string    `json:"error,omitempty"`  // Why an allowed connection failed
+	BytesSent     int64     `json:"bytes_sent"`       // Script to host
+	BytesReceived int64     `json:"bytes_received"`   // Host to script
+	DurationMS    int64     `json:"duration_ms"`
+}
+
+// AuditLog writes audit records as JSON lines. It is safe for concurrent use,
+// and a nil *AuditLog discards records.
+type AuditLog struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	observers []func(AuditRecord)
+}
+
+// NewAuditLog creates an audit log writing to w. With a nil w, records are
+// only passed to observers.
+func NewAuditLog(w io.Writer) *AuditLog {
+	l := &AuditLog{}
+	if w != nil {
+		l.enc = json.NewEncoder(w)
+	}
+	return l
+}
+
+// Observe calls fn with every record from now on. Calls are serialized.
+func (l *AuditLog) Observe(fn func(AuditRecord)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.observers = append(l.observers, fn)
+}
+
+// Record writes a record. Write errors are ignored so auditing never breaks a run.
+func (l *AuditLog) Record(rec AuditRecord) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc != nil {
+		_ = l.enc.Encode(rec)
+	}
+	for _, fn := range l.observers {
+		fn(rec)
+	}
+}
+
+// auditEntry collects a record while a connection is in progress
+type auditEntry struct {
+	log      *AuditLog
+	start    time.Time
+	protocol string
+	host     string
+	port     int
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (l *AuditLog) begin(protocol, host string, port int) *auditEntry {
+	return &auditEntry{log: l, start: time.Now(), protocol: protocol, host: host, port: port}
+}
+
+// finish records the outcome: denied for policy errors, otherwise allowed
+// (with the error if the connection failed)
+func (e *auditEntry) finish(err error) {
+	if e.log == nil {
+		return
+	}
+
+	rec := AuditRecord{
+		Time:          e.start.UTC(),
+		Protocol:      e.protocol,
+		Host:          e.host,
+		Port:          e.port,
+		Decision:      DecisionAllowed,
+		BytesSent:     e.sent.Load(),
+		BytesReceived: e.received.Load(),
+		DurationMS:    time.Since(e.start).Milliseconds(),
+	}
+
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		rec.Decision = DecisionDenied
+		rec.Reason = blocked.Reason
+	} else if err != nil {
+		rec.Error = err.Error()
+	}
+
+	e.log.Record(rec)
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readAudit decodes the records written to buf
+func readAudit(t *testing.T, buf *bytes.Buffer) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	dec := json.NewDecoder(buf)
+	for {
+		var rec AuditRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatalf("invalid audit line: %v", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// newUpstream starts an HTTP server on 127.0.0.1 echoing request bodies
+func newUpstream(t *testing.T) (*httptest.Server, int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "echo:%s", body)
+	}))
+	t.Cleanup(server.Close)
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return server, portNum
+}
+
+func TestHTTPProxy_audit_log(t *testing.T) {
+	upstream, port := newUpstream(t)
+
+	filter := NewDomainFilter()
+	_ = filter.AddAllowed("127.0.0.1")
+
+	var buf bytes.Buffer
+	p, err := NewHTTPProxy(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetAuditLog(NewAuditLog(&buf))
+	_ = p.Start()
+
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// Plain HTTP request with a body
+	resp, err := client.Post(upstream.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	// Blocked host
+	resp, err = client.Get("http://blocked.invalid/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", resp.StatusCode)
+	}
+
+	// CONNECT tunnel
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("127.0.0.1:%d", port)
+	_, _ = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	reader := bufio.NewReader(conn)
+	if status, _ := reader.ReadString('\n'); !strings.Contains(status, "200") {
+		t.Fatalf("CONNECT status = %q", status)
+	}
+	_, _ = reader.ReadString('\n') // Blank line ending the response
+	_, _ = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target)
+	_, _ = io.ReadAll(reader)
+	_ = conn.Close()
+
+	// Stop waits for the tunnel's record
+	_ = p.Stop()
+
+	records := readAudit(t, &buf)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3: %+v", len(records), records)
+	}
+
+	post := records[0]
+	if post.Protocol != ProtocolHTTP || post.Host != "127.0.0.1" || post.Port != port || post.Decision != DecisionAllowed {
+		t.Errorf("unexpected HTTP record: %+v", post)
+	}
+	if post.BytesSent != 5 || post.BytesReceived != int64(len("echo:hello")) {
+		t.Errorf("HTTP bytes = %d sent, %d received", post.BytesSent, post.BytesReceived)
+	}
+	if post.Time.IsZero() {
+		t.Error("missing timestamp")
+	}
+
+	blocked := records[1]
+	if blocked.Host != "blocked.invalid" || blocked.Port != 80 || blocked.Decision != DecisionDenied || blocked.Reason == "" {
+		t.Errorf("unexpected denied record: %+v", blocked)
+	}
+
+	tunnel := records[2]
+	if tunnel.Protocol != ProtocolConnect || tunnel.Port != port || tunnel.Decision != DecisionAllowed {
+		t.Errorf("unexpected CONNECT record: %+v", tunnel)
+	}
+	if tunnel.BytesSent == 0 || tunnel.BytesReceived == 0 {
+		t.Errorf("CONNECT bytes = %d sent, %d received", tunnel.BytesSent, tunnel.BytesReceived)
+	}
+}
+
+func TestSOCKS5Proxy_audit_log(t *testing.T) {
+	_, port := newUpstream(t)
+
+	filter := NewDomainFilter()
+	_ = filter.AddAllowed("127.0.0.1")
+
+	var buf bytes.Buffer
+	p, err := NewSOCKS5Proxy(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetAuditLog(NewAuditLog(&buf))
+	_ = p.Start()
+
+	// socksConnect performs a SOCKS5 CONNECT to a domain and returns the reply code
+	socksConnect := func(host string, port int) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write([]byte{socks5Version, 1, authNone})
+		_, _ = io.ReadFull(conn, make([]byte, 2))
+
+		req := []byte{socks5Version, cmdConnect, 0, atypDomain, byte(len(host))}
+		req = append(req, host...)
+		req = append(req, byte(port>>8), byte(port))
+		_, _ = conn.Write(req)
+
+		reply := make([]byte, 10)
+		_, _ = io.ReadFull(conn, reply)
+		return conn, reply[1]
+	}
+
+	conn, rep := socksConnect("127.0.0.1", port)
+	if rep != repSuccess {
+		t.Fatalf("reply = %d, want success", rep)
+	}
+	_, _ = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	_, _ = io.ReadAll(conn)
+	_ = conn.Close()
+
+	conn, rep = socksConnect("Blocked.invalid", 443)
+	_ = conn.Close()
+	if rep != repNotAllowed {
+		t.Errorf("reply = %d, want not allowed", rep)
+	}
+
+	_ = p.Stop()
+
+	records := readAudit(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2: %+v", len(records), records)
+	}
+	if r := records[0]; r.Protocol != ProtocolSOCKS5 || r.Decision != DecisionAllowed || r.Port != port || r.BytesSent == 0 || r.BytesReceived == 0 {
+		t.Errorf("unexpected allowed record: %+v", r)
+	}
+	if r := records[1]; r.Host != "blocked.invalid" || r.Port != 443 || r.Decision != DecisionDenied {
+		t.Errorf("unexpected denied record: %+v", r)
+	}
+}
+
+func TestAuditLog_nil_discards(t *testing.T) {
+	var l *AuditLog
+	l.Record(AuditRecord{Host: "example.com"})
+	l.begin(ProtocolHTTP, "example.com", 80).finish(nil)
+}
+
+func TestAuditLog_Observe(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewAuditLog(&buf)
+
+	var seen []string
+	l.Observe(func(rec AuditRecord) { seen = append(seen, rec.Host) })
+	l.Record(AuditRecord{Host: "a.example"})
+	l.Record(AuditRecord{Host: "b.example"})
+
+	if len(seen) != 2 || seen[0] != "a.example" || seen[1] != "b.example" {
+		t.Errorf("observed %v", seen)
+	}
+	if len(readAudit(t, &buf)) != 2 {
+		t.Error("records should still be written")
+	}
+
+	// Observers only
+	quiet := NewAuditLog(nil)
+	quiet.Observe(func(rec AuditRecord) { seen = append(seen, rec.Host) })
+	quiet.Record(AuditRecord{Host: "c.example"})
+	if len(seen) != 3 {
+		t.Errorf("observed %v", seen)
+	}
+}
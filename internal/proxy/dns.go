@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// DNSPath is where the proxy answers DNS queries for the script's network
+// namespace: DNS messages POSTed in requests without a host, as in DNS over
+// HTTPS (RFC 8484). Only names the filter allows are looked up and answered;
+// every other name gets NXDOMAIN, so queries can't carry data out of the
+// sandbox.
+const DNSPath = "/dns-query"
+
+// DNSContentType is the media type of DNS messages sent to and from DNSPath
+const DNSContentType = "application/dns-message"
+
+// DNS message fields
+const (
+	dnsHeaderLen = 12
+	dnsTypeA     = 1
+	dnsTypeAAAA  = 28
+	dnsClassIN   = 1
+	dnsTTL       = 60  // Seconds, short as rules can change while the script runs
+	maxDNSAnswer = 512 // The most a UDP answer may take without EDNS
+
+	rcodeFormErr  = 1
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+)
+
+// handleDNS answers a DNS query POSTed to DNSPath
+func (p *HTTPProxy) handleDNS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "DNS queries must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	answer := answerDNS(r.Context(), p.filter, query)
+	if answer == nil {
+		http.Error(w, "invalid DNS message", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", DNSContentType)
+	_, _ = w.Write(answer)
+}
+
+// answerDNS answers a DNS query with the addresses filter resolves its name
+// to, returning nil for messages too broken to answer. Only A and AAAA
+// queries get records; other types of allowed names get an empty answer.
+func answerDNS(ctx context.Context, filter *DomainFilter, query []byte) []byte {
+	if len(query) < dnsHeaderLen || query[2]&0x80 != 0 {
+		return nil
+	}
+	if opcode := query[2] >> 3 & 0xf; opcode != 0 {
+		return dnsReply(query, nil, nil, rcodeNotImp)
+	}
+	if binary.BigEndian.Uint16(query[4:]) != 1 {
+		return dnsReply(query, nil, nil, rcodeFormErr)
+	}
+
+	name, end, ok := parseDNSName(query, dnsHeaderLen)
+	if !ok || len(query) < end+4 {
+		return dnsReply(query, nil, nil, rcodeFormErr)
+	}
+	question := query[dnsHeaderLen : end+4]
+	qtype := binary.BigEndian.Uint16(query[end:])
+	if binary.BigEndian.Uint16(query[end+2:]) != dnsClassIN {
+		return dnsReply(query, question, nil, rcodeNotImp)
+	}
+
+	addrs, err := filter.Resolve(ctx, name)
+	if err != nil {
+		var blocked *BlockedError
+		var dnsErr *net.DNSError
+		if errors.As(err, &blocked) || errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return dnsReply(query, question, nil, rcodeNXDomain)
+		}
+		return dnsReply(query, question, nil, rcodeServFail)
+	}
+
+	var answers []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is4() && qtype == dnsTypeA || addr.Is6() && qtype == dnsTypeAAAA {
+			answers = append(answers, addr)
+		}
+	}
+	return dnsReply(query, question, answers, 0)
+}
+
+// parseDNSName reads the name starting at off in msg, returning it and the
+// offset just past it. Questions don't use compression, so pointers are
+// rejected, as are labels holding dots, which would make the name ambiguous.
+func parseDNSName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		if n > 63 || off+n > len(msg) {
+			return "", 0, false
+		}
+		label := string(msg[off : off+n])
+		if strings.Contains(label, ".") {
+			return "", 0, false
+		}
+		labels = append(labels, label)
+		off += n
+	}
+	name := strings.Join(labels, ".")
+	if len(name) > 253 {
+		return "", 0, false
+	}
+	return name, off, true
+}
+
+// dnsReply builds the response to query with rcode, echoing question and
+// answering it with the addresses that fit in maxDNSAnswer
+func dnsReply(query, question []byte, answers []netip.Addr, rcode byte) []byte {
+	reply := make([]byte, dnsHeaderLen, maxDNSAnswer)
+	copy(reply, query[:2])
+	// A response with the query's opcode and recursion desired bit, from a
+	// server offering recursion
+	reply[2] = 0x80 | query[2]&0x79
+	reply[3] = 0x80 | rcode
+	if question == nil {
+		return reply
+	}
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	reply = append(reply, question...)
+
+	var count uint16
+	for _, addr := range answers {
+		data := addr.AsSlice()
+		qtype := uint16(dnsTypeA)
+		if addr.Is6() {
+			qtype = dnsTypeAAAA
+		}
+		if len(reply)+12+len(data) > maxDNSAnswer {
+			break
+		}
+		// The name is a pointer to the question's
+		reply = append(reply, 0xc0, dnsHeaderLen)
+		reply = binary.BigEndian.AppendUint16(reply, qtype)
+		reply = binary.BigEndian.AppendUint16(reply, dnsClassIN)
+		reply = binary.BigEndian.AppendUint32(reply, dnsTTL)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(data)))
+		reply = append(reply, data...)
+		count++
+	}
+	binary.BigEndian.PutUint16(reply[6:], count)
+	return reply
+}
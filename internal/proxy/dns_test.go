@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dnsQuery builds a query for name, with an EDNS record as resolvers add
+func dnsQuery(name string, qtype uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 1}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	return append(msg, 0, 0, 41, 4, 0xd0, 0, 0, 0, 0, 0, 0) // OPT
+}
+
+// parseReply returns the rcode of a reply to a dnsQuery and its addresses
+func parseReply(t *testing.T, reply []byte) (int, []string) {
+	t.Helper()
+	if len(reply) < dnsHeaderLen || reply[0] != 0x12 || reply[1] != 0x34 || reply[2]&0x80 == 0 {
+		t.Fatalf("reply = %x, not a response to the query", reply)
+	}
+	rcode := int(reply[3] & 0xf)
+	if binary.BigEndian.Uint16(reply[4:]) == 0 {
+		return rcode, nil
+	}
+
+	_, off, ok := parseDNSName(reply, dnsHeaderLen)
+	if !ok {
+		t.Fatalf("reply = %x, bad question", reply)
+	}
+	off += 4
+	var addrs []string
+	for range binary.BigEndian.Uint16(reply[6:]) {
+		length := int(binary.BigEndian.Uint16(reply[off+10:]))
+		addr, _ := netip.AddrFromSlice(reply[off+12 : off+12+length])
+		addrs = append(addrs, addr.String())
+		off += 12 + length
+	}
+	return rcode, addrs
+}
+
+func TestAnswerDNS(t *testing.T) {
+	f := newRuleFilter(t, []string{"*.example.com"}, []string{"admin.example.com"}, nil)
+	f.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		if host == "api.example.com" {
+			return []netip.Addr{netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("2606:2800:220:1::1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	tests := []struct {
+		name  string
+		query []byte
+		rcode int
+		addrs []string
+	}{
+		{"A", dnsQuery("api.example.com", dnsTypeA), 0, []string{"93.184.216.34"}},
+		{"AAAA", dnsQuery("api.example.com", dnsTypeAAAA), 0, []string{"2606:2800:220:1::1"}},
+		{"other type", dnsQuery("api.example.com", 16), 0, nil},
+		{"name that doesn't exist", dnsQuery("gone.example.com", dnsTypeA), rcodeNXDomain, nil},
+		{"denied name", dnsQuery("admin.example.com", dnsTypeA), rcodeNXDomain, nil},
+		{"name outside the rules", dnsQuery("c2VjcmV0.attacker.example", dnsTypeA), rcodeNXDomain, nil},
+		{"label with a dot", []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07api.exa\x03com\x00\x00\x01\x00\x01"), rcodeFormErr, nil},
+		{"compressed name", []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\xc0\x0c\x00\x01\x00\x01"), rcodeFormErr, nil},
+		{"truncated question", dnsQuery("api.example.com", dnsTypeA)[:20], rcodeFormErr, nil},
+		{"inverse query", []byte("\x12\x34\x09\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x01"), rcodeNotImp, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcode, addrs := parseReply(t, answerDNS(context.Background(), f, tt.query))
+			if rcode != tt.rcode || !slices.Equal(addrs, tt.addrs) {
+				t.Errorf("answer = rcode %d %v, want rcode %d %v", rcode, addrs, tt.rcode, tt.addrs)
+			}
+		})
+	}
+
+	if reply := answerDNS(context.Background(), f, []byte{0x12, 0x34}); reply != nil {
+		t.Errorf("answered a short message: %x", reply)
+	}
+}
+
+func TestHTTPProxy_DNS(t *testing.T) {
+	filter := NewDomainFilter()
+	_ = filter.AddAllowed("localhost")
+	p, err := NewHTTPProxy(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Start()
+	defer func() { _ = p.Stop() }()
+
+	url := "http://" + p.Addr() + DNSPath
+	for _, tt := range []struct {
+		name  string
+		rcode int
+	}{
+		{"localhost", 0},
+		{"blocked.invalid", rcodeNXDomain},
+	} {
+		resp, err := http.Post(url, DNSContentType, bytes.NewReader(dnsQuery(tt.name, dnsTypeA)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != DNSContentType {
+			t.Errorf("%s: Content-Type = %q", tt.name, ct)
+		}
+		if rcode, _ := parseReply(t, reply); rcode != tt.rcode {
+			t.Errorf("%s: rcode = %d, want %d", tt.name, rcode, tt.rcode)
+		}
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -1,71 +1,399 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-// DomainFilter handles domain allowlisting.
+// DomainFilter decides which hosts sandboxed scripts may connect to, using
+// allow and deny rules (see rule for the accepted forms). Deny rules win over
+// allow rules, and anything not allowed is blocked.
+//
+// Rules are checked again after DNS resolution, against every address a host
+// resolves to:
+//   - link-local and cloud metadata addresses (e.g. 169.254.169.254) are only
+//     reachable through an IP rule that covers them
+//   - a host allowed by name that resolves to a private or loopback address is
+//     blocked unless an IP rule covers the address, so an allowed name cannot
+//     be pointed at internal services
+//
+// It is safe for concurrent use, as hosts can be allowed while the proxies run.
 type DomainFilter struct {
-	allowedDomains  []string
-	wildcardDomains []string // Domains starting with *.
-	allowAll        bool
+	mu       sync.RWMutex
+	allow    []rule
+	deny     []rule
+	allowAll bool
+
+	lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+	dialer net.Dialer
+
+	prompter Prompter
+	promptMu sync.Mutex      // Serializes prompts
+	denied   map[string]bool // Hosts refused at a prompt
+	answered map[string]bool // Hosts allowed at a prompt and not yet blocked
+	always   []string        // Hosts allowed "always" at a prompt
+
+	resolved map[netip.Addr]string // Names Resolve answered with each address
+}
+
+// BlockedError reports a connection refused by the filter
+type BlockedError struct {
+	Host   string
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("access to %s is not allowed by sandbox policy (%s)", e.Host, e.Reason)
 }
 
+// verdict is the outcome of checking a connection against the rules
+type verdict int
+
+const (
+	unmatched verdict = iota // No rule allows or denies it
+	allowed
+	denied
+)
+
 // NewDomainFilter creates a new domain filter.
 func NewDomainFilter() *DomainFilter {
 	return &DomainFilter{
-		allowedDomains:  []string{},
-		wildcardDomains: []string{},
+		lookup:   lookupHost,
+		dialer:   net.Dialer{Timeout: 10 * time.Second},
+		denied:   make(map[string]bool),
+		answered: make(map[string]bool),
+		resolved: make(map[netip.Addr]string),
 	}
 }
 
 // AllowAll allows all domains (disables filtering).
+// Deny rules and the link-local/metadata restriction still apply.
 func (f *DomainFilter) AllowAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.allowAll = true
 }
 
-// AddAllowed adds a domain to the allow list.
-// Supports wildcards like *.github.com
-func (f *DomainFilter) AddAllowed(domain string) {
-	domain = strings.ToLower(strings.TrimSpace(domain))
-	if domain == "" {
-		return
-	}
+// AddAllowed adds a rule to the allow list, e.g. "*.github.com" or "10.0.0.0/8:443".
+// Empty rules are ignored.
+func (f *DomainFilter) AddAllowed(spec string) error {
+	return f.addRule(spec, &f.allow)
+}
 
-	if strings.HasPrefix(domain, "*.") {
-		// Wildcard domain - store the suffix
-		f.wildcardDomains = append(f.wildcardDomains, domain[1:]) // Store ".github.com"
-	} else {
-		f.allowedDomains = append(f.allowedDomains, domain)
+// AddDenied adds a rule to the deny list. Empty rules are ignored.
+func (f *DomainFilter) AddDenied(spec string) error {
+	return f.addRule(spec, &f.deny)
+}
+
+func (f *DomainFilter) addRule(spec string, list *[]rule) error {
+	r, err := parseRule(spec)
+	if err != nil {
+		if strings.TrimSpace(spec) == "" {
+			return nil
+		}
+		return err
 	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*list = append(*list, r)
+	return nil
 }
 
-// IsAllowed checks if a domain is allowed.
+// IsAllowed reports whether the rules allow a host, given as host or host:port,
+// without resolving it or prompting.
 func (f *DomainFilter) IsAllowed(host string) bool {
-	// Remove port if present
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		host = host[:idx]
+	name, port := splitHostPort(host)
+	v, _ := f.evaluate(name, port, literalAddr(name))
+	return v == allowed
+}
+
+// SetPrompter makes the filter ask p about hosts the rules don't cover.
+func (f *DomainFilter) SetPrompter(p Prompter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompter = p
+}
+
+// Check reports whether a host, given as host or host:port, may be reached,
+// without resolving it. Hosts the rules don't cover are put to the prompter,
+// if one is set.
+func (f *DomainFilter) Check(host string) bool {
+	name, port := splitHostPort(host)
+	return f.authorize(name, port, literalAddr(name)) == nil
+}
+
+// Dial connects to address (host:port) if the rules allow it. Host names are
+// resolved and every address checked, then the connection is made to a
+// checked address so DNS cannot change the answer in between.
+func (f *DomainFilter) Dial(ctx context.Context, address string) (net.Conn, error) {
+	name, port := splitHostPort(address)
+	if port == 0 {
+		return nil, fmt.Errorf("missing port in address '%s'", address)
 	}
-	host = strings.ToLower(host)
 
-	// Allow all mode
-	if f.allowAll {
-		return true
+	addrs := literalAddr(name)
+	if addrs == nil {
+		var err error
+		if addrs, err = f.resolve(ctx, name, port); err != nil {
+			return nil, err
+		}
 	}
 
-	// Check exact matches
-	for _, allowed := range f.allowedDomains {
-		if host == allowed {
-			return true
+	if err := f.authorize(name, port, addrs); err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := f.dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(addr, uint16(port)).String())
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Resolve looks up a host name for the script's DNS queries. Names the rules
+// can't allow on any port are never looked up, giving a BlockedError, and
+// addresses the rules block are left out of the answer. Connections to the
+// addresses returned are still checked as usual; those with nothing else to
+// go on are checked against the name they were resolved for.
+func (f *DomainFilter) Resolve(ctx context.Context, name string) ([]netip.Addr, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	addrs, err := f.resolve(ctx, name, anyPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowedAddrs []netip.Addr
+	for _, addr := range addrs {
+		if v, _ := f.evaluate(name, anyPort, []netip.Addr{addr}); v == allowed {
+			allowedAddrs = append(allowedAddrs, addr)
+		}
+	}
+	if allowedAddrs == nil {
+		// Report why, or put a name only IP rules could allow to the prompter
+		if err := f.authorize(name, anyPort, addrs); err != nil {
+			return nil, err
+		}
+		allowedAddrs = addrs
+	}
+
+	f.mu.Lock()
+	for _, addr := range allowedAddrs {
+		f.resolved[addr] = name
+	}
+	f.mu.Unlock()
+	return allowedAddrs, nil
+}
+
+// resolvedName returns the name Resolve last answered with addr, or "" if none
+func (f *DomainFilter) resolvedName(addr netip.Addr) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.resolved[addr]
+}
+
+// resolve looks up name for a connection on port, once the rules could allow it
+func (f *DomainFilter) resolve(ctx context.Context, name string, port int) ([]netip.Addr, error) {
+	// Settle the name before looking it up, as lookups of hosts that can
+	// only be allowed by name would leak data through DNS
+	if f.hasIPAllowRules() {
+		if v, reason := f.evaluate(name, port, nil); v == denied {
+			return nil, &BlockedError{Host: name, Reason: reason}
+		}
+	} else if err := f.authorize(name, port, nil); err != nil {
+		return nil, err
+	}
+
+	addrs, err := f.lookup(ctx, name)
+	if err != nil {
+		// Only report lookup failures for names the rules allow
+		if v, reason := f.evaluate(name, port, nil); v != allowed {
+			return nil, &BlockedError{Host: name, Reason: reason}
+		}
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// authorize checks a connection against the rules, prompting for hosts they
+// don't cover. Prompt answers hold for the rest of the run, and requests wait
+// while a prompt is open.
+func (f *DomainFilter) authorize(name string, port int, addrs []netip.Addr) error {
+	v, reason := f.evaluate(name, port, addrs)
+	if v != unmatched {
+		if v == denied {
+			f.explain(name, reason)
+		}
+		return f.result(v, name, reason)
+	}
+
+	f.mu.RLock()
+	prompter := f.prompter
+	f.mu.RUnlock()
+	if prompter == nil {
+		return f.result(v, name, reason)
+	}
+
+	f.promptMu.Lock()
+	defer f.promptMu.Unlock()
+
+	// The host may have been answered while this request waited
+	if v, reason = f.evaluate(name, port, addrs); v != unmatched || f.denied[name] {
+		return f.result(v, name, reason)
+	}
+
+	answer := prompter.Ask(name)
+	if answer == AnswerNo {
+		f.denied[name] = true
+		return &BlockedError{Host: name, Reason: "refused at prompt"}
+	}
+	f.mu.Lock()
+	f.answered[name] = true
+	if answer == AnswerAlways {
+		f.always = append(f.always, name)
+	}
+	f.mu.Unlock()
+	_ = f.AddAllowed(name)
+
+	v, reason = f.evaluate(name, port, addrs)
+	if v == denied {
+		f.explain(name, reason)
+	}
+	return f.result(v, name, reason)
+}
+
+// explain tells the user when a host they allowed at a prompt is blocked
+// anyway, as when it resolves to an internal address, and drops it from the
+// hosts to remember in the script
+func (f *DomainFilter) explain(name, reason string) {
+	f.mu.Lock()
+	answered, prompter := f.answered[name], f.prompter
+	delete(f.answered, name)
+	f.always = slices.DeleteFunc(f.always, func(host string) bool { return host == name })
+	f.mu.Unlock()
+
+	if answered && prompter != nil {
+		prompter.Blocked(name, reason)
+	}
+}
+
+func (f *DomainFilter) result(v verdict, name, reason string) error {
+	if v == allowed {
+		return nil
+	}
+	return &BlockedError{Host: name, Reason: reason}
+}
+
+// evaluate checks a connection to name:port against the rules. addrs are the
+// addresses name resolves to; with none, only the name is checked.
+func (f *DomainFilter) evaluate(name string, port int, addrs []netip.Addr) (verdict, string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, r := range f.deny {
+		// A rule denying some ports leaves the host reachable on the others
+		if port == anyPort && len(r.ports) > 0 {
+			continue
+		}
+		if !r.isIP() && r.matchesName(name, port) {
+			return denied, "matches a deny rule"
+		}
+		for _, addr := range addrs {
+			if r.matchesIP(addr, port) {
+				return denied, fmt.Sprintf("%s matches a deny rule", addr)
+			}
+		}
+	}
+
+	// Allowed by a name rule, and whether that rule vouches for internal addresses
+	byName, internalOK := f.allowAll, f.allowAll
+	for _, r := range f.allow {
+		if !r.isIP() && r.matchesName(name, port) {
+			byName = true
+			internalOK = internalOK || r.any || r.host == "localhost"
+		}
+	}
+
+	if len(addrs) == 0 {
+		if byName {
+			return allowed, ""
 		}
+		return unmatched, "not in the allow list"
 	}
 
-	// Check wildcard matches
-	for _, suffix := range f.wildcardDomains {
-		if strings.HasSuffix(host, suffix) {
+	for _, addr := range addrs {
+		if f.allowsIP(addr, port) {
+			continue
+		}
+		if isRestricted(addr) {
+			return denied, fmt.Sprintf("%s is a link-local or cloud metadata address", addr)
+		}
+		if !byName {
+			return unmatched, "not in the allow list"
+		}
+		if isInternal(addr) && !internalOK {
+			return denied, fmt.Sprintf("resolves to internal address %s; allow it with an IP rule", addr)
+		}
+	}
+	return allowed, ""
+}
+
+// allowsIP reports whether an IP allow rule matches addr:port. Callers hold f.mu.
+func (f *DomainFilter) allowsIP(addr netip.Addr, port int) bool {
+	for _, r := range f.allow {
+		if r.matchesIP(addr, port) {
 			return true
 		}
 	}
+	return false
+}
 
+func (f *DomainFilter) hasIPAllowRules() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, r := range f.allow {
+		if r.isIP() {
+			return true
+		}
+	}
 	return false
 }
+
+// AlwaysAllowed returns the hosts allowed "always" at a prompt, in the order
+// they were allowed.
+func (f *DomainFilter) AlwaysAllowed() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]string(nil), f.always...)
+}
+
+// literalAddr returns the address of an IP literal host, or nil for names
+func literalAddr(name string) []netip.Addr {
+	addr, err := netip.ParseAddr(name)
+	if err != nil {
+		return nil
+	}
+	return []netip.Addr{addr.Unmap()}
+}
+
+// lookupHost resolves a host name with the system resolver
+func lookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
@@ -1,6 +1,18 @@
 package proxy
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
 
 // createFilter is a helper to create a filter with allowed domains
 func createFilter(allowed []string) *DomainFilter {
@@ -152,3 +164,391 @@ func TestDomainFilter_AddAllowed(t *testing.T) {
 		}
 	})
 }
+
+// stubPrompter answers every prompt the same way and counts them
+type stubPrompter struct {
+	answer  Answer
+	asked   []string
+	blocked []string
+}
+
+func (p *stubPrompter) Ask(host string) Answer {
+	p.asked = append(p.asked, host)
+	return p.answer
+}
+
+func (p *stubPrompter) Blocked(host, reason string) {
+	p.blocked = append(p.blocked, host+": "+reason)
+}
+
+func TestDomainFilter_Check(t *testing.T) {
+	t.Run("without a prompter behaves like IsAllowed", func(t *testing.T) {
+		f := createFilter([]string{"api.github.com"})
+		if !f.Check("api.github.com:443") {
+			t.Error("expected allowed host to pass")
+		}
+		if f.Check("evil.com:443") {
+			t.Error("expected other host to be blocked")
+		}
+	})
+
+	t.Run("allowed hosts are not prompted", func(t *testing.T) {
+		f := createFilter([]string{"api.github.com"})
+		p := &stubPrompter{answer: AnswerNo}
+		f.SetPrompter(p)
+
+		if !f.Check("api.github.com") {
+			t.Error("expected allowed host to pass")
+		}
+		if len(p.asked) != 0 {
+			t.Errorf("prompted for %v", p.asked)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		answer     Answer
+		wantAllow  bool
+		wantAlways []string
+	}{
+		{"no blocks the host", AnswerNo, false, nil},
+		{"yes allows the host", AnswerYes, true, nil},
+		{"always allows and records the host", AnswerAlways, true, []string{"api.foo.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := createFilter([]string{"api.github.com"})
+			p := &stubPrompter{answer: tt.answer}
+			f.SetPrompter(p)
+
+			// Asked once, then the answer holds for the run
+			for i := 0; i < 3; i++ {
+				if got := f.Check("API.foo.com:443"); got != tt.wantAllow {
+					t.Errorf("Check = %v, want %v", got, tt.wantAllow)
+				}
+			}
+			if !reflect.DeepEqual(p.asked, []string{"api.foo.com"}) {
+				t.Errorf("asked = %v, want one prompt for api.foo.com", p.asked)
+			}
+			if got := f.AlwaysAllowed(); !reflect.DeepEqual(got, tt.wantAlways) {
+				t.Errorf("AlwaysAllowed = %v, want %v", got, tt.wantAlways)
+			}
+		})
+	}
+}
+
+func TestDomainFilter_Dial_explains_hosts_allowed_at_a_prompt_but_blocked(t *testing.T) {
+	f := createFilter([]string{"api.github.com"})
+	f.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("10.0.0.5")}, nil
+	}
+	p := &stubPrompter{answer: AnswerAlways}
+	f.SetPrompter(p)
+
+	for i := 0; i < 2; i++ {
+		var blocked *BlockedError
+		if _, err := f.Dial(context.Background(), "intranet.example:443"); !errors.As(err, &blocked) {
+			t.Fatalf("Dial() error = %v, want BlockedError", err)
+		}
+	}
+
+	want := []string{"intranet.example: resolves to internal address 10.0.0.5; allow it with an IP rule"}
+	if !reflect.DeepEqual(p.blocked, want) {
+		t.Errorf("blocked = %v, want %v once", p.blocked, want)
+	}
+	if got := f.AlwaysAllowed(); len(got) != 0 {
+		t.Errorf("AlwaysAllowed = %v, want the blocked host left out", got)
+	}
+}
+
+func TestDomainFilter_Check_prompts_once_for_concurrent_requests(t *testing.T) {
+	f := createFilter([]string{"api.github.com"})
+	p := &stubPrompter{answer: AnswerYes}
+	f.SetPrompter(p)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !f.Check("api.foo.com:443") {
+				t.Error("expected host to be allowed")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(p.asked) != 1 {
+		t.Errorf("prompted %d times, want 1", len(p.asked))
+	}
+}
+
+func TestTerminalPrompter_Ask(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Answer
+	}{
+		{"y\n", AnswerYes},
+		{"YES\n", AnswerYes},
+		{"always\n", AnswerAlways},
+		{"a\n", AnswerAlways},
+		{"\n", AnswerNo},
+		{"n\n", AnswerNo},
+		{"maybe\n", AnswerNo},
+		{"", AnswerNo},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.input), func(t *testing.T) {
+			var out bytes.Buffer
+			p := NewTerminalPrompter(strings.NewReader(tt.input), &out)
+
+			if got := p.Ask("api.foo.com"); got != tt.want {
+				t.Errorf("Ask = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(out.String(), "allow api.foo.com? [y/N/always]") {
+				t.Errorf("prompt = %q", out.String())
+			}
+		})
+	}
+}
+
+// newRuleFilter creates a filter from allow and deny rules, resolving names
+// from a fixed table
+func newRuleFilter(t *testing.T, allow, deny []string, hosts map[string][]string) *DomainFilter {
+	t.Helper()
+	f := NewDomainFilter()
+	for _, spec := range allow {
+		if err := f.AddAllowed(spec); err != nil {
+			t.Fatalf("AddAllowed(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range deny {
+		if err := f.AddDenied(spec); err != nil {
+			t.Fatalf("AddDenied(%q): %v", spec, err)
+		}
+	}
+	f.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		ips, ok := hosts[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		addrs := make([]netip.Addr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = netip.MustParseAddr(ip)
+		}
+		return addrs, nil
+	}
+	return f
+}
+
+func TestDomainFilter_rules(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		host  string
+		want  bool
+	}{
+		{"deny wins over allow", []string{"*.example.com"}, []string{"admin.example.com"}, "admin.example.com:443", false},
+		{"deny leaves other hosts", []string{"*.example.com"}, []string{"admin.example.com"}, "api.example.com:443", true},
+		{"port allowed", []string{"api.example.com:443"}, nil, "api.example.com:443", true},
+		{"port not allowed", []string{"api.example.com:443"}, nil, "api.example.com:22", false},
+		{"port range", []string{"localhost:8000-8999"}, nil, "localhost:8080", true},
+		{"CIDR allows address", []string{"10.0.0.0/8"}, nil, "10.1.2.3:80", true},
+		{"CIDR excludes address", []string{"10.0.0.0/8"}, nil, "11.1.2.3:80", false},
+		{"deny port on allowed host", []string{"api.example.com"}, []string{"api.example.com:80"}, "api.example.com:80", false},
+		{"deny CIDR on IP literal", []string{"*"}, []string{"10.0.0.0/8"}, "10.1.2.3:80", false},
+		{"IPv6 CIDR", []string{"fd00::/8"}, nil, "[fd00::1]:443", true},
+		{"metadata address blocked despite *", []string{"*"}, nil, "169.254.169.254:80", false},
+		{"metadata address allowed by IP rule", []string{"169.254.169.254"}, nil, "169.254.169.254:80", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRuleFilter(t, tt.allow, tt.deny, nil)
+			if got := f.Check(tt.host); got != tt.want {
+				t.Errorf("Check(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainFilter_AllowAll_blocks_metadata_address(t *testing.T) {
+	f := NewDomainFilter()
+	f.AllowAll()
+
+	if !f.IsAllowed("10.0.0.1:80") {
+		t.Error("expected private address to be allowed in allow-all mode")
+	}
+	if f.IsAllowed("169.254.169.254:80") {
+		t.Error("expected metadata address to be blocked in allow-all mode")
+	}
+	if f.IsAllowed("[::ffff:169.254.169.254]:80") {
+		t.Error("expected IPv4-mapped metadata address to be blocked")
+	}
+}
+
+func TestDomainFilter_Dial_checks_resolved_addresses(t *testing.T) {
+	hosts := map[string][]string{
+		"api.example.com":    {"93.184.216.34"},
+		"rebind.example.com": {"93.184.216.34", "10.0.0.5"},
+		"meta.example.com":   {"169.254.169.254"},
+		"db.corp.example":    {"10.0.0.7"},
+		"localhost":          {"127.0.0.1"},
+	}
+
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		address string
+		reason  string // Expected in the BlockedError, "" when allowed
+	}{
+		{"public address", []string{"*.example.com"}, nil, "api.example.com:443", ""},
+		{"name resolving to internal address", []string{"*.example.com"}, nil, "rebind.example.com:443", "internal address 10.0.0.5"},
+		{"name resolving to metadata address", []string{"*.example.com"}, nil, "meta.example.com:80", "metadata"},
+		{"metadata address with allow-all rule", []string{"*"}, nil, "meta.example.com:80", "metadata"},
+		{"internal address allowed by CIDR", []string{"*.example.com", "10.0.0.0/8"}, nil, "rebind.example.com:443", ""},
+		{"name allowed only by CIDR", []string{"10.0.0.0/8"}, nil, "db.corp.example:5432", ""},
+		{"CIDR port mismatch", []string{"10.0.0.0/8:443"}, nil, "db.corp.example:5432", "not in the allow list"},
+		{"deny CIDR after resolution", []string{"*.example.com"}, []string{"93.184.216.0/24"}, "api.example.com:443", "matches a deny rule"},
+		{"localhost rule reaches loopback", []string{"localhost"}, nil, "localhost:3000", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRuleFilter(t, tt.allow, tt.deny, hosts)
+			err := checkDial(f, tt.address)
+
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("expected allowed, got %v", err)
+				}
+				return
+			}
+			var blocked *BlockedError
+			if !errors.As(err, &blocked) {
+				t.Fatalf("expected BlockedError, got %v", err)
+			}
+			if !strings.Contains(blocked.Reason, tt.reason) {
+				t.Errorf("reason = %q, want it to mention %q", blocked.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+// checkDial runs Dial's checks without connecting: the context is already
+// cancelled, so a dial the rules permit fails with context.Canceled
+func checkDial(f *DomainFilter, address string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // Any dial fails immediately with context.Canceled
+
+	_, err := f.Dial(ctx, address)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func TestDomainFilter_Dial_does_not_resolve_blocked_names(t *testing.T) {
+	f := newRuleFilter(t, []string{"api.example.com"}, nil, nil)
+	looked := false
+	f.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		looked = true
+		return nil, fmt.Errorf("unexpected lookup of %s", host)
+	}
+
+	_, err := f.Dial(context.Background(), "secret-data.attacker.example:443")
+
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("expected BlockedError, got %v", err)
+	}
+	if looked {
+		t.Error("blocked name was looked up")
+	}
+}
+
+func TestDomainFilter_Dial_connects_to_checked_address(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			_ = conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	f := newRuleFilter(t, []string{"service.test", "127.0.0.1"}, nil, map[string][]string{
+		"service.test": {"127.0.0.1"},
+	})
+
+	conn, err := f.Dial(context.Background(), "service.test:"+port)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if got := conn.RemoteAddr().String(); got != listener.Addr().String() {
+		t.Errorf("connected to %s, want %s", got, listener.Addr())
+	}
+}
+
+func TestDomainFilter_Resolve(t *testing.T) {
+	hosts := map[string][]string{
+		"api.example.com":    {"93.184.216.34", "2606:2800:220:1::1"},
+		"rebind.example.com": {"93.184.216.34", "10.0.0.5"},
+		"db.corp.example":    {"10.0.0.7"},
+	}
+
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		host  string
+		want  []string // nil when blocked
+	}{
+		{"allowed name", []string{"*.example.com"}, nil, "api.example.com", []string{"93.184.216.34", "2606:2800:220:1::1"}},
+		{"query form of the name", []string{"api.example.com"}, nil, "API.Example.com.", []string{"93.184.216.34", "2606:2800:220:1::1"}},
+		{"name allowed on a port", []string{"api.example.com:443"}, nil, "api.example.com", []string{"93.184.216.34", "2606:2800:220:1::1"}},
+		{"name denied on a port", []string{"*.example.com"}, []string{"api.example.com:80"}, "api.example.com", []string{"93.184.216.34", "2606:2800:220:1::1"}},
+		{"denied name", []string{"*.example.com"}, []string{"api.example.com"}, "api.example.com", nil},
+		{"name outside the rules", []string{"*.example.com"}, nil, "secret-data.attacker.example", nil},
+		{"internal address left out", []string{"*.example.com"}, nil, "rebind.example.com", []string{"93.184.216.34"}},
+		{"name allowed only by CIDR", []string{"10.0.0.0/8"}, nil, "db.corp.example", []string{"10.0.0.7"}},
+		{"name resolving outside the CIDR", []string{"10.0.0.0/8"}, nil, "api.example.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRuleFilter(t, tt.allow, tt.deny, hosts)
+			addrs, err := f.Resolve(context.Background(), tt.host)
+
+			if tt.want == nil {
+				var blocked *BlockedError
+				if !errors.As(err, &blocked) {
+					t.Errorf("expected BlockedError, got %v, %v", addrs, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			var got []string
+			for _, addr := range addrs {
+				got = append(got, addr.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Resolve(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+			if name := f.resolvedName(addrs[0]); name != strings.ToLower(strings.TrimSuffix(tt.host, ".")) {
+				t.Errorf("resolvedName(%s) = %q", addrs[0], name)
+			}
+		})
+	}
+}
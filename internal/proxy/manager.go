@@ -7,21 +7,27 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 )
 
 // Manager coordinates HTTP and SOCKS5 proxy servers for sandboxed execution.
 type Manager struct {
+	filter      *DomainFilter
 	httpProxy   *HTTPProxy
 	socks5Proxy *SOCKS5Proxy
 	socketProxy *HTTPProxy
 	socketPath  string
 	verbose     bool
+	stopOnce    sync.Once
 }
 
 // ManagerConfig holds configuration for the proxy manager.
 type ManagerConfig struct {
-	AllowedHosts []string
+	AllowedHosts []string  // Allow rules (empty = allow all but DeniedHosts)
+	DeniedHosts  []string  // Deny rules, which win over allow rules
+	Prompter     Prompter  // Asks about hosts outside AllowedHosts (nil = block them)
+	AuditLog     *AuditLog // Records every connection attempt (nil = no auditing)
 	Verbose      bool
 }
 
@@ -32,13 +38,24 @@ func NewManager(cfg ManagerConfig) (*Manager, error) {
 
 	// Create filter
 	filter := NewDomainFilter()
-	if len(cfg.AllowedHosts) == 0 {
+	if len(cfg.AllowedHosts) == 0 && cfg.Prompter == nil {
 		filter.AllowAll()
 	} else {
 		for _, host := range cfg.AllowedHosts {
-			filter.AddAllowed(host)
+			if err := filter.AddAllowed(host); err != nil {
+				return nil, fmt.Errorf("invalid allow-host rule: %w", err)
+			}
+		}
+	}
+	for _, host := range cfg.DeniedHosts {
+		if err := filter.AddDenied(host); err != nil {
+			return nil, fmt.Errorf("invalid deny-host rule: %w", err)
 		}
 	}
+	if cfg.Prompter != nil {
+		filter.SetPrompter(cfg.Prompter)
+	}
+	m.filter = filter
 
 	// Start HTTP proxy
 	httpProxy, err := NewHTTPProxy(filter)
@@ -46,6 +63,7 @@ func NewManager(cfg ManagerConfig) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create HTTP proxy: %w", err)
 	}
 	m.httpProxy = httpProxy
+	m.httpProxy.SetAuditLog(cfg.AuditLog)
 	if err := m.httpProxy.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start HTTP proxy: %w", err)
 	}
@@ -59,6 +77,7 @@ func NewManager(cfg ManagerConfig) (*Manager, error) {
 		}
 	} else {
 		m.socks5Proxy = socks5Proxy
+		m.socks5Proxy.SetAuditLog(cfg.AuditLog)
 		if err := m.socks5Proxy.Start(); err != nil {
 			// Warn but continue - SOCKS5 is optional
 			if cfg.Verbose {
@@ -76,6 +95,7 @@ func NewManager(cfg ManagerConfig) (*Manager, error) {
 		_ = os.Remove(socketPath)
 
 		socketProxy := NewHTTPProxyWithListener(nil, filter)
+		socketProxy.SetAuditLog(cfg.AuditLog)
 		if err := socketProxy.StartUnix(socketPath); err != nil {
 			if cfg.Verbose {
 				fmt.Fprintf(os.Stderr, "[buns] Warning: Could not start Unix socket proxy: %v\n", err)
@@ -89,20 +109,22 @@ func NewManager(cfg ManagerConfig) (*Manager, error) {
 	return m, nil
 }
 
-// Stop shuts down all proxy servers.
+// Stop shuts down all proxy servers. Calls after the first do nothing.
 func (m *Manager) Stop() {
-	if m.socketProxy != nil {
-		_ = m.socketProxy.Stop()
-		if m.socketPath != "" {
-			_ = os.Remove(m.socketPath)
+	m.stopOnce.Do(func() {
+		if m.socketProxy != nil {
+			_ = m.socketProxy.Stop()
+			if m.socketPath != "" {
+				_ = os.Remove(m.socketPath)
+			}
 		}
-	}
-	if m.socks5Proxy != nil {
-		_ = m.socks5Proxy.Stop()
-	}
-	if m.httpProxy != nil {
-		_ = m.httpProxy.Stop()
-	}
+		if m.socks5Proxy != nil {
+			_ = m.socks5Proxy.Stop()
+		}
+		if m.httpProxy != nil {
+			_ = m.httpProxy.Stop()
+		}
+	})
 }
 
 // Port returns the HTTP proxy port.
@@ -121,6 +143,11 @@ func (m *Manager) SOCKS5Port() int {
 	return 0
 }
 
+// AlwaysAllowed returns the hosts allowed "always" at a prompt.
+func (m *Manager) AlwaysAllowed() []string {
+	return m.filter.AlwaysAllowed()
+}
+
 // SocketPath returns the Unix socket path (Linux only).
 func (m *Manager) SocketPath() string {
 	return m.socketPath
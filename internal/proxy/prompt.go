@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Answer is a response to a permission prompt
+type Answer int
+
+const (
+	AnswerNo     Answer = iota // Block the host for the rest of the run
+	AnswerYes                  // Allow the host for the rest of the run
+	AnswerAlways               // Allow the host and remember it in the script
+)
+
+// Prompter asks whether a host outside the allow list may be reached
+type Prompter interface {
+	Ask(host string) Answer
+
+	// Blocked tells the user a host they allowed is blocked anyway
+	Blocked(host, reason string)
+}
+
+// TerminalPrompter asks on a terminal, typically /dev/tty so that prompts
+// work while the script owns stdin and stdout.
+type TerminalPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewTerminalPrompter creates a prompter reading answers from in and writing
+// questions to out.
+func NewTerminalPrompter(in io.Reader, out io.Writer) *TerminalPrompter {
+	return &TerminalPrompter{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// Ask prompts for host. Anything other than y/yes or a/always is a no.
+func (p *TerminalPrompter) Ask(host string) Answer {
+	_, _ = fmt.Fprintf(p.out, "[buns] allow %s? [y/N/always] ", host)
+
+	line, err := p.in.ReadString('\n')
+	if err != nil {
+		// No answer (e.g. the terminal closed) - end the prompt line
+		_, _ = fmt.Fprintln(p.out)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return AnswerYes
+	case "a", "always":
+		return AnswerAlways
+	}
+	return AnswerNo
+}
+
+// Blocked reports that host is blocked despite being allowed at a prompt
+func (p *TerminalPrompter) Blocked(host, reason string) {
+	_, _ = fmt.Fprintf(p.out, "[buns] %s is still blocked: %s\n", host, reason)
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,9 +18,12 @@ import (
 type HTTPProxy struct {
 	listener net.Listener
 	server   *http.Server
+	client   *http.Client
 	filter   *DomainFilter
+	audit    *AuditLog
 	addr     string
 	wg       sync.WaitGroup
+	tunnels  sync.WaitGroup // CONNECT tunnels, which outlive their handlers
 }
 
 // NewHTTPProxy creates a new HTTP proxy server with domain filtering.
@@ -49,9 +53,29 @@ func NewHTTPProxyWithListener(listener net.Listener, filter *DomainFilter) *HTTP
 		Handler: http.HandlerFunc(p.handleRequest),
 	}
 
+	// Every upstream connection goes through the filter, which checks the
+	// resolved addresses before dialing them
+	p.client = &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return filter.Dial(ctx, addr)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Don't follow redirects, let the client handle them
+			return http.ErrUseLastResponse
+		},
+	}
+
 	return p
 }
 
+// SetAuditLog records every connection attempt to l.
+func (p *HTTPProxy) SetAuditLog(l *AuditLog) {
+	p.audit = l
+}
+
 // Addr returns the proxy's address (host:port).
 func (p *HTTPProxy) Addr() string {
 	return p.addr
@@ -81,6 +105,18 @@ func (p *HTTPProxy) Stop() error {
 
 	err := p.server.Shutdown(ctx)
 	p.wg.Wait()
+
+	// Let open tunnels finish so their audit records are written
+	done := make(chan struct{})
+	go func() {
+		p.tunnels.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
 	return err
 }
 
@@ -108,32 +144,38 @@ func (p *HTTPProxy) StartUnix(socketPath string) error {
 
 // handleRequest routes incoming proxy requests.
 func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodConnect {
+	switch {
+	case r.Method == http.MethodConnect:
 		p.handleConnect(w, r)
-	} else {
+	case r.URL.Host == "" && r.URL.Path == DNSPath:
+		p.handleDNS(w, r)
+	default:
 		p.handleHTTP(w, r)
 	}
 }
 
 // handleConnect handles HTTPS CONNECT requests (tunneling).
 func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
-	host := r.Host
-
-	// Check domain filter
-	if !p.filter.IsAllowed(host) {
-		http.Error(w, fmt.Sprintf("Access to %s is not allowed by sandbox policy", host), http.StatusForbidden)
+	if r.Header.Get(TransparentHeader) != "" {
+		p.handleTransparent(w, r)
 		return
 	}
 
+	host := r.Host
+
 	// Ensure host has a port
-	if !strings.Contains(host, ":") {
-		host = host + ":443"
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "443")
 	}
 
-	// Connect to target with timeout
-	targetConn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	name, port := splitHostPort(host)
+	entry := p.audit.begin(ProtocolConnect, name, port)
+
+	// Connect to target through the filter
+	targetConn, err := p.filter.Dial(r.Context(), host)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		entry.finish(err)
+		writeDialError(w, err)
 		return
 	}
 
@@ -142,6 +184,7 @@ func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
 		_ = targetConn.Close()
+		entry.finish(nil)
 		return
 	}
 
@@ -149,21 +192,39 @@ func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		_ = targetConn.Close()
+		entry.finish(nil)
 		return
 	}
 
 	// Send success response
 	_, _ = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 
-	// Tunnel data bidirectionally
+	p.tunnel(clientConn, clientConn, targetConn, entry)
+}
+
+// tunnel copies data both ways between a client, read from client, and
+// target, recording the tunnel once both directions close
+func (p *HTTPProxy) tunnel(clientConn net.Conn, client io.Reader, targetConn net.Conn, entry *auditEntry) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	p.tunnels.Add(1)
 	go func() {
-		_, _ = io.Copy(targetConn, clientConn)
+		defer wg.Done()
+		n, _ := io.Copy(targetConn, client)
+		entry.sent.Add(n)
 		_ = targetConn.Close()
 	}()
 	go func() {
-		_, _ = io.Copy(clientConn, targetConn)
+		defer wg.Done()
+		n, _ := io.Copy(clientConn, targetConn)
+		entry.received.Add(n)
 		_ = clientConn.Close()
 	}()
+	go func() {
+		defer p.tunnels.Done()
+		wg.Wait()
+		entry.finish(nil)
+	}()
 }
 
 // handleHTTP handles regular HTTP proxy requests.
@@ -173,11 +234,14 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		host = r.URL.Host
 	}
 
-	// Check domain filter
-	if !p.filter.IsAllowed(host) {
-		http.Error(w, fmt.Sprintf("Access to %s is not allowed by sandbox policy", host), http.StatusForbidden)
-		return
+	name, port := splitHostPort(host)
+	if port == 0 {
+		port = 80
+		if r.URL.Scheme == "https" {
+			port = 443
+		}
 	}
+	entry := p.audit.begin(ProtocolHTTP, name, port)
 
 	// Create outgoing request
 	outReq := &http.Request{
@@ -186,24 +250,20 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		Header: r.Header.Clone(),
 		Body:   r.Body,
 	}
+	if r.Body != http.NoBody {
+		outReq.Body = &countingReader{r: r.Body, n: &entry.sent}
+	}
 
 	// Remove hop-by-hop headers
 	outReq.Header.Del("Proxy-Connection")
 	outReq.Header.Del("Proxy-Authenticate")
 	outReq.Header.Del("Proxy-Authorization")
 
-	// Make request with timeout
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Don't follow redirects, let the client handle them
-			return http.ErrUseLastResponse
-		},
-	}
-
-	resp, err := client.Do(outReq)
+	// Make request through the filtering transport
+	resp, err := p.client.Do(outReq.WithContext(r.Context()))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		entry.finish(err)
+		writeDialError(w, err)
 		return
 	}
 	defer func() { _ = resp.Body.Close() }()
@@ -217,5 +277,18 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Write status and body
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	n, err := io.Copy(w, resp.Body)
+	entry.received.Add(n)
+	entry.finish(err)
+}
+
+// writeDialError reports a failed upstream connection: 403 when the sandbox
+// policy blocked it, 502 otherwise
+func writeDialError(w http.ResponseWriter, err error) {
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		http.Error(w, fmt.Sprintf("Access to %s is not allowed by sandbox policy: %s", blocked.Host, blocked.Reason), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
 }
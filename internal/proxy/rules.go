@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// rule is one allow or deny entry of a DomainFilter. It matches a host name,
+// a wildcard suffix or an IP range, optionally restricted to some ports.
+//
+// Accepted forms:
+//
+//	api.github.com          exact host
+//	*.github.com            any subdomain
+//	*                       any host
+//	10.0.0.1, [::1]         IP address
+//	10.0.0.0/8, fd00::/8    CIDR block
+//	api.github.com:443      with a port
+//	api.github.com:80,443   with a port list
+//	localhost:8000-8999     with a port range
+//	[fd00::/8]:443          IPv6 with ports
+type rule struct {
+	host   string       // Exact host name
+	suffix string       // Wildcard suffix, e.g. ".github.com"
+	any    bool         // "*" matches every host
+	prefix netip.Prefix // IP address or CIDR block
+	ports  []portRange  // Empty matches every port
+}
+
+// portRange is an inclusive range of ports
+type portRange struct {
+	lo, hi int
+}
+
+// anyPort stands for the port when checking whether a host may be reached on
+// some port, as for a DNS answer. Rules with ports match it.
+const anyPort = -1
+
+// parseRule parses a rule in one of the forms documented on rule
+func parseRule(s string) (rule, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return rule{}, fmt.Errorf("empty rule")
+	}
+
+	target, ports, err := splitRulePorts(s)
+	if err != nil {
+		return rule{}, err
+	}
+
+	r := rule{}
+	if ports != "" {
+		if r.ports, err = parsePorts(ports); err != nil {
+			return rule{}, fmt.Errorf("invalid ports in '%s': %w", s, err)
+		}
+	}
+
+	switch {
+	case target == "*":
+		r.any = true
+	case strings.HasPrefix(target, "*."):
+		r.suffix = target[1:]
+	case strings.Contains(target, "/"):
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid CIDR block '%s'", target)
+		}
+		r.prefix = prefix.Masked()
+	default:
+		if addr, err := netip.ParseAddr(target); err == nil {
+			addr = addr.Unmap()
+			r.prefix = netip.PrefixFrom(addr, addr.BitLen())
+		} else if strings.ContainsAny(target, "*/[]") {
+			return rule{}, fmt.Errorf("invalid host '%s'", target)
+		} else {
+			r.host = target
+		}
+	}
+
+	return r, nil
+}
+
+// splitRulePorts separates the target of a rule from its port list
+func splitRulePorts(s string) (target, ports string, err error) {
+	// Bracketed IPv6 address or block, e.g. [::1]:8080
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return "", "", fmt.Errorf("missing ']' in '%s'", s)
+		}
+		rest := s[end+1:]
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("unexpected '%s' after ']' in '%s'", rest, s)
+		}
+		return s[1:end], strings.TrimPrefix(rest, ":"), nil
+	}
+
+	// Unbracketed IPv6 addresses and blocks have no ports
+	if strings.Count(s, ":") > 1 {
+		return s, "", nil
+	}
+
+	if idx := strings.LastIndex(s, ":"); idx != -1 {
+		return s[:idx], s[idx+1:], nil
+	}
+	return s, "", nil
+}
+
+// parsePorts parses a comma-separated list of ports and ranges, e.g. "80,443,8000-8999"
+func parsePorts(s string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			hi = lo
+		}
+		l, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		h, err := parsePort(hi)
+		if err != nil {
+			return nil, err
+		}
+		if l > h {
+			return nil, fmt.Errorf("range %d-%d is reversed", l, h)
+		}
+		ranges = append(ranges, portRange{l, h})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("'%s' is not a port", s)
+	}
+	return port, nil
+}
+
+// isIP reports whether the rule matches addresses rather than names
+func (r rule) isIP() bool {
+	return r.prefix.IsValid()
+}
+
+// matchesName reports whether the rule matches a host name and port.
+// IP rules match names that are IP literals.
+func (r rule) matchesName(name string, port int) bool {
+	if !r.matchesPort(port) {
+		return false
+	}
+
+	switch {
+	case r.any:
+		return true
+	case r.suffix != "":
+		return strings.HasSuffix(name, r.suffix)
+	case r.isIP():
+		addr, err := netip.ParseAddr(name)
+		return err == nil && r.prefix.Contains(addr.Unmap())
+	}
+	return name == r.host
+}
+
+// matchesIP reports whether an IP rule matches an address and port
+func (r rule) matchesIP(addr netip.Addr, port int) bool {
+	return r.isIP() && r.matchesPort(port) && r.prefix.Contains(addr)
+}
+
+func (r rule) matchesPort(port int) bool {
+	if len(r.ports) == 0 || port == anyPort {
+		return true
+	}
+	for _, pr := range r.ports {
+		if port >= pr.lo && port <= pr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitRules splits a comma-separated list of rules. Port lists keep their
+// commas: "api.github.com:80,443,example.com" is two rules.
+func SplitRules(list string) []string {
+	var rules []string
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if len(rules) > 0 && isPortSpec(part) && hasPorts(rules[len(rules)-1]) {
+			rules[len(rules)-1] += "," + part
+			continue
+		}
+		rules = append(rules, part)
+	}
+	return rules
+}
+
+// isPortSpec reports whether s is a bare port or port range
+func isPortSpec(s string) bool {
+	lo, hi, _ := strings.Cut(s, "-")
+	if _, err := strconv.Atoi(lo); err != nil {
+		return false
+	}
+	if hi != "" {
+		if _, err := strconv.Atoi(hi); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPorts reports whether a rule ends in a port list
+func hasPorts(s string) bool {
+	_, ports, err := splitRulePorts(strings.ToLower(s))
+	return err == nil && ports != ""
+}
+
+// Addresses that must not be reached unless an IP rule explicitly allows them:
+// link-local ranges (which include 169.254.169.254) and cloud metadata services
+var restrictedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fd00:ec2::254/128"),  // AWS (IPv6)
+	netip.MustParsePrefix("100.100.100.200/32"), // Alibaba Cloud
+	netip.MustParsePrefix("168.63.129.16/32"),   // Azure
+}
+
+// cgnatPrefix is the shared address space of RFC 6598
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// isRestricted reports whether addr is a link-local or metadata address
+func isRestricted(addr netip.Addr) bool {
+	for _, p := range restrictedPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternal reports whether addr belongs to a private, loopback or otherwise
+// non-public network
+func isInternal(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsUnspecified() || cgnatPrefix.Contains(addr)
+}
+
+// splitHostPort splits host:port leniently: the port is optional (0 when
+// missing), and bare IPv6 addresses are accepted. The host is lowercased and
+// unbracketed.
+func splitHostPort(hostport string) (string, int) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		p, _ := strconv.Atoi(port)
+		return strings.ToLower(host), p
+	}
+	return strings.ToLower(strings.Trim(hostport, "[]")), 0
+}
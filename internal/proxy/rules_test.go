@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    rule
+		wantErr bool
+	}{
+		{spec: "API.GitHub.com", want: rule{host: "api.github.com"}},
+		{spec: "*.github.com", want: rule{suffix: ".github.com"}},
+		{spec: "*", want: rule{any: true}},
+		{spec: "*:443", want: rule{any: true, ports: []portRange{{443, 443}}}},
+		{spec: "10.0.0.1", want: rule{prefix: netip.MustParsePrefix("10.0.0.1/32")}},
+		{spec: "10.1.2.3/8", want: rule{prefix: netip.MustParsePrefix("10.0.0.0/8")}},
+		{spec: "::1", want: rule{prefix: netip.MustParsePrefix("::1/128")}},
+		{spec: "[::1]", want: rule{prefix: netip.MustParsePrefix("::1/128")}},
+		{spec: "fd00::/8", want: rule{prefix: netip.MustParsePrefix("fd00::/8")}},
+		{spec: "[fd00::/8]:443", want: rule{prefix: netip.MustParsePrefix("fd00::/8"), ports: []portRange{{443, 443}}}},
+		{spec: "api.github.com:443", want: rule{host: "api.github.com", ports: []portRange{{443, 443}}}},
+		{spec: "api.github.com:80,443", want: rule{host: "api.github.com", ports: []portRange{{80, 80}, {443, 443}}}},
+		{spec: "localhost:8000-8999", want: rule{host: "localhost", ports: []portRange{{8000, 8999}}}},
+
+		{spec: "", wantErr: true},
+		{spec: "api.github.com:http", wantErr: true},
+		{spec: "api.github.com:0", wantErr: true},
+		{spec: "api.github.com:70000", wantErr: true},
+		{spec: "api.github.com:9000-8000", wantErr: true},
+		{spec: "10.0.0.0/33", wantErr: true},
+		{spec: "[::1", wantErr: true},
+		{spec: "[::1]x", wantErr: true},
+		{spec: "api.*.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRule(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRule(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRules(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"a.example, b.example", []string{"a.example", "b.example"}},
+		{"a.example:80,443,b.example", []string{"a.example:80,443", "b.example"}},
+		{"a.example:80,8000-8999, b.example:443", []string{"a.example:80,8000-8999", "b.example:443"}},
+		{"[::1]:80,443", []string{"[::1]:80,443"}},
+		{"10.0.0.0/8,443", []string{"10.0.0.0/8", "443"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			if got := SplitRules(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitRules(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package proxy
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -36,6 +39,7 @@ const (
 type SOCKS5Proxy struct {
 	listener net.Listener
 	filter   *DomainFilter
+	audit    *AuditLog
 	addr     string
 	wg       sync.WaitGroup
 	quit     chan struct{}
@@ -56,6 +60,11 @@ func NewSOCKS5Proxy(filter *DomainFilter) (*SOCKS5Proxy, error) {
 	}, nil
 }
 
+// SetAuditLog records every connection attempt to l
+func (p *SOCKS5Proxy) SetAuditLog(l *AuditLog) {
+	p.audit = l
+}
+
 // Addr returns the proxy's address (host:port)
 func (p *SOCKS5Proxy) Addr() string {
 	return p.addr
@@ -162,17 +171,18 @@ func (p *SOCKS5Proxy) handleConnection(conn net.Conn) {
 		return
 	}
 
-	// Check domain filter
-	if !p.filter.IsAllowed(host) {
-		p.sendReply(conn, repNotAllowed, nil)
-		return
-	}
-
-	// Connect to target - use net.JoinHostPort for IPv6 safety
+	// Connect to target through the filter - use net.JoinHostPort for IPv6 safety
 	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
-	targetConn, err := net.Dial("tcp", target)
+	entry := p.audit.begin(ProtocolSOCKS5, strings.ToLower(host), int(port))
+	targetConn, err := p.filter.Dial(context.Background(), target)
 	if err != nil {
-		p.sendReply(conn, repHostUnreach, nil)
+		entry.finish(err)
+		var blocked *BlockedError
+		if errors.As(err, &blocked) {
+			p.sendReply(conn, repNotAllowed, nil)
+		} else {
+			p.sendReply(conn, repHostUnreach, nil)
+		}
 		return
 	}
 	defer func() { _ = targetConn.Close() }()
@@ -185,17 +195,23 @@ func (p *SOCKS5Proxy) handleConnection(conn net.Conn) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	// Closing each destination when its source ends lets the other direction finish
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(targetConn, conn)
+		n, _ := io.Copy(targetConn, conn)
+		entry.sent.Add(n)
+		_ = targetConn.Close()
 	}()
 
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(conn, targetConn)
+		n, _ := io.Copy(conn, targetConn)
+		entry.received.Add(n)
+		_ = conn.Close()
 	}()
 
 	wg.Wait()
+	entry.finish(nil)
 }
 
 func (p *SOCKS5Proxy) readAddress(conn net.Conn, addrType byte) (string, uint16, error) {
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// TransparentHeader marks a CONNECT request for a connection intercepted in
+// the script's network namespace, whose target is the address the script
+// connected to. As the script may not have resolved a name itself, the proxy
+// works out the host from what the script sends first: the server name of a
+// TLS ClientHello or the Host header of an HTTP request. When neither is sent,
+// the host is the name the proxy's DNS answered with the address, if any, and
+// otherwise the address itself is checked against the rules.
+const TransparentHeader = "Buns-Transparent"
+
+// sniffTimeout is how long to wait for the script to send a ClientHello or
+// request, for protocols where the server speaks first
+var sniffTimeout = time.Second
+
+// maxSniff is the most read to find the host: a TLS record with its header
+const maxSniff = 5 + 1<<14
+
+// tlsHandshake is the TLS record type of a ClientHello
+const tlsHandshake = 0x16
+
+// InterceptPorts returns the ports to intercept connections to in the
+// script's network namespace: HTTP, HTTPS and every single port the allow
+// rules name. Port ranges are left out, as each port takes a listener.
+func InterceptPorts(allowRules []string) []int {
+	ports := []int{80, 443}
+	for _, spec := range allowRules {
+		r, err := parseRule(spec)
+		if err != nil {
+			continue
+		}
+		for _, pr := range r.ports {
+			if pr.lo == pr.hi && !slices.Contains(ports, pr.lo) {
+				ports = append(ports, pr.lo)
+			}
+		}
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// handleTransparent handles a CONNECT request with TransparentHeader: it
+// accepts the tunnel at once, then connects to the host the script asks for
+// if the rules allow it, closing the tunnel otherwise
+func (p *HTTPProxy) handleTransparent(w http.ResponseWriter, r *http.Request) {
+	dst, err := netip.ParseAddrPort(r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid address '%s'", r.Host), http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	client := bufio.NewReaderSize(rw.Reader, maxSniff)
+	_ = clientConn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	name := sniffHost(client)
+	_ = clientConn.SetReadDeadline(time.Time{})
+	if name == "" {
+		// The name the script looked up the address for, if it asked buns
+		name = p.filter.resolvedName(dst.Addr().Unmap())
+	}
+	if name == "" {
+		name = dst.Addr().Unmap().String()
+	}
+
+	port := int(dst.Port())
+	entry := p.audit.begin(ProtocolTransparent, name, port)
+	// The request's context ends with the handler, which hijacking detaches
+	// the connection from
+	targetConn, err := p.filter.Dial(context.Background(), net.JoinHostPort(name, strconv.Itoa(port)))
+	if err != nil {
+		entry.finish(err)
+		_ = clientConn.Close()
+		return
+	}
+
+	p.tunnel(clientConn, client, targetConn, entry)
+}
+
+// sniffHost peeks at the start of a connection for the host it is meant for,
+// returning "" when it isn't TLS or HTTP
+func sniffHost(r *bufio.Reader) string {
+	first, err := r.Peek(1)
+	if err != nil {
+		return ""
+	}
+
+	if first[0] == tlsHandshake {
+		header, err := r.Peek(5)
+		if err != nil {
+			return ""
+		}
+		length := 5 + int(binary.BigEndian.Uint16(header[3:]))
+		record, err := r.Peek(min(length, r.Size()))
+		if err != nil {
+			return ""
+		}
+		return serverName(record)
+	}
+
+	// Read up to the end of the request's headers
+	for {
+		head, _ := r.Peek(r.Buffered())
+		if !isRequestStart(head) {
+			return ""
+		}
+		if end := bytes.Index(head, []byte("\r\n\r\n")); end != -1 {
+			return requestHost(head[:end+4])
+		}
+		if len(head) == r.Size() {
+			return ""
+		}
+		if _, err := r.Peek(len(head) + 1); err != nil {
+			return ""
+		}
+	}
+}
+
+// isRequestStart reports whether b could start an HTTP request: a method of
+// capital letters followed by a space
+func isRequestStart(b []byte) bool {
+	for i, c := range b {
+		switch {
+		case c == ' ':
+			return i > 0
+		case c < 'A' || c > 'Z' || i >= 16:
+			return false
+		}
+	}
+	return true
+}
+
+// requestHost returns the host of an HTTP request's headers, without a port
+func requestHost(head []byte) string {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return ""
+	}
+	name, _ := splitHostPort(req.Host)
+	return name
+}
+
+// errSniffed stops a handshake once the ClientHello has been read
+var errSniffed = errors.New("sniffed")
+
+// serverName returns the server name of a TLS record holding a ClientHello,
+// which crypto/tls parses for us
+func serverName(record []byte) string {
+	var name string
+	conn := tls.Server(sniffConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errSniffed
+		},
+	})
+	_ = conn.Handshake()
+	return name
+}
+
+// sniffConn is a connection that reads from r and discards writes, for
+// parsing a ClientHello
+type sniffConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c sniffConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c sniffConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c sniffConn) Close() error                { return nil }
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientHello returns the first TLS record a client sends to serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: serverName == ""}).Handshake()
+	}()
+	defer func() { _ = client.Close() }()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+func TestSniffHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"TLS", clientHello(t, "api.github.com"), "api.github.com"},
+		{"TLS without a server name", clientHello(t, ""), ""},
+		{"truncated TLS", clientHello(t, "api.github.com")[:40], ""},
+		{"HTTP", []byte("GET / HTTP/1.1\r\nHost: Example.com:8080\r\nAccept: */*\r\n\r\n"), "example.com"},
+		{"HTTP without a host", []byte("GET / HTTP/1.0\r\n\r\n"), ""},
+		{"SSH", []byte("SSH-2.0-OpenSSH_9.6\r\n"), ""},
+		{"binary", []byte{0, 0, 0, 8, 4, 210, 22, 47}, ""},
+		{"nothing", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReaderSize(bytes.NewReader(tt.input), maxSniff)
+			if got := sniffHost(r); got != tt.want {
+				t.Errorf("sniffHost() = %q, want %q", got, tt.want)
+			}
+
+			// Sniffing leaves the input to be relayed
+			rest, _ := io.ReadAll(r)
+			if !bytes.Equal(rest, tt.input) {
+				t.Error("sniffHost() consumed input")
+			}
+		})
+	}
+}
+
+func TestInterceptPorts(t *testing.T) {
+	got := InterceptPorts([]string{"api.example.com:8443", "db.internal:5432,6000-6010", "*", "api.*.com:22"})
+	if want := []int{80, 443, 5432, 8443}; !slices.Equal(got, want) {
+		t.Errorf("InterceptPorts() = %v, want %v", got, want)
+	}
+}
+
+func TestHTTPProxy_transparent(t *testing.T) {
+	_, port := newUpstream(t)
+
+	filter := NewDomainFilter()
+	_ = filter.AddAllowed("localhost")
+	_ = filter.AddAllowed("127.0.0.1")
+
+	var buf bytes.Buffer
+	p, err := NewHTTPProxy(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetAuditLog(NewAuditLog(&buf))
+	_ = p.Start()
+
+	defer func(timeout time.Duration) { sniffTimeout = timeout }(sniffTimeout)
+	sniffTimeout = 50 * time.Millisecond
+
+	// intercepted opens a tunnel for a connection the script made to address,
+	// sends request, perhaps after a pause, and returns the response
+	intercepted := func(address, request string, pause time.Duration) string {
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n%s: 1\r\n\r\n", address, address, TransparentHeader)
+		reader := bufio.NewReader(conn)
+		if status, _ := reader.ReadString('\n'); !strings.Contains(status, "200") {
+			t.Fatalf("CONNECT status = %q", status)
+		}
+		_, _ = reader.ReadString('\n') // Blank line ending the response
+
+		time.Sleep(pause)
+		_, _ = io.WriteString(conn, request)
+		response, _ := io.ReadAll(reader)
+		return string(response)
+	}
+
+	// The script resolved a name the proxy doesn't know, but sent its Host
+	request := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost:%d\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", port)
+	if response := intercepted(fmt.Sprintf("198.51.100.7:%d", port), request, 0); !strings.HasSuffix(response, "echo:hi") {
+		t.Errorf("response = %q", response)
+	}
+
+	request = fmt.Sprintf("GET / HTTP/1.1\r\nHost: blocked.invalid:%d\r\nConnection: close\r\n\r\n", port)
+	if response := intercepted(fmt.Sprintf("127.0.0.1:%d", port), request, 0); response != "" {
+		t.Errorf("blocked host responded: %q", response)
+	}
+
+	// Nothing to sniff before the timeout, so the address is checked instead
+	request = "POST / HTTP/1.1\r\nHost: blocked.invalid\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi"
+	if response := intercepted(fmt.Sprintf("127.0.0.1:%d", port), request, 2*sniffTimeout); !strings.HasSuffix(response, "echo:hi") {
+		t.Errorf("response = %q", response)
+	}
+
+	_ = p.Stop()
+
+	records := readAudit(t, &buf)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3: %+v", len(records), records)
+	}
+	for i, want := range []struct {
+		host     string
+		decision string
+	}{
+		{"localhost", DecisionAllowed},
+		{"blocked.invalid", DecisionDenied},
+		{"127.0.0.1", DecisionAllowed},
+	} {
+		r := records[i]
+		if r.Protocol != ProtocolTransparent || r.Host != want.host || r.Port != port || r.Decision != want.decision {
+			t.Errorf("record %d = %+v, want %s %s", i, r, want.host, want.decision)
+		}
+	}
+}
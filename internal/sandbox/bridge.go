@@ -0,0 +1,370 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eddmann/buns/internal/proxy"
+)
+
+// BridgeHelper is the hidden buns command that relays SandboxBridgePort to
+// the proxy's Unix socket inside a network namespace, then runs the script.
+// Connections to any address on the intercepted ports go to the proxy too,
+// for clients that ignore the proxy variables, as do DNS queries.
+const BridgeHelper = "__bridge"
+
+// landlockFlag passes the bridge a Landlock policy to restrict the script to
+const landlockFlag = "--landlock"
+
+// BridgeArgs returns the command line that runs args with the proxy's Unix
+// socket at socketPath reachable on SandboxBridgePort, intercepting ports.
+// self is the buns executable, which must be reachable where the command runs.
+func BridgeArgs(self, socketPath string, ports []int, args []string) []string {
+	list := make([]string, len(ports))
+	for i, port := range ports {
+		list[i] = strconv.Itoa(port)
+	}
+	return append([]string{self, BridgeHelper, socketPath, strings.Join(list, ","), "--"}, args...)
+}
+
+// landlockBridgeArgs returns the BridgeArgs command line that also restricts
+// the command to the encoded Landlock policy
+func landlockBridgeArgs(self, socketPath string, ports []int, policy string, args []string) []string {
+	bridged := BridgeArgs(self, socketPath, ports, args)
+	return slices.Insert(bridged, 4, landlockFlag, policy)
+}
+
+// bunsExecutable returns the real path of the running buns binary, which
+// sandboxes mount to run the bridge
+func bunsExecutable() (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find buns executable: %w", err)
+	}
+	return ResolvePath(self)
+}
+
+// resolvConf replaces /etc/resolv.conf in sandboxes with their own root, so
+// the script's DNS queries go to the bridge
+const resolvConf = "nameserver 127.0.0.1\n"
+
+// writeResolvConf writes resolvConf to a temporary file for mounting into a
+// sandbox. Callers remove it once the script has exited.
+func writeResolvConf() (string, error) {
+	f, err := os.CreateTemp("", "buns-resolv-*.conf")
+	if err != nil {
+		return "", fmt.Errorf("failed to create resolv.conf: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Readable by the unprivileged user nsjail runs the script as
+	if err := f.Chmod(0644); err == nil {
+		_, err = f.WriteString(resolvConf)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write resolv.conf: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// bridgeEnv points the proxy variables in env at the bridge, as the proxy's
+// own ports aren't reachable from the script's network namespace
+func bridgeEnv(env []string) []string {
+	bridged := make([]string, 0, len(env))
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		switch name {
+		case "HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy":
+			continue
+		}
+		bridged = append(bridged, e)
+	}
+	return append(bridged, ProxyEnvVars()...)
+}
+
+// RunBridgeHelper is the BridgeHelper command: it listens on
+// SandboxBridgePort, the comma-separated ports in args[1] and the DNS port,
+// relays connections and queries to the Unix socket in args[0] and runs the
+// command after "--" once the listeners are ready, exiting as the command does. Errors before
+// the command starts are written to file descriptor 3.
+func RunBridgeHelper(args []string) error {
+	var policy *LandlockPolicy
+	if len(args) >= 4 && args[2] == landlockFlag {
+		policy = &LandlockPolicy{}
+		if err := json.Unmarshal([]byte(args[3]), policy); err != nil {
+			err = fmt.Errorf("invalid Landlock policy: %w", err)
+			reportSetupError(err)
+			return err
+		}
+		args = append(args[:2:2], args[4:]...)
+	}
+	if len(args) < 4 || args[2] != "--" {
+		err := fmt.Errorf("usage: buns %s <socket> <ports> [%s <policy>] -- <command>", BridgeHelper, landlockFlag)
+		reportSetupError(err)
+		return err
+	}
+
+	var ports []int
+	for _, s := range strings.Split(args[1], ",") {
+		if s == "" {
+			continue
+		}
+		port, err := strconv.Atoi(s)
+		if err != nil {
+			err = fmt.Errorf("invalid port '%s'", s)
+			reportSetupError(err)
+			return err
+		}
+		ports = append(ports, port)
+	}
+
+	cmd, err := startBridged(args[0], ports, policy, args[3:])
+	if err != nil {
+		reportSetupError(err)
+		return err
+	}
+	// The error pipe closes once the command has started
+	if f := setupErrorFile(); f != nil {
+		_ = f.Close()
+	}
+
+	exitLike(cmd.Wait())
+	return nil
+}
+
+// startBridged starts relaying to socketPath and then starts command,
+// restricted to policy when given
+func startBridged(socketPath string, ports []int, policy *LandlockPolicy, command []string) (*exec.Cmd, error) {
+	// A new network namespace's loopback interface is down
+	_ = bringUpLoopback()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(SandboxBridgePort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start proxy bridge: %w", err)
+	}
+	go serveBridge(ln, socketPath)
+	intercept(socketPath, ports)
+	resolve(socketPath)
+
+	// buns signals the script's processes itself, so the bridge stays up
+	// until the script exits
+	signal.Notify(make(chan os.Signal, 1), ForwardedSignals...)
+
+	// Keep the error pipe from the command
+	if f := setupErrorFile(); f != nil {
+		syscall.CloseOnExec(int(f.Fd()))
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := startRestricted(cmd, policy); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", command[0], err)
+	}
+	return cmd, nil
+}
+
+// serveBridge relays each connection accepted on ln to socketPath
+func serveBridge(ln net.Listener, socketPath string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go relay(conn, socketPath)
+	}
+}
+
+func relay(conn net.Conn, socketPath string) {
+	upstream, err := net.Dial("unix", socketPath)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	pipe(conn, upstream, upstream)
+}
+
+// intercept makes connections to any address on ports arrive at the bridge,
+// which passes them to the proxy at socketPath. It needs CAP_NET_ADMIN in the
+// network namespace; without it, only clients using the proxy variables
+// reach the network.
+func intercept(socketPath string, ports []int) {
+	if routeAllLocal(syscall.AF_INET) != nil {
+		return
+	}
+	_ = routeAllLocal(syscall.AF_INET6)
+
+	for _, port := range ports {
+		if port == SandboxBridgePort {
+			continue
+		}
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			continue
+		}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go relayIntercepted(conn, socketPath)
+			}
+		}()
+	}
+}
+
+// relayIntercepted passes a connection the script made to another host to
+// the proxy at socketPath, as a tunnel to the address it connected to
+func relayIntercepted(conn net.Conn, socketPath string) {
+	upstream, err := net.Dial("unix", socketPath)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	// The address the script connected to is local to the namespace
+	dst := conn.LocalAddr().(*net.TCPAddr).AddrPort()
+	target := netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port()).String()
+	_, _ = fmt.Fprintf(upstream, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n%s: 1\r\n\r\n", target, target, proxy.TransparentHeader)
+
+	reader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		_ = upstream.Close()
+		_ = conn.Close()
+		return
+	}
+	pipe(conn, upstream, reader)
+}
+
+// resolve answers DNS queries to 127.0.0.1 and the name servers in
+// /etc/resolv.conf with the proxy at socketPath, which only answers for
+// allowed names. Name servers outside the loopback network can only be
+// listened on once intercept has routed every address to the namespace.
+func resolve(socketPath string) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	for _, addr := range nameServers("/etc/resolv.conf") {
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(addr, "53"))
+		if err != nil {
+			continue
+		}
+		go serveDNS(conn, client)
+	}
+}
+
+// nameServers returns 127.0.0.1 and the name servers listed in the
+// resolv.conf at path
+func nameServers(path string) []string {
+	servers := []string{"127.0.0.1"}
+	data, _ := os.ReadFile(path)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[1])
+		if err != nil || slices.Contains(servers, addr.String()) {
+			continue
+		}
+		servers = append(servers, addr.String())
+	}
+	return servers
+}
+
+// serveDNS passes each query received on conn to the proxy through client,
+// sending back its answer
+func serveDNS(conn net.PacketConn, client *http.Client) {
+	buf := make([]byte, 1<<16)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := bytes.Clone(buf[:n])
+		go func() {
+			resp, err := client.Post("http://buns"+proxy.DNSPath, proxy.DNSContentType, bytes.NewReader(query))
+			if err != nil {
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+			answer, err := io.ReadAll(resp.Body)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				return
+			}
+			_, _ = conn.WriteTo(answer, addr)
+		}()
+	}
+}
+
+// pipe copies data both ways between conn and upstream, read from
+// fromUpstream, closing each side once the other is done
+func pipe(conn, upstream net.Conn, fromUpstream io.Reader) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, fromUpstream)
+		_ = conn.Close()
+	}()
+	wg.Wait()
+}
+
+// exitLike ends the bridge the way the command it ran ended: with its exit
+// code, or killed by the same signal. Signals the Go runtime doesn't simply
+// die of are reported as status 128+N, as shells do.
+func exitLike(err error) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		os.Exit(exitErr.ExitCode())
+	}
+	switch sig := status.Signal(); sig {
+	case syscall.SIGKILL, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM:
+		signal.Reset(sig)
+		_ = syscall.Kill(os.Getpid(), sig)
+		// The signal may be handled on another thread
+		time.Sleep(time.Second)
+	}
+	os.Exit(128 + int(status.Signal()))
+}
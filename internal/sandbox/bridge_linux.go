@@ -0,0 +1,145 @@
+package sandbox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bringUpLoopback brings up the loopback interface of the current network
+// namespace, which needs CAP_NET_ADMIN in it
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	if ifr.Uint16()&unix.IFF_UP != 0 {
+		return nil
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// routeAllLocal makes every address of family (AF_INET or AF_INET6) local to
+// the loopback interface of the current network namespace, so connections to
+// any host arrive at listeners there. It needs CAP_NET_ADMIN in the namespace.
+func routeAllLocal(family int) error {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	// The equivalent of "ip route add local default dev lo table local": an
+	// rtmsg with a zero-length destination and the interface as its attribute
+	msg := make([]byte, unix.SizeofNlMsghdr+unix.SizeofRtMsg+unix.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:], unix.RTM_NEWROUTE)
+	binary.NativeEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+	binary.NativeEndian.PutUint32(msg[8:], 1) // Sequence number
+
+	rt := msg[unix.SizeofNlMsghdr:]
+	rt[0] = byte(family)
+	rt[4] = unix.RT_TABLE_LOCAL
+	rt[5] = unix.RTPROT_BOOT
+	rt[6] = unix.RT_SCOPE_HOST
+	rt[7] = unix.RTN_LOCAL
+
+	attr := rt[unix.SizeofRtMsg:]
+	binary.NativeEndian.PutUint16(attr[0:], unix.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint16(attr[2:], unix.RTA_OIF)
+	binary.NativeEndian.PutUint32(attr[4:], uint32(lo.Index))
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	replies, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if reply.Header.Type == unix.NLMSG_ERROR && len(reply.Data) >= 4 {
+			if errno := int32(binary.NativeEndian.Uint32(reply.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+		}
+	}
+	return nil
+}
+
+// startRestricted starts cmd without the capabilities the bridge needs to set
+// up the namespace's network, so the script can't reconfigure it, and
+// restricted to policy when given. Capabilities, Landlock and seccomp belong
+// to threads, so they are applied to a locked thread that cmd is forked from
+// and inherits them from, which is never unlocked and ends with its goroutine.
+func startRestricted(cmd *exec.Cmd, policy *LandlockPolicy) error {
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := dropCapabilities(); err != nil {
+			done <- err
+			return
+		}
+		if policy != nil {
+			if err := restrictThread(*policy); err != nil {
+				done <- err
+				return
+			}
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.AmbientCaps = []uintptr{}
+		done <- cmd.Start()
+	}()
+	return <-done
+}
+
+// dropCapabilities empties the current thread's bounding, ambient,
+// inheritable, permitted and effective capability sets. Emptying the bounding
+// set needs CAP_SETPCAP, which bubblewrap and nsjail don't grant; it only
+// matters for root, which execve would otherwise give the whole set.
+func dropCapabilities() error {
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		// Capabilities newer than the kernel are EINVAL
+		if err != nil && err != unix.EINVAL && (err != unix.EPERM || os.Geteuid() == 0) {
+			return fmt.Errorf("failed to drop capability %d: %w", c, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %w", err)
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to drop capabilities: %w", err)
+	}
+	return nil
+}
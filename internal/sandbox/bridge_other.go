@@ -0,0 +1,23 @@
+//go:build !linux
+
+package sandbox
+
+import "os/exec"
+
+// bringUpLoopback does nothing, as network namespaces are Linux only
+func bringUpLoopback() error {
+	return nil
+}
+
+// routeAllLocal does nothing, as network namespaces are Linux only
+func routeAllLocal(family int) error {
+	return nil
+}
+
+// startRestricted starts cmd, as capabilities and Landlock are Linux only
+func startRestricted(cmd *exec.Cmd, policy *LandlockPolicy) error {
+	if policy != nil {
+		return restrictThread(*policy)
+	}
+	return cmd.Start()
+}
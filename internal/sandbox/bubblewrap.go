@@ -28,9 +28,19 @@ func (b *Bubblewrap) Available() bool {
 
 // Execute runs the script within bubblewrap sandbox
 func (b *Bubblewrap) Execute(ctx context.Context, cfg *Config) (*Result, error) {
-	args, err := b.buildArgs(cfg)
+	// Through the bridge, the script resolves names with buns rather than the host
+	var resolvConf string
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		var err error
+		if resolvConf, err = writeResolvConf(); err != nil {
+			return setupFailed(err)
+		}
+		defer func() { _ = os.Remove(resolvConf) }()
+	}
+
+	args, err := b.buildArgs(cfg, resolvConf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build bwrap args: %w", err)
+		return setupFailed(fmt.Errorf("failed to build bwrap args: %w", err))
 	}
 
 	cmd := exec.CommandContext(ctx, "bwrap", args...)
@@ -41,15 +51,71 @@ func (b *Bubblewrap) Execute(ctx context.Context, cfg *Config) (*Result, error)
 	// Add NODE_PATH
 	cmd.Env = BuildEnvWithNodePath(cmd.Env, cfg.NodeModules)
 
-	// Add memory limit hint (soft limit only - bubblewrap doesn't support rlimits)
+	// Add memory limit hint, making Bun's GC work within the cgroup's limit
 	cmd.Env = BuildEnvWithMemoryLimit(cmd.Env, cfg.MemoryMB)
 
-	err = cmd.Run()
-	return BuildResult(err, cfg, stdout, stderr)
+	// The bridge helper reports setup errors on a pipe
+	setupErr := func() error { return nil }
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		cmd.Env = bridgeEnv(cmd.Env)
+		var err error
+		if setupErr, err = setupErrorPipe(cmd); err != nil {
+			return setupFailed(err)
+		}
+	}
+
+	result, err := runInCgroup(ctx, cmd, cfg, stdout, stderr)
+	if err := setupErr(); err != nil {
+		return setupFailed(err)
+	}
+	signalFromExitStatus(result)
+	return result, err
+}
+
+// Host paths mounted read-only into the bubblewrap sandbox
+var (
+	// System directories and timezone data
+	bwrapSystemPaths = []string{
+		"/usr",
+		"/lib",
+		"/lib64",
+		"/bin",
+		"/sbin",
+		"/etc/alternatives",
+		"/etc/ld.so.cache",
+		"/etc/ld.so.conf",
+		"/etc/ld.so.conf.d",
+		"/usr/share/zoneinfo",
+		"/etc/localtime",
+	}
+
+	// DNS resolution and SSL certificates, for network access via the proxy
+	bwrapNetworkPaths = []string{
+		"/etc/resolv.conf",
+		"/etc/hosts",
+		"/etc/services",
+		"/etc/nsswitch.conf",
+		"/etc/ssl",
+		"/etc/pki",
+		"/etc/ca-certificates",
+		"/usr/share/ca-certificates",
+	}
+)
+
+// SystemPaths returns the host paths the bubblewrap sandbox makes readable to
+// every script, including DNS and TLS configuration when network is enabled.
+// Paths that don't exist on this host are included.
+func SystemPaths(network bool) []string {
+	paths := append([]string{}, bwrapSystemPaths...)
+	if network {
+		paths = append(paths, bwrapNetworkPaths...)
+	}
+	return paths
 }
 
-// buildArgs constructs bubblewrap command arguments
-func (b *Bubblewrap) buildArgs(cfg *Config) ([]string, error) {
+// buildArgs constructs bubblewrap command arguments. resolvConf, if set, is
+// mounted in place of the host's /etc/resolv.conf.
+func (b *Bubblewrap) buildArgs(cfg *Config, resolvConf string) ([]string, error) {
 	var args []string
 
 	// Namespace isolation
@@ -77,64 +143,17 @@ func (b *Bubblewrap) buildArgs(cfg *Config) ([]string, error) {
 	// Proc filesystem
 	args = append(args, "--proc", "/proc")
 
-	// System directories (read-only)
-	systemDirs := []string{
-		"/usr",
-		"/lib",
-		"/lib64",
-		"/bin",
-		"/sbin",
-		"/etc/alternatives",
-		"/etc/ld.so.cache",
-		"/etc/ld.so.conf",
-		"/etc/ld.so.conf.d",
-	}
-
-	for _, dir := range systemDirs {
-		if _, err := os.Stat(dir); err == nil {
-			args = append(args, "--ro-bind", dir, dir)
+	// System directories, timezone data and, with network, DNS and TLS configuration (read-only)
+	for _, path := range SystemPaths(cfg.Network) {
+		if path == "/etc/resolv.conf" && resolvConf != "" {
+			args = append(args, "--ro-bind", resolvConf, path)
+			continue
 		}
-	}
-
-	// Timezone data
-	timezoneDirs := []string{
-		"/usr/share/zoneinfo",
-		"/etc/localtime",
-	}
-	for _, path := range timezoneDirs {
 		if _, err := os.Stat(path); err == nil {
 			args = append(args, "--ro-bind", path, path)
 		}
 	}
 
-	// DNS resolution (if network enabled via proxy)
-	if cfg.Network {
-		dnsFiles := []string{
-			"/etc/resolv.conf",
-			"/etc/hosts",
-			"/etc/services",
-			"/etc/nsswitch.conf",
-		}
-		for _, path := range dnsFiles {
-			if _, err := os.Stat(path); err == nil {
-				args = append(args, "--ro-bind", path, path)
-			}
-		}
-
-		// SSL certificates
-		certDirs := []string{
-			"/etc/ssl",
-			"/etc/pki",
-			"/etc/ca-certificates",
-			"/usr/share/ca-certificates",
-		}
-		for _, dir := range certDirs {
-			if _, err := os.Stat(dir); err == nil {
-				args = append(args, "--ro-bind", dir, dir)
-			}
-		}
-	}
-
 	// Bun binary
 	bunPath, err := ResolvePath(cfg.BunBinary)
 	if err != nil {
@@ -205,12 +224,16 @@ func (b *Bubblewrap) buildArgs(cfg *Config) ([]string, error) {
 	}
 
 	// Add the command to run
-	// If we need network through proxy, wrap with socat bridge
+	// If we need network through proxy, run bun under buns' bridge helper
 	if cfg.Network && cfg.ProxySocketPath != "" {
-		// Create a shell script that sets up socat and runs bun
-		bunCmd := BuildBunCommand(cfg)
-		script := BuildSocatBridgeCommand("/tmp/proxy.sock", bunCmd)
-		args = append(args, "/bin/sh", "-c", script)
+		self, err := bunsExecutable()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--ro-bind", self, self)
+		// The bridge routes every address to itself to intercept connections
+		args = append(args, "--cap-add", "CAP_NET_ADMIN", "--cap-add", "CAP_NET_BIND_SERVICE")
+		args = append(args, BridgeArgs(self, "/tmp/proxy.sock", cfg.InterceptPorts, BuildBunArgs(cfg))...)
 	} else {
 		bunArgs := BuildBunArgs(cfg)
 		args = append(args, bunArgs...)
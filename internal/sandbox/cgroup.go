@@ -0,0 +1,429 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cpu.max accounting period in microseconds
+const cpuPeriod = 100000
+
+// Cgroup is a transient cgroup v2 holding one sandboxed run. The kernel
+// enforces its memory, CPU bandwidth and process limits on the whole process
+// tree, however many processes the script starts.
+type Cgroup struct {
+	path string
+}
+
+// NewCgroup creates a cgroup for a run under the user's delegated cgroup and
+// applies cfg's limits. It fails when cgroups v2 aren't available or not
+// delegated to this user.
+func NewCgroup(cfg *Config) (*Cgroup, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.New("cgroups are only available on Linux")
+	}
+
+	root, err := cgroup2Mount("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	current, err := currentCgroup("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	parent, err := prepareCgroupParent(root, current, os.Getuid(), cgroupControllers(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return newCgroupIn(parent, cfg)
+}
+
+// cgroupControllers returns the cgroup v2 controllers cfg's limits need. CPU
+// time is accounted without the cpu controller, which only limits bandwidth.
+func cgroupControllers(cfg *Config) []string {
+	var controllers []string
+	if cfg.MemoryMB > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if cfg.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if cfg.MaxProcs > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// newCgroupIn creates a run cgroup inside parent and applies cfg's limits
+func newCgroupIn(parent string, cfg *Config) (*Cgroup, error) {
+	path, err := os.MkdirTemp(parent, "run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &Cgroup{path: path}
+
+	if err := cg.applyLimits(cfg); err != nil {
+		_ = cg.Remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// applyLimits writes the memory, CPU bandwidth and process limits
+func (c *Cgroup) applyLimits(cfg *Config) error {
+	if cfg.MemoryMB > 0 {
+		if err := c.write("memory.max", strconv.Itoa(cfg.MemoryMB*1024*1024)); err != nil {
+			return err
+		}
+		// Without swap the limit is reached (and reported) instead of paging out
+		if c.has("memory.swap.max") {
+			if err := c.write("memory.swap.max", "0"); err != nil {
+				return err
+			}
+		}
+		// An OOM kill takes down the whole run rather than one process of it
+		if c.has("memory.oom.group") {
+			if err := c.write("memory.oom.group", "1"); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.CPUs > 0 {
+		quota := int(cfg.CPUs * cpuPeriod)
+		if quota < 1000 {
+			quota = 1000 // The kernel's minimum
+		}
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return err
+		}
+	}
+	if cfg.MaxProcs > 0 {
+		if err := c.write("pids.max", strconv.Itoa(cfg.MaxProcs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Path returns the cgroup's directory
+func (c *Cgroup) Path() string {
+	return c.path
+}
+
+// OOMKilled reports whether the kernel killed a process for exceeding the
+// memory limit
+func (c *Cgroup) OOMKilled() bool {
+	return c.readKey("memory.events", "oom_kill") > 0
+}
+
+// CPUUsage returns the CPU time used by all processes in the cgroup so far
+func (c *Cgroup) CPUUsage() time.Duration {
+	return time.Duration(c.readKey("cpu.stat", "usage_usec")) * time.Microsecond
+}
+
+// addUsage replaces figures in u with the cgroup's, which cover every
+// process of the run rather than those the command waited for
+func (c *Cgroup) addUsage(u *Usage) {
+	if user := c.readKey("cpu.stat", "user_usec"); user > 0 {
+		u.UserCPU = time.Duration(user) * time.Microsecond
+		u.SystemCPU = time.Duration(c.readKey("cpu.stat", "system_usec")) * time.Microsecond
+	}
+
+	// memory.peak needs Linux 5.19
+	if data, err := os.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		if peak, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && peak > 0 {
+			u.PeakRSS = peak
+		}
+	}
+
+	// io.stat has a line per device: "8:0 rbytes=1459200 wbytes=314773504 ..."
+	data, err := os.ReadFile(filepath.Join(c.path, "io.stat"))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+	u.ReadBytes, u.WrittenBytes = 0, 0
+	for _, field := range strings.Fields(string(data)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch key {
+		case "rbytes":
+			u.ReadBytes += n
+		case "wbytes":
+			u.WrittenBytes += n
+		}
+	}
+}
+
+// Kill kills every process in the cgroup
+func (c *Cgroup) Kill() error {
+	if c.has("cgroup.kill") {
+		return c.write("cgroup.kill", "1")
+	}
+
+	// Before Linux 5.14, kill the processes one by one
+	signalAll(c.Procs(), syscall.SIGKILL)
+	return nil
+}
+
+// Procs lists the processes in the cgroup
+func (c *Cgroup) Procs() []int {
+	data, _ := os.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Remove kills anything left in the cgroup and deletes it
+func (c *Cgroup) Remove() error {
+	_ = c.Kill()
+
+	// Killed processes leave the cgroup asynchronously
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+func (c *Cgroup) has(file string) bool {
+	_, err := os.Stat(filepath.Join(c.path, file))
+	return err == nil
+}
+
+func (c *Cgroup) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set cgroup %s: %w", file, err)
+	}
+	return nil
+}
+
+// readKey reads a value from a flat keyed file such as memory.events,
+// returning 0 when it can't be read
+func (c *Cgroup) readKey(file, key string) int64 {
+	f, err := os.Open(filepath.Join(c.path, file))
+	if err != nil {
+		return 0
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// cgroup2Mount returns where the cgroup v2 hierarchy is mounted, from a
+// mountinfo file
+func cgroup2Mount(mountinfo string) (string, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 25 0:30 / /sys/fs/cgroup rw,nosuid - cgroup2 cgroup2 rw
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, fsType := strings.Fields(pre), strings.Fields(post)
+		if len(fields) >= 5 && len(fsType) > 0 && fsType[0] == "cgroup2" {
+			return fields[4], nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// currentCgroup returns the cgroup v2 path of this process, from a
+// /proc/<pid>/cgroup file
+func currentCgroup(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("process is not in a cgroup v2 hierarchy")
+}
+
+// prepareCgroupParent returns the directory run cgroups are created in: a
+// "buns" cgroup inside the user's systemd service (user@<uid>.service), which
+// systemd delegates to the user, or inside the root cgroup for root. It is
+// created with controllers enabled for its children.
+//
+// A process can only start children in a cgroup if it may write to the
+// cgroup.procs of their common ancestor, so buns must itself run inside the
+// user's service. From a login session (session-N.scope), the ancestor is the
+// root-owned user-<uid>.slice.
+func prepareCgroupParent(root, current string, uid int, controllers []string) (string, error) {
+	service := fmt.Sprintf("/user@%d.service", uid)
+	var base string
+	switch {
+	case strings.Contains(current, service+"/"):
+		base = current[:strings.Index(current, service+"/")+len(service)]
+	case uid == 0:
+		base = "/"
+	default:
+		return "", fmt.Errorf("buns runs in cgroup %s, outside the user's delegated %s (run it with systemd-run --user --scope)", current, service[1:])
+	}
+
+	dir := filepath.Join(root, base, "buns")
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("cgroup %s is not delegated to this user: %w", filepath.Join(root, base), err)
+	}
+
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	enabled, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, controller := range controllers {
+		if hasWord(string(enabled), controller) {
+			continue
+		}
+		if !hasWord(string(available), controller) {
+			return "", fmt.Errorf("the cgroup %s controller is not delegated to this user", controller)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			return "", fmt.Errorf("failed to enable the cgroup %s controller: %w", controller, err)
+		}
+	}
+	return dir, nil
+}
+
+func hasWord(s, word string) bool {
+	for _, field := range strings.Fields(s) {
+		if field == word {
+			return true
+		}
+	}
+	return false
+}
+
+// runInCgroup runs cmd with cfg's memory, CPU and process limits enforced by
+// a transient cgroup. Where cgroups v2 aren't available it runs cmd without
+// them, leaving only the limits the backend applies itself.
+func runInCgroup(ctx context.Context, cmd *exec.Cmd, cfg *Config, stdout, stderr *bytes.Buffer) (*Result, error) {
+	cg, err := NewCgroup(cfg)
+	if err != nil {
+		if cfg.Verbose {
+			fmt.Fprintf(os.Stderr, "[buns] Resource limits not enforced: %v\n", err)
+		}
+		return RunCommand(ctx, cmd, cfg, stdout, stderr)
+	}
+	defer func() { _ = cg.Remove() }()
+
+	// A cgroup that can't be joined leaves the limits unenforced, as one
+	// that can't be created does
+	release, err := cg.attach(cmd)
+	if err != nil {
+		if cfg.Verbose {
+			fmt.Fprintf(os.Stderr, "[buns] Resource limits not enforced: %v\n", err)
+		}
+		return RunCommand(ctx, cmd, cfg, stdout, stderr)
+	}
+	term, targets := handleCancel(cmd, cg.Procs, cfg.GracePeriod)
+	start := time.Now()
+	err = cmd.Start()
+	release()
+	if err != nil {
+		term.stop()
+		if cfg.Verbose {
+			fmt.Fprintf(os.Stderr, "[buns] Resource limits not enforced: failed to start in cgroup %s: %v\n", cg.Path(), err)
+		}
+		return RunCommand(ctx, withoutCgroup(ctx, cmd), cfg, stdout, stderr)
+	}
+	stopForwarding := ForwardSignals(targets)
+
+	// cgroups have no CPU time limit, so watch the usage
+	cpuExceeded := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		cpuExceeded <- watchCPU(cg, time.Duration(cfg.CPUSeconds)*time.Second, done)
+	}()
+
+	err = exitErr(cmd, cmd.Wait())
+	wall := time.Since(start)
+	stopForwarding()
+	term.stop()
+	close(done)
+
+	cpuKilled := <-cpuExceeded
+	result, err := BuildResult(err, cfg, stdout, stderr)
+	if err != nil {
+		return result, err
+	}
+
+	// The kernel and the CPU watch kill with SIGKILL, so the limits explain
+	// the kill better than the signal does
+	result.Termination, result.Signal = ProcessTermination(ctx, cmd.ProcessState)
+	switch {
+	case cg.OOMKilled():
+		result.Termination, result.Signal = TerminationMemoryLimit, 0
+	case cpuKilled:
+		result.Termination, result.Signal = TerminationCPULimit, 0
+	}
+	result.Usage = ProcessUsage(cmd.ProcessState, wall)
+	cg.addUsage(&result.Usage)
+	return result, nil
+}
+
+// watchCPU kills the cgroup's processes once they have used limit of CPU
+// time, reporting whether it did. It returns when done is closed.
+func watchCPU(cg *Cgroup, limit time.Duration, done <-chan struct{}) bool {
+	if limit <= 0 {
+		<-done
+		return false
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-ticker.C:
+			if cg.CPUUsage() >= limit {
+				_ = cg.Kill()
+				<-done
+				return true
+			}
+		}
+	}
+}
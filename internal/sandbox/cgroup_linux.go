@@ -0,0 +1,46 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// attach makes cmd start inside the cgroup, so no process of the run ever
+// escapes its limits. The returned function releases the cgroup once cmd has
+// started.
+func (c *Cgroup) attach(cmd *exec.Cmd) (func(), error) {
+	dir, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	return func() { _ = dir.Close() }, nil
+}
+
+// withoutCgroup returns a copy of cmd, which failed to start in a cgroup, to
+// start outside it. A command can only be started once.
+func withoutCgroup(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	retry := exec.CommandContext(ctx, cmd.Path)
+	retry.Args = cmd.Args
+	retry.Env = cmd.Env
+	retry.Dir = cmd.Dir
+	retry.Stdin = cmd.Stdin
+	retry.Stdout = cmd.Stdout
+	retry.Stderr = cmd.Stderr
+	retry.ExtraFiles = cmd.ExtraFiles
+	if cmd.SysProcAttr != nil {
+		attr := *cmd.SysProcAttr
+		attr.UseCgroupFD, attr.CgroupFD = false, 0
+		retry.SysProcAttr = &attr
+	}
+	return retry
+}
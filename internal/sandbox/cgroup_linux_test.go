@@ -0,0 +1,40 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestWithoutCgroup(t *testing.T) {
+	// A directory that isn't a cgroup can't be started in
+	dir, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dir.Close() }()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", "echo $GREETING")
+	cmd.Env = []string{"GREETING=hello"}
+	cmd.Stdout = &stdout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, UseCgroupFD: true, CgroupFD: int(dir.Fd())}
+	if err := cmd.Start(); err == nil {
+		_ = cmd.Wait()
+		t.Skip("started in a directory that isn't a cgroup")
+	}
+
+	retry := withoutCgroup(context.Background(), cmd)
+	if err := retry.Run(); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if got := stdout.String(); got != "hello\n" {
+		t.Errorf("stdout = %q, want the same command's output", got)
+	}
+	if !retry.SysProcAttr.Setpgid {
+		t.Error("the retry lost the command's other attributes")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+// attach is unsupported, as cgroups are Linux only
+func (c *Cgroup) attach(cmd *exec.Cmd) (func(), error) {
+	return nil, errors.New("cgroups are only available on Linux")
+}
+
+// withoutCgroup returns cmd, as attach never sets it up to start in a cgroup
+func withoutCgroup(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	return cmd
+}
@@ -0,0 +1,228 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles creates files with content under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCgroup2Mount(t *testing.T) {
+	dir := t.TempDir()
+	mountinfo := filepath.Join(dir, "mountinfo")
+	writeFiles(t, dir, map[string]string{"mountinfo": `22 1 0:21 / /proc rw,nosuid - proc proc rw
+30 24 0:26 / /sys/fs/cgroup/cpu rw,relatime shared:9 - cgroup cgroup rw,cpu
+36 25 0:30 / /sys/fs/cgroup/unified rw,nosuid shared:10 - cgroup2 cgroup2 rw
+`})
+
+	got, err := cgroup2Mount(mountinfo)
+	if err != nil {
+		t.Fatalf("cgroup2Mount() error = %v", err)
+	}
+	if got != "/sys/fs/cgroup/unified" {
+		t.Errorf("cgroup2Mount() = %q", got)
+	}
+
+	writeFiles(t, dir, map[string]string{"mountinfo": "22 1 0:21 / /proc rw - proc proc rw\n"})
+	if _, err := cgroup2Mount(mountinfo); err == nil {
+		t.Error("expected error without a cgroup2 mount")
+	}
+}
+
+func TestCurrentCgroup(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cgroup")
+	writeFiles(t, dir, map[string]string{"cgroup": "1:name=systemd:/\n0::/user.slice/user-1000.slice/user@1000.service/app.slice/term.scope\n"})
+
+	got, err := currentCgroup(file)
+	if err != nil {
+		t.Fatalf("currentCgroup() error = %v", err)
+	}
+	if got != "/user.slice/user-1000.slice/user@1000.service/app.slice/term.scope" {
+		t.Errorf("currentCgroup() = %q", got)
+	}
+}
+
+func TestPrepareCgroupParent(t *testing.T) {
+	service := "user.slice/user-1000.slice/user@1000.service"
+
+	t.Run("uses the user's service and enables controllers", func(t *testing.T) {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			service + "/buns/cgroup.controllers":     "cpu memory pids\n",
+			service + "/buns/cgroup.subtree_control": "",
+		})
+
+		dir, err := prepareCgroupParent(root, "/"+service+"/app.slice/term.scope", 1000, []string{"memory", "pids"})
+		if err != nil {
+			t.Fatalf("prepareCgroupParent() error = %v", err)
+		}
+		if dir != filepath.Join(root, service, "buns") {
+			t.Errorf("dir = %q", dir)
+		}
+		// cgroupfs applies each write; a plain file keeps the last
+		if got := readFile(t, filepath.Join(dir, "cgroup.subtree_control")); got != "+pids" {
+			t.Errorf("subtree_control = %q", got)
+		}
+	})
+
+	t.Run("not from a session outside the user's service", func(t *testing.T) {
+		// Runs started there couldn't move into the service, through the
+		// root-owned user-1000.slice
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			service + "/buns/cgroup.controllers":     "memory\n",
+			service + "/buns/cgroup.subtree_control": "memory\n",
+		})
+
+		_, err := prepareCgroupParent(root, "/user.slice/user-1000.slice/session-2.scope", 1000, []string{"memory"})
+		if err == nil || !strings.Contains(err.Error(), "session-2.scope") {
+			t.Errorf("error = %v, want an error naming the session", err)
+		}
+	})
+
+	t.Run("controller not delegated", func(t *testing.T) {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			service + "/buns/cgroup.controllers":     "memory pids\n",
+			service + "/buns/cgroup.subtree_control": "",
+		})
+
+		_, err := prepareCgroupParent(root, "/"+service+"/init.scope", 1000, []string{"cpu"})
+		if err == nil || !strings.Contains(err.Error(), "cpu") {
+			t.Errorf("error = %v, want cpu controller error", err)
+		}
+	})
+
+	t.Run("no delegated cgroup", func(t *testing.T) {
+		if _, err := prepareCgroupParent(t.TempDir(), "/"+service+"/init.scope", 1000, nil); err == nil {
+			t.Error("expected error when the user's service cgroup doesn't exist")
+		}
+	})
+}
+
+func TestCgroup_applyLimits(t *testing.T) {
+	parent := t.TempDir()
+	cg, err := newCgroupIn(parent, &Config{MemoryMB: 64, CPUs: 0.5, MaxProcs: 100})
+	if err != nil {
+		t.Fatalf("newCgroupIn() error = %v", err)
+	}
+
+	if !strings.HasPrefix(cg.Path(), filepath.Join(parent, "run-")) {
+		t.Errorf("path = %q", cg.Path())
+	}
+
+	want := map[string]string{
+		"memory.max": "67108864",
+		"cpu.max":    "50000 100000",
+		"pids.max":   "100",
+	}
+	for file, value := range want {
+		if got := readFile(t, filepath.Join(cg.Path(), file)); got != value {
+			t.Errorf("%s = %q, want %q", file, got, value)
+		}
+	}
+
+	// Unset limits aren't written
+	cg, err = newCgroupIn(parent, &Config{})
+	if err != nil {
+		t.Fatalf("newCgroupIn() error = %v", err)
+	}
+	entries, _ := os.ReadDir(cg.Path())
+	if len(entries) != 0 {
+		t.Errorf("expected no limit files, got %d", len(entries))
+	}
+}
+
+func TestCgroup_stats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n",
+		"cpu.stat":      "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+	})
+	cg := &Cgroup{path: dir}
+
+	if !cg.OOMKilled() {
+		t.Error("expected OOM kill to be reported")
+	}
+	if got := cg.CPUUsage(); got != 2500*time.Millisecond {
+		t.Errorf("CPUUsage() = %v", got)
+	}
+
+	empty := &Cgroup{path: t.TempDir()}
+	if empty.OOMKilled() || empty.CPUUsage() != 0 {
+		t.Error("missing files should read as zero")
+	}
+}
+
+func TestCgroup_addUsage(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cpu.stat":    "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"memory.peak": "52428800\n",
+		"io.stat":     "8:0 rbytes=4096 wbytes=1000 rios=1 wios=1\n8:16 rbytes=100 wbytes=0 rios=1 wios=0\n",
+	})
+	cg := &Cgroup{path: dir}
+
+	u := Usage{UserCPU: time.Second, PeakRSS: 1024, ReadBytes: 1}
+	cg.addUsage(&u)
+	want := Usage{UserCPU: 2 * time.Second, SystemCPU: 500 * time.Millisecond, PeakRSS: 52428800, ReadBytes: 4196, WrittenBytes: 1000}
+	if u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+
+	// Figures the cgroup can't provide are kept
+	u = Usage{UserCPU: time.Second, PeakRSS: 1024, ReadBytes: 1}
+	(&Cgroup{path: t.TempDir()}).addUsage(&u)
+	if u.UserCPU != time.Second || u.PeakRSS != 1024 || u.ReadBytes != 1 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestWatchCPU(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cpu.stat":    "usage_usec 100\n",
+		"cgroup.kill": "",
+	})
+	cg := &Cgroup{path: dir}
+
+	done := make(chan struct{})
+	result := make(chan bool)
+	go func() { result <- watchCPU(cg, time.Second, done) }()
+
+	writeFiles(t, dir, map[string]string{"cpu.stat": "usage_usec 1500000\n"})
+	time.Sleep(300 * time.Millisecond)
+	if got := readFile(t, filepath.Join(dir, "cgroup.kill")); got != "1" {
+		t.Errorf("cgroup.kill = %q, want 1", got)
+	}
+
+	close(done)
+	if !<-result {
+		t.Error("expected the limit to be reported as exceeded")
+	}
+}
@@ -2,12 +2,15 @@ package sandbox
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 // SafeEnvVars is a whitelist of environment variables considered safe to pass through
@@ -31,6 +34,20 @@ var SafeEnvVars = map[string]bool{
 // SafeEnvPrefixes are prefixes for environment variables considered safe
 var SafeEnvPrefixes = []string{"LC_", "XDG_"}
 
+// IsSafeEnvVar reports whether a variable is passed to sandboxed scripts
+// without being allowed explicitly
+func IsSafeEnvVar(name string) bool {
+	if SafeEnvVars[name] {
+		return true
+	}
+	for _, prefix := range SafeEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // FilterEnv creates a filtered environment from the current environment
 // It includes only safe vars and explicitly allowed vars
 func FilterEnv(allowed []string) []string {
@@ -47,24 +64,9 @@ func FilterEnv(allowed []string) []string {
 		}
 		name := parts[0]
 
-		// Check if explicitly allowed
-		if allowedSet[name] {
-			filtered = append(filtered, env)
-			continue
-		}
-
-		// Check if in safe list
-		if SafeEnvVars[name] {
+		// Explicitly allowed, in the safe list or with a safe prefix
+		if allowedSet[name] || IsSafeEnvVar(name) {
 			filtered = append(filtered, env)
-			continue
-		}
-
-		// Check if has safe prefix
-		for _, prefix := range SafeEnvPrefixes {
-			if strings.HasPrefix(name, prefix) {
-				filtered = append(filtered, env)
-				break
-			}
 		}
 	}
 
@@ -77,9 +79,10 @@ func ShellEscape(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
 }
 
-// BuildBunArgs constructs the bun command arguments
+// BuildBunArgs constructs the bun command arguments, including any wrapper
 func BuildBunArgs(cfg *Config) []string {
-	args := []string{cfg.BunBinary, "run", cfg.ScriptPath}
+	args := append([]string{}, cfg.BunWrapper...)
+	args = append(args, cfg.BunBinary, "run", cfg.ScriptPath)
 	args = append(args, cfg.ScriptArgs...)
 	return args
 }
@@ -87,11 +90,11 @@ func BuildBunArgs(cfg *Config) []string {
 // BuildBunCommand constructs an escaped bun command string from config.
 func BuildBunCommand(cfg *Config) string {
 	bunArgs := BuildBunArgs(cfg)
-	bunCmd := ShellEscape(cfg.BunBinary)
-	for _, arg := range bunArgs[1:] {
-		bunCmd += " " + ShellEscape(arg)
+	escaped := make([]string, len(bunArgs))
+	for i, arg := range bunArgs {
+		escaped[i] = ShellEscape(arg)
 	}
-	return bunCmd
+	return strings.Join(escaped, " ")
 }
 
 // ProxyEnvVars returns environment variables for the sandbox bridge proxy.
@@ -103,29 +106,50 @@ func ProxyEnvVars() []string {
 		"http_proxy=http://127.0.0.1:" + port,
 		"https_proxy=http://127.0.0.1:" + port,
 		"ALL_PROXY=http://127.0.0.1:" + port,
+		"all_proxy=http://127.0.0.1:" + port,
 	}
 }
 
-// BuildSocatBridgeCommand creates a shell command that starts socat to bridge
-// localhost traffic to a Unix socket, then runs the given command.
-// Uses a retry loop instead of sleep to avoid race conditions.
-func BuildSocatBridgeCommand(socketPath string, bunCmd string) string {
-	return fmt.Sprintf(
-		`socat TCP-LISTEN:%d,fork,reuseaddr UNIX-CONNECT:%s &
-SOCAT_PID=$!
-for i in 1 2 3 4 5 6 7 8 9 10; do
-  if nc -z 127.0.0.1 %d 2>/dev/null; then break; fi
-  sleep 0.05
-done
-%s
-EXIT_CODE=$?
-kill $SOCAT_PID 2>/dev/null
-exit $EXIT_CODE`,
-		SandboxBridgePort,
-		ShellEscape(socketPath),
-		SandboxBridgePort,
-		bunCmd,
-	)
+// setupErrorPipe gives cmd a pipe on file descriptor 3, which buns' helper
+// commands report errors on that stop them starting the script. Once cmd has
+// exited, read returns the error reported, if any.
+func setupErrorPipe(cmd *exec.Cmd) (read func() error, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = []*os.File{w}
+
+	return func() error {
+		_ = w.Close()
+		defer func() { _ = r.Close() }()
+		if msg, _ := io.ReadAll(r); len(msg) > 0 {
+			return errors.New(strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}, nil
+}
+
+// setupErrorFile returns the pipe from setupErrorPipe in a helper command,
+// or nil when run without one, as by hand. File descriptor 3 is then likely
+// the Go runtime's own.
+func setupErrorFile() *os.File {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(3, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+		return nil
+	}
+	return os.NewFile(3, "errors")
+}
+
+// reportSetupError reports err from a helper command on the pipe from
+// setupErrorPipe, or on stderr without one
+func reportSetupError(err error) {
+	if f := setupErrorFile(); f != nil {
+		if _, werr := fmt.Fprintln(f, err); werr == nil {
+			return
+		}
+	}
+	_, _ = fmt.Fprintln(os.Stderr, err)
 }
 
 // SetupCommand configures a command with standard I/O handling and environment.
@@ -156,6 +180,7 @@ func SetupCommand(cmd *exec.Cmd, cfg *Config) (*bytes.Buffer, *bytes.Buffer) {
 }
 
 // BuildResult creates a Result from command execution, extracting exit code and output.
+// A command that couldn't be run at all is a setup failure.
 func BuildResult(err error, cfg *Config, stdout, stderr *bytes.Buffer) (*Result, error) {
 	result := &Result{}
 
@@ -169,6 +194,8 @@ func BuildResult(err error, cfg *Config, stdout, stderr *bytes.Buffer) (*Result,
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		result.ExitCode = exitErr.ExitCode()
 	} else if err != nil {
+		result.ExitCode = -1
+		result.Termination = TerminationSetupFailed
 		return result, err
 	}
 
@@ -213,7 +240,8 @@ func BuildEnvWithNodePath(baseEnv []string, nodeModules string) []string {
 
 // BuildEnvWithMemoryLimit adds BUN_JSC_forceRAMSize to hint memory limits to Bun.
 // This is a soft limit - it makes Bun's GC more aggressive but is NOT enforced.
-// Only nsjail on Linux provides hard memory limits via rlimit.
+// Hard limits come from a cgroup on Linux with cgroups v2, and from rlimits
+// under nsjail.
 func BuildEnvWithMemoryLimit(baseEnv []string, memoryMB int) []string {
 	if memoryMB <= 0 {
 		return baseEnv
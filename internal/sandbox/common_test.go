@@ -2,6 +2,7 @@ package sandbox
 
 import (
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -95,6 +96,24 @@ func TestBuildBunArgs(t *testing.T) {
 	}
 }
 
+func TestBuildBunArgs_with_wrapper(t *testing.T) {
+	cfg := &Config{
+		BunBinary:  "/path/to/bun",
+		BunWrapper: []string{"strace", "-o", "/tmp/trace"},
+		ScriptPath: "/path/to/script.ts",
+	}
+
+	args := BuildBunArgs(cfg)
+
+	expected := []string{"strace", "-o", "/tmp/trace", "/path/to/bun", "run", "/path/to/script.ts"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("args = %v, want %v", args, expected)
+	}
+	if got := BuildBunCommand(cfg); got != "'strace' '-o' '/tmp/trace' '/path/to/bun' 'run' '/path/to/script.ts'" {
+		t.Errorf("command = %s", got)
+	}
+}
+
 func TestBuildEnvWithNodePath(t *testing.T) {
 	base := []string{"PATH=/usr/bin", "HOME=/home/test"}
 
@@ -13,6 +13,7 @@ type Config struct {
 	ProxySocketPath string   // Unix socket path for proxy (Linux)
 	ProxyPort       int      // TCP port for HTTP proxy (macOS/fallback)
 	ProxySOCKS5Port int      // TCP port for SOCKS5 proxy
+	InterceptPorts  []int    // Ports connected to on any host that go through the proxy (Linux)
 
 	// Filesystem settings
 	ReadablePaths []string // Additional paths to allow reading
@@ -20,12 +21,16 @@ type Config struct {
 	WorkDir       string   // Working directory
 
 	// Resource limits
-	MemoryMB   int           // Memory limit in MB
-	Timeout    time.Duration // Execution timeout
-	CPUSeconds int           // CPU time limit
+	MemoryMB    int           // Memory limit in MB
+	Timeout     time.Duration // Execution timeout
+	CPUSeconds  int           // CPU time limit
+	CPUs        float64       // CPU bandwidth in cores, e.g. 0.5 (0 = unlimited)
+	MaxProcs    int           // Maximum processes and threads (0 = unlimited)
+	GracePeriod time.Duration // Time between SIGTERM and SIGKILL on timeout (0 = SIGKILL at once)
 
 	// Bun settings
 	BunBinary   string   // Path to Bun binary
+	BunWrapper  []string // Command that runs Bun, e.g. a tracer (nil = run Bun directly)
 	ScriptPath  string   // Path to script to execute
 	ScriptArgs  []string // Arguments to pass to script
 	NodeModules string   // Path to node_modules (for NODE_PATH)
@@ -43,5 +48,6 @@ type Config struct {
 	Verbose bool
 }
 
-// SandboxBridgePort is the fixed port for socat bridge in isolated namespaces
+// SandboxBridgePort is the fixed port the bridge helper relays to the proxy
+// in isolated network namespaces
 const SandboxBridgePort = 19850
@@ -0,0 +1,202 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Diagnosis is what a backend needs and the isolation it provides on this
+// host, as reported by "buns sandbox doctor"
+type Diagnosis struct {
+	Backend   string
+	Usable    bool // Every required check passed
+	Checks    []Check
+	Isolation []Guarantee
+}
+
+// Check is one thing a backend needs
+type Check struct {
+	Name     string
+	OK       bool
+	Detail   string
+	Required bool // The backend can't run without it
+}
+
+// Guarantee is how a backend enforces one kind of isolation: filesystem,
+// network, memory or CPU
+type Guarantee struct {
+	Kind     string
+	Enforced bool
+	Detail   string
+}
+
+// host is what the doctor found out about this system
+type host struct {
+	linux       bool
+	binaries    map[string]string // Path of each tool found in PATH
+	userNS      error             // Why user namespaces can't be created
+	cgroup      error             // Why run cgroups can't be created
+	landlockABI int
+	seccomp     bool
+}
+
+// Diagnose probes each of Backends on this host
+func Diagnose() []Diagnosis {
+	return diagnose(probeHost())
+}
+
+// probeHost looks for the tools and kernel features backends need. It
+// creates a user namespace and a cgroup to check they work.
+func probeHost() host {
+	h := host{linux: runtime.GOOS == "linux", binaries: make(map[string]string)}
+	for _, name := range []string{"bwrap", "nsjail", "unshare"} {
+		if path, err := exec.LookPath(name); err == nil {
+			h.binaries[name] = path
+		}
+	}
+	if !h.linux {
+		return h
+	}
+
+	h.userNS = probeUserNamespaces()
+	h.landlockABI = landlockABI()
+	h.seccomp = seccompAvailable()
+
+	cg, err := NewCgroup(&Config{MemoryMB: 1, CPUs: 1, MaxProcs: 1})
+	if err == nil {
+		err = cg.Remove()
+	}
+	h.cgroup = err
+
+	return h
+}
+
+// probeUserNamespaces starts a process in new user and network namespaces,
+// as the backends do
+func probeUserNamespaces() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, "--version")
+	isolateNetwork(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	_ = cmd.Wait()
+	return nil
+}
+
+func diagnose(h host) []Diagnosis {
+	diagnoses := make([]Diagnosis, 0, len(Backends))
+	for _, name := range Backends {
+		d := Diagnosis{Backend: name}
+		if h.linux {
+			d.Checks, d.Isolation = diagnoseBackend(name, h)
+		} else {
+			d.Checks = []Check{{Name: "Linux", Detail: "only available on Linux", Required: true}}
+		}
+
+		d.Usable = true
+		for _, c := range d.Checks {
+			if c.Required && !c.OK {
+				d.Usable = false
+			}
+		}
+		diagnoses = append(diagnoses, d)
+	}
+	return diagnoses
+}
+
+// diagnoseBackend checks what backend name needs on a Linux host and works
+// out the isolation it provides there
+func diagnoseBackend(name string, h host) ([]Check, []Guarantee) {
+	userNS := Check{Name: "user namespaces", OK: h.userNS == nil, Detail: errDetail(h.userNS, "working"), Required: true}
+	cgroup := Check{Name: "cgroup v2 delegation", OK: h.cgroup == nil, Detail: errDetail(h.cgroup, "working")}
+	memory, cpu := cgroupLimits(h.cgroup == nil)
+
+	switch name {
+	case "bwrap":
+		return []Check{binaryCheck(h, "bwrap"), userNS, cgroup}, []Guarantee{
+			{"filesystem", true, "private mount namespace with only the script, its dependencies and allowed paths"},
+			{"network", true, "empty network namespace, with network access only through the proxy"},
+			memory, cpu,
+		}
+
+	case "nsjail":
+		// Without a cgroup, nsjail's own rlimits still apply
+		if h.cgroup != nil {
+			memory = Guarantee{"memory", true, "address space rlimit, per process"}
+			cpu = Guarantee{"cpu", true, "CPU time rlimit, per process"}
+		}
+		return []Check{binaryCheck(h, "nsjail"), userNS, cgroup}, []Guarantee{
+			{"filesystem", true, "private mount namespace with only the script, its dependencies and allowed paths"},
+			{"network", true, "empty network namespace, with network access only through the proxy"},
+			memory, cpu,
+		}
+
+	case "landlock":
+		abi := Check{Name: "Landlock", OK: h.landlockABI > 0, Detail: fmt.Sprintf("ABI %d", h.landlockABI), Required: true}
+		if !abi.OK {
+			abi.Detail = "not supported by this kernel (needs Linux 5.13)"
+		}
+		seccomp := Check{Name: "seccomp", OK: h.seccomp, Detail: "working", Required: true}
+		if !seccomp.OK {
+			seccomp.Detail = "not supported by this kernel"
+		}
+		userNS.Required = false
+		if !userNS.OK {
+			userNS.Detail += "; runs rely on Landlock network rules, which match ports on any address"
+		}
+
+		network := Guarantee{"network", true, "empty network namespace, with network access only through the proxy"}
+		if h.userNS != nil {
+			if h.landlockABI >= landlockNetworkABI {
+				network.Detail = "TCP connections only to the proxy's port numbers, but on any address, as user namespaces don't work; other sockets are blocked"
+			} else {
+				network.Enforced = false
+				network.Detail = "runs fail, as this kernel can't restrict TCP ports (needs Linux 6.7) and user namespaces don't work"
+			}
+		}
+		return []Check{abi, seccomp, userNS, cgroup}, []Guarantee{
+			{"filesystem", true, "Landlock rules allow only the script, its dependencies and allowed paths; other processes stay visible"},
+			network, memory, cpu,
+		}
+
+	case "unshare":
+		return []Check{binaryCheck(h, "unshare"), userNS, cgroup}, []Guarantee{
+			{"filesystem", false, "network only: the script can read and write anything you can"},
+			{"network", true, "empty network namespace, with network access only through the proxy"},
+			memory, cpu,
+		}
+	}
+	return nil, nil
+}
+
+// cgroupLimits are the memory and CPU guarantees of backends that use a cgroup
+// when one can be created
+func cgroupLimits(available bool) (Guarantee, Guarantee) {
+	if !available {
+		return Guarantee{"memory", false, "no cgroup, so only a hint to Bun's garbage collector"},
+			Guarantee{"cpu", false, "no cgroup, so only the timeout"}
+	}
+	return Guarantee{"memory", true, "cgroup memory.max across every process of the script"},
+		Guarantee{"cpu", true, "cgroup CPU accounting and cpu.max across every process of the script"}
+}
+
+func binaryCheck(h host, name string) Check {
+	path, ok := h.binaries[name]
+	if !ok {
+		path = "not found in PATH"
+	}
+	return Check{Name: name + " binary", OK: ok, Detail: path, Required: true}
+}
+
+func errDetail(err error, ok string) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ok
+}
@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+)
+
+// diagnosisOf returns the diagnosis of backend
+func diagnosisOf(t *testing.T, diagnoses []Diagnosis, backend string) Diagnosis {
+	t.Helper()
+	for _, d := range diagnoses {
+		if d.Backend == backend {
+			return d
+		}
+	}
+	t.Fatalf("no diagnosis for %s", backend)
+	return Diagnosis{}
+}
+
+// guarantee returns how d enforces kind of isolation
+func guarantee(t *testing.T, d Diagnosis, kind string) Guarantee {
+	t.Helper()
+	for _, g := range d.Isolation {
+		if g.Kind == kind {
+			return g
+		}
+	}
+	t.Fatalf("%s has no %s guarantee", d.Backend, kind)
+	return Guarantee{}
+}
+
+func TestDiagnose(t *testing.T) {
+	t.Run("everything available", func(t *testing.T) {
+		diagnoses := diagnose(host{
+			linux:       true,
+			binaries:    map[string]string{"bwrap": "/usr/bin/bwrap", "nsjail": "/usr/bin/nsjail", "unshare": "/usr/bin/unshare"},
+			landlockABI: 6,
+			seccomp:     true,
+		})
+		if len(diagnoses) != len(Backends) {
+			t.Fatalf("got %d diagnoses, want one per backend", len(diagnoses))
+		}
+		for _, d := range diagnoses {
+			if !d.Usable {
+				t.Errorf("%s should be usable: %+v", d.Backend, d.Checks)
+			}
+		}
+
+		bwrap := diagnosisOf(t, diagnoses, "bwrap")
+		for _, kind := range []string{"filesystem", "network", "memory", "cpu"} {
+			if !guarantee(t, bwrap, kind).Enforced {
+				t.Errorf("bwrap should enforce %s", kind)
+			}
+		}
+		if guarantee(t, diagnosisOf(t, diagnoses, "unshare"), "filesystem").Enforced {
+			t.Error("unshare doesn't isolate the filesystem")
+		}
+		if !guarantee(t, diagnosisOf(t, diagnoses, "nsjail"), "network").Enforced {
+			t.Error("nsjail should keep scripts with network access to the proxy")
+		}
+	})
+
+	t.Run("minimal host", func(t *testing.T) {
+		diagnoses := diagnose(host{
+			linux:       true,
+			binaries:    map[string]string{},
+			userNS:      errors.New("operation not permitted"),
+			cgroup:      errors.New("cgroup2 not mounted"),
+			landlockABI: 3,
+			seccomp:     true,
+		})
+
+		for _, name := range []string{"bwrap", "nsjail", "unshare"} {
+			if diagnosisOf(t, diagnoses, name).Usable {
+				t.Errorf("%s shouldn't be usable without its binary", name)
+			}
+		}
+
+		landlock := diagnosisOf(t, diagnoses, "landlock")
+		if !landlock.Usable {
+			t.Fatalf("landlock only needs kernel support: %+v", landlock.Checks)
+		}
+		if guarantee(t, landlock, "network").Enforced {
+			t.Error("landlock can't restrict the network without user namespaces or ABI 4")
+		}
+		if guarantee(t, landlock, "memory").Enforced {
+			t.Error("memory isn't limited without a cgroup")
+		}
+	})
+
+	t.Run("not Linux", func(t *testing.T) {
+		for _, d := range diagnose(host{binaries: map[string]string{}}) {
+			if d.Usable {
+				t.Errorf("%s shouldn't be usable", d.Backend)
+			}
+		}
+	})
+}
+
+func TestBackend(t *testing.T) {
+	for _, name := range Backends {
+		if _, err := Backend(name); err != nil {
+			t.Errorf("Backend(%q) error = %v", name, err)
+		}
+	}
+	if _, err := Backend("docker"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+
+	unshare, _ := Backend("unshare")
+	bwrap, _ := Backend("bwrap")
+	if FullIsolation(unshare) || !FullIsolation(bwrap) {
+		t.Error("only unshare should be network-only")
+	}
+}
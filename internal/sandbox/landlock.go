@@ -0,0 +1,210 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// LandlockHelper is the hidden buns command that restricts itself and then
+// runs the script, as Landlock and seccomp apply to the process that asks
+const LandlockHelper = "__landlock"
+
+// Landlock implements full sandbox using the kernel's Landlock LSM for
+// filesystem and TCP rules and a seccomp filter for everything else, so it
+// needs no external binaries
+type Landlock struct{}
+
+// Name returns the sandbox name
+func (l *Landlock) Name() string {
+	return "landlock"
+}
+
+// IsSandboxed returns true since this provides full isolation
+func (l *Landlock) IsSandboxed() bool {
+	return true
+}
+
+// Available checks the kernel supports Landlock and seccomp
+func (l *Landlock) Available() bool {
+	return runtime.GOOS == "linux" && landlockABI() > 0 && seccompAvailable()
+}
+
+// LandlockPolicy is what the helper restricts the script to
+type LandlockPolicy struct {
+	ReadPaths  []string `json:"read"`  // Readable and executable
+	WritePaths []string `json:"write"` // Fully accessible
+
+	// Network restrictions: TCP connections only to these ports, and no other
+	// sockets besides socket pairs. Landlock matches the port on any address.
+	RestrictNetwork bool  `json:"restrict_network"`
+	ConnectPorts    []int `json:"connect_ports"`
+}
+
+// Execute runs the script within a Landlock sandbox
+func (l *Landlock) Execute(ctx context.Context, cfg *Config) (*Result, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return setupFailed(fmt.Errorf("failed to find buns executable: %w", err))
+	}
+
+	// A private temp directory stands in for bubblewrap's tmpfs /tmp
+	tmpDir, err := os.MkdirTemp("", "buns-landlock-")
+	if err != nil {
+		return setupFailed(fmt.Errorf("failed to create temp dir: %w", err))
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// Landlock's rules match the proxy's port numbers on any address, so
+	// with network access the script runs in an empty network namespace,
+	// reaching the proxy through buns' bridge, which applies the policy
+	bridged := cfg.Network && cfg.ProxySocketPath != "" && userNamespacesAvailable()
+
+	policy, err := buildLandlockPolicy(cfg, tmpDir, bridged)
+	if err != nil {
+		return setupFailed(err)
+	}
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return setupFailed(err)
+	}
+
+	args := append([]string{self, LandlockHelper, string(encoded), "--"}, BuildBunArgs(cfg)...)
+	if bridged {
+		args = landlockBridgeArgs(self, cfg.ProxySocketPath, cfg.InterceptPorts, string(encoded), BuildBunArgs(cfg))
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
+	// Setup I/O and environment
+	stdout, stderr := SetupCommand(cmd, cfg)
+	cmd.Env = append(cmd.Env, "TMPDIR="+tmpDir)
+
+	// Add NODE_PATH
+	cmd.Env = BuildEnvWithNodePath(cmd.Env, cfg.NodeModules)
+
+	// Add memory limit hint, making Bun's GC work within the cgroup's limit
+	cmd.Env = BuildEnvWithMemoryLimit(cmd.Env, cfg.MemoryMB)
+
+	cmd.Dir = cfg.WorkDir
+
+	switch {
+	case bridged:
+		isolateNetwork(cmd)
+		cmd.Env = bridgeEnv(cmd.Env)
+	case !cfg.Network && userNamespacesAvailable():
+		// An empty network namespace on top of the Landlock rules
+		isolateNetwork(cmd)
+	case landlockABI() < landlockNetworkABI:
+		return setupFailed(errors.New("Landlock can't restrict network access on this kernel (needs Linux 6.7)"))
+	}
+
+	// The helper reports setup errors on a pipe closed when the script starts
+	setupErr, err := setupErrorPipe(cmd)
+	if err != nil {
+		return setupFailed(err)
+	}
+
+	result, err := runInCgroup(ctx, cmd, cfg, stdout, stderr)
+	if err := setupErr(); err != nil {
+		return setupFailed(err)
+	}
+	return result, err
+}
+
+// buildLandlockPolicy allows what the bubblewrap sandbox mounts: system
+// paths, Bun, the script and its dependencies read-only, and the writable
+// paths and a private temp directory read-write. Bridged scripts connect to
+// the bridge and the ports it intercepts rather than the proxy's ports.
+func buildLandlockPolicy(cfg *Config, tmpDir string, bridged bool) (LandlockPolicy, error) {
+	policy := LandlockPolicy{RestrictNetwork: true}
+
+	read := append(SystemPaths(cfg.Network), "/proc", "/dev/urandom", "/dev/random")
+
+	bunPath, err := ResolvePath(cfg.BunBinary)
+	if err != nil {
+		return policy, fmt.Errorf("failed to resolve bun path: %w", err)
+	}
+	read = append(read, filepath.Dir(bunPath))
+
+	scriptPath, err := ResolvePath(cfg.ScriptPath)
+	if err != nil {
+		return policy, fmt.Errorf("failed to resolve script path: %w", err)
+	}
+	read = append(read, filepath.Dir(scriptPath))
+
+	if cfg.NodeModules != "" {
+		nodeModulesPath, err := ResolvePath(cfg.NodeModules)
+		if err != nil {
+			return policy, fmt.Errorf("failed to resolve node_modules: %w", err)
+		}
+		read = append(read, filepath.Dir(nodeModulesPath))
+	}
+
+	for _, path := range cfg.ReadablePaths {
+		if resolved, err := ResolvePath(path); err == nil {
+			read = append(read, resolved)
+		}
+	}
+
+	write := []string{"/dev/null", tmpDir}
+	for _, path := range cfg.WritablePaths {
+		resolved, err := ResolvePath(path)
+		if err != nil {
+			continue
+		}
+		// Create the path if it doesn't exist, as Landlock rules need one
+		if _, err := os.Stat(resolved); os.IsNotExist(err) {
+			_ = os.MkdirAll(resolved, 0755)
+		}
+		write = append(write, resolved)
+	}
+
+	// Paths missing on this host are left out
+	for _, path := range read {
+		if _, err := os.Stat(path); err == nil {
+			policy.ReadPaths = append(policy.ReadPaths, path)
+		}
+	}
+	for _, path := range write {
+		if _, err := os.Stat(path); err == nil {
+			policy.WritePaths = append(policy.WritePaths, path)
+		}
+	}
+
+	if cfg.Network {
+		ports := []int{cfg.ProxyPort, cfg.ProxySOCKS5Port}
+		if bridged {
+			ports = append([]int{SandboxBridgePort}, cfg.InterceptPorts...)
+		}
+		for _, port := range ports {
+			if port > 0 {
+				policy.ConnectPorts = append(policy.ConnectPorts, port)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// RunLandlockHelper is the LandlockHelper command: it restricts itself to the
+// policy in args[0] and replaces itself with the command after "--". Errors
+// before the command starts are written to file descriptor 3.
+func RunLandlockHelper(args []string) error {
+	err := fmt.Errorf("usage: buns %s <policy> -- <command>", LandlockHelper)
+	if len(args) >= 3 && args[1] == "--" {
+		var policy LandlockPolicy
+		err = json.Unmarshal([]byte(args[0]), &policy)
+		if err == nil {
+			err = execLandlocked(policy, args[2:])
+		}
+	}
+
+	// Only reached when the command couldn't be started
+	reportSetupError(err)
+	return err
+}
@@ -0,0 +1,383 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock ABI versions that brought the features the sandbox uses
+const (
+	landlockReferABI    = 2 // LANDLOCK_ACCESS_FS_REFER
+	landlockTruncateABI = 3 // LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockNetworkABI  = 4 // TCP bind and connect rules
+	landlockIoctlABI    = 5 // LANDLOCK_ACCESS_FS_IOCTL_DEV
+	landlockScopeABI    = 6 // Signal and abstract socket scoping
+)
+
+// Not yet in x/sys
+const landlockRuleNetPort = 2
+
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
+
+// Rights that apply to files rather than directories
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+	unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+const landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+// landlockABI returns the kernel's Landlock ABI version, or 0 without Landlock
+func landlockABI() int {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// landlockFSAccess returns the filesystem rights the ABI can restrict
+func landlockFSAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= landlockReferABI {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= landlockTruncateABI {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= landlockIoctlABI {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return access
+}
+
+// seccompAvailable reports whether the kernel supports seccomp filters
+func seccompAvailable() bool {
+	_, err := unix.PrctlRetInt(unix.PR_GET_SECCOMP, 0, 0, 0, 0)
+	return err == nil
+}
+
+// userNamespacesAvailable reports whether unprivileged processes may create
+// user namespaces, which distributions can turn off
+func userNamespacesAvailable() bool {
+	settings := map[string]string{
+		"/proc/sys/user/max_user_namespaces":                     "0",
+		"/proc/sys/kernel/unprivileged_userns_clone":             "0", // Debian
+		"/proc/sys/kernel/apparmor_restrict_unprivileged_userns": "1", // Ubuntu
+	}
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return false
+	}
+	for file, disabled := range settings {
+		if data, err := os.ReadFile(file); err == nil && strings.TrimSpace(string(data)) == disabled {
+			return false
+		}
+	}
+	return true
+}
+
+// isolateNetwork starts cmd in new user and network namespaces, leaving it
+// no network at all. The user keeps their own IDs.
+func isolateNetwork(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+}
+
+// execLandlocked restricts the current thread to policy and replaces the
+// process with command. Landlock and seccomp apply per thread and carry over
+// to the program exec'd from it.
+func execLandlocked(policy LandlockPolicy, command []string) error {
+	runtime.LockOSThread()
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+
+	// The error pipe closes once the command starts
+	if f := setupErrorFile(); f != nil {
+		syscall.CloseOnExec(int(f.Fd()))
+	}
+
+	if err := restrictThread(policy); err != nil {
+		return err
+	}
+
+	if err := syscall.Exec(path, command, os.Environ()); err != nil {
+		return fmt.Errorf("failed to run %s: %w", path, err)
+	}
+	return nil
+}
+
+// restrictThread restricts the current thread, and the processes it starts,
+// to policy
+func restrictThread(policy LandlockPolicy) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if err := restrictLandlock(policy, landlockABI()); err != nil {
+		return err
+	}
+	return installSeccomp(seccompFilter(policy))
+}
+
+// restrictLandlock enforces policy's filesystem and network rules
+func restrictLandlock(policy LandlockPolicy, abi int) error {
+	if abi < 1 {
+		return fmt.Errorf("landlock is not supported by this kernel")
+	}
+
+	fsAccess := landlockFSAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: fsAccess}
+	if policy.RestrictNetwork && abi >= landlockNetworkABI {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	if abi >= landlockScopeABI {
+		// No signals to processes outside the sandbox
+		attr.Scoped = unix.LANDLOCK_SCOPE_SIGNAL | unix.LANDLOCK_SCOPE_ABSTRACT_UNIX_SOCKET
+	}
+
+	// Older kernels reject the newer fields, so pass only what the ABI knows
+	size := unsafe.Sizeof(attr)
+	switch {
+	case abi < landlockNetworkABI:
+		size = unsafe.Offsetof(attr.Access_net)
+	case abi < landlockScopeABI:
+		size = unsafe.Offsetof(attr.Scoped)
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), size, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer func() { _ = unix.Close(ruleset) }()
+
+	for _, path := range policy.ReadPaths {
+		if err := addLandlockPath(ruleset, path, landlockReadAccess&fsAccess); err != nil {
+			return err
+		}
+	}
+	for _, path := range policy.WritePaths {
+		if err := addLandlockPath(ruleset, path, fsAccess); err != nil {
+			return err
+		}
+	}
+
+	if attr.Access_net != 0 {
+		for _, port := range policy.ConnectPorts {
+			rule := landlockNetPortAttr{allowedAccess: unix.LANDLOCK_ACCESS_NET_CONNECT_TCP, port: uint64(port)}
+			_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), landlockRuleNetPort, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+			if errno != 0 {
+				return fmt.Errorf("failed to allow port %d: %w", port, errno)
+			}
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockPath allows access beneath path. Files only take file rights.
+func addLandlockPath(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		// Paths can disappear between building the policy and here
+		return nil
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err == nil && st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to allow %s: %w", path, errno)
+	}
+	return nil
+}
+
+// Syscalls a script never needs and that widen what it can reach: kernel
+// modules and keyrings, mounts and namespaces, tracing other processes, BPF,
+// and io_uring, whose operations bypass seccomp
+var seccompDenied = []uint32{
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_REBOOT,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_QUOTACTL,
+	unix.SYS_ACCT,
+	unix.SYS_SYSLOG,
+	unix.SYS_IO_URING_SETUP,
+	unix.SYS_IO_URING_ENTER,
+	unix.SYS_IO_URING_REGISTER,
+}
+
+// Namespace flags refused to clone
+const cloneNewFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// Architectures the seccomp filter accepts, by GOARCH
+var auditArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+// Offsets into struct seccomp_data
+const (
+	seccompNr   = 0
+	seccompArch = 4
+	seccompArg0 = 16 // Low 32 bits, as both architectures are little-endian
+	seccompArg1 = 24
+)
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter builds a BPF program that fails the denied syscalls with
+// EPERM and, when policy restricts the network, every socket other than TCP.
+// Unix sockets are refused either way, as Landlock doesn't cover connecting
+// to them; socket pairs still work.
+func seccompFilter(policy LandlockPolicy) []unix.SockFilter {
+	const (
+		load  = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge   = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset  = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		and   = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+		ret   = unix.BPF_RET | unix.BPF_K
+		allow = unix.SECCOMP_RET_ALLOW
+		eperm = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	)
+
+	// Other architectures' syscall numbers mean something else, so kill
+	filter := []unix.SockFilter{
+		bpfStmt(load, seccompArch),
+		bpfJump(jeq, auditArch[runtime.GOARCH], 1, 0),
+		bpfStmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		bpfStmt(load, seccompNr),
+	}
+	if runtime.GOARCH == "amd64" {
+		// x32 syscalls on x86-64
+		filter = append(filter,
+			bpfJump(jge, 0x40000000, 0, 1),
+			bpfStmt(ret, eperm),
+		)
+	}
+
+	for _, nr := range seccompDenied {
+		filter = append(filter,
+			bpfJump(jeq, nr, 0, 1),
+			bpfStmt(ret, eperm),
+		)
+	}
+
+	// clone3 passes its flags in memory the filter can't read; C libraries
+	// fall back to clone on ENOSYS
+	filter = append(filter,
+		bpfJump(jeq, unix.SYS_CLONE3, 0, 1),
+		bpfStmt(ret, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		bpfJump(jeq, unix.SYS_CLONE, 0, 4),
+		bpfStmt(load, seccompArg0),
+		bpfJump(jset, cloneNewFlags, 0, 1),
+		bpfStmt(ret, eperm),
+		bpfStmt(ret, allow),
+	)
+
+	var socket []unix.SockFilter
+	socket = append(socket,
+		bpfStmt(load, seccompArg0),
+		bpfJump(jeq, unix.AF_UNIX, 0, 1),
+		bpfStmt(ret, eperm),
+	)
+	if policy.RestrictNetwork {
+		socket = append(socket,
+			bpfJump(jeq, unix.AF_INET, 1, 0),
+			bpfJump(jeq, unix.AF_INET6, 0, 4),
+			bpfStmt(load, seccompArg1),
+			bpfStmt(and, 0xf), // The type without SOCK_NONBLOCK and SOCK_CLOEXEC
+			bpfJump(jeq, unix.SOCK_STREAM, 0, 1),
+			bpfStmt(ret, allow),
+			bpfStmt(ret, eperm),
+		)
+	} else {
+		socket = append(socket, bpfStmt(ret, allow))
+	}
+	filter = append(filter, bpfJump(jeq, unix.SYS_SOCKET, 0, uint8(len(socket))))
+	filter = append(filter, socket...)
+
+	return append(filter, bpfStmt(ret, allow))
+}
+
+// installSeccomp loads filter for the current thread
+func installSeccomp(filter []unix.SockFilter) error {
+	if _, ok := auditArch[runtime.GOARCH]; !ok {
+		return fmt.Errorf("seccomp filter not supported on %s", runtime.GOARCH)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, 0, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runSeccompFilter runs filter against a syscall, interpreting the few BPF
+// instructions seccompFilter uses
+func runSeccompFilter(t *testing.T, filter []unix.SockFilter, arch, nr uint32, args ...uint32) uint32 {
+	t.Helper()
+	data := map[uint32]uint32{seccompNr: nr, seccompArch: arch}
+	for i, arg := range args {
+		data[seccompArg0+8*uint32(i)] = arg
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = data[ins.K]
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= ins.K
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K,
+			unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			var match bool
+			switch ins.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				match = acc == ins.K
+			case unix.BPF_JGE:
+				match = acc >= ins.K
+			case unix.BPF_JSET:
+				match = acc&ins.K != 0
+			}
+			if match {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatal("filter ran off the end")
+	return 0
+}
+
+func TestSeccompFilter(t *testing.T) {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no seccomp filter for %s", runtime.GOARCH)
+	}
+	eperm := unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	enosys := unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)
+	allow := uint32(unix.SECCOMP_RET_ALLOW)
+
+	restricted := seccompFilter(LandlockPolicy{RestrictNetwork: true})
+	open := seccompFilter(LandlockPolicy{})
+
+	type test struct {
+		name   string
+		filter []unix.SockFilter
+		arch   uint32
+		nr     uint32
+		args   []uint32
+		want   uint32
+	}
+	tests := []test{
+		{"other syscalls", restricted, arch, unix.SYS_READ, nil, allow},
+		{"denied syscall", restricted, arch, unix.SYS_PTRACE, nil, eperm},
+		{"other architecture", restricted, arch + 1, unix.SYS_READ, nil, unix.SECCOMP_RET_KILL_PROCESS},
+		{"clone3", restricted, arch, unix.SYS_CLONE3, nil, enosys},
+		{"clone for a thread", restricted, arch, unix.SYS_CLONE, []uint32{unix.CLONE_VM | unix.CLONE_THREAD}, allow},
+		{"clone into a namespace", restricted, arch, unix.SYS_CLONE, []uint32{unix.CLONE_NEWUSER}, eperm},
+		{"unix socket", open, arch, unix.SYS_SOCKET, []uint32{unix.AF_UNIX, unix.SOCK_STREAM}, eperm},
+		{"any socket unrestricted", open, arch, unix.SYS_SOCKET, []uint32{unix.AF_INET, unix.SOCK_DGRAM}, allow},
+		{"TCP socket", restricted, arch, unix.SYS_SOCKET, []uint32{unix.AF_INET6, unix.SOCK_STREAM | unix.SOCK_CLOEXEC}, allow},
+		{"UDP socket", restricted, arch, unix.SYS_SOCKET, []uint32{unix.AF_INET, unix.SOCK_DGRAM}, eperm},
+		{"netlink socket", restricted, arch, unix.SYS_SOCKET, []uint32{unix.AF_NETLINK, unix.SOCK_RAW}, eperm},
+		{"socket pair", restricted, arch, unix.SYS_SOCKETPAIR, []uint32{unix.AF_UNIX, unix.SOCK_STREAM}, allow},
+	}
+	if runtime.GOARCH == "amd64" {
+		tests = append(tests, test{"x32 syscall", restricted, arch, 0x40000000 | unix.SYS_PTRACE, nil, eperm})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runSeccompFilter(t, tt.filter, tt.arch, tt.nr, tt.args...); got != tt.want {
+				t.Errorf("action = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+const landlockNetworkABI = 4
+
+// landlockABI reports no Landlock, as it is Linux only
+func landlockABI() int {
+	return 0
+}
+
+func seccompAvailable() bool {
+	return false
+}
+
+func userNamespacesAvailable() bool {
+	return false
+}
+
+func isolateNetwork(cmd *exec.Cmd) {}
+
+func execLandlocked(policy LandlockPolicy, command []string) error {
+	return errors.New("landlock is only available on Linux")
+}
+
+func restrictThread(policy LandlockPolicy) error {
+	return errors.New("landlock is only available on Linux")
+}
@@ -0,0 +1,224 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eddmann/buns/internal/proxy"
+)
+
+// TestMain lets the test binary act as the helper commands, which sandboxes
+// run by re-executing buns
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 {
+		helpers := map[string]func([]string) error{
+			LandlockHelper: RunLandlockHelper,
+			BridgeHelper:   RunBridgeHelper,
+		}
+		if run, ok := helpers[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				os.Exit(1)
+			}
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func TestBuildLandlockPolicy(t *testing.T) {
+	bunDir := t.TempDir()
+	scriptDir := t.TempDir()
+	depsDir := t.TempDir()
+	extraDir := t.TempDir()
+	tmpDir := t.TempDir()
+	writeFiles(t, bunDir, map[string]string{"bun": ""})
+	writeFiles(t, scriptDir, map[string]string{"script.ts": ""})
+	newDir := filepath.Join(t.TempDir(), "output")
+
+	cfg := &Config{
+		Network:         true,
+		ProxyPort:       8080,
+		ProxySOCKS5Port: 1080,
+		BunBinary:       filepath.Join(bunDir, "bun"),
+		ScriptPath:      filepath.Join(scriptDir, "script.ts"),
+		NodeModules:     filepath.Join(depsDir, "node_modules"),
+		ReadablePaths:   []string{extraDir, "/nonexistent/path"},
+		WritablePaths:   []string{newDir},
+	}
+	policy, err := buildLandlockPolicy(cfg, tmpDir, false)
+	if err != nil {
+		t.Fatalf("buildLandlockPolicy() error = %v", err)
+	}
+
+	for _, path := range []string{bunDir, scriptDir, depsDir, extraDir} {
+		if !slices.Contains(policy.ReadPaths, path) {
+			t.Errorf("ReadPaths = %v, missing %s", policy.ReadPaths, path)
+		}
+	}
+	if slices.Contains(policy.ReadPaths, "/nonexistent/path") {
+		t.Error("missing paths should be left out")
+	}
+	for _, path := range []string{tmpDir, newDir} {
+		if !slices.Contains(policy.WritePaths, path) {
+			t.Errorf("WritePaths = %v, missing %s", policy.WritePaths, path)
+		}
+	}
+	if _, err := os.Stat(newDir); err != nil {
+		t.Error("writable path should be created")
+	}
+	if !policy.RestrictNetwork {
+		t.Error("network should always be restricted")
+	}
+	if !slices.Equal(policy.ConnectPorts, []int{8080, 1080}) {
+		t.Errorf("ConnectPorts = %v, want the proxy ports", policy.ConnectPorts)
+	}
+
+	cfg.InterceptPorts = []int{80, 443}
+	policy, err = buildLandlockPolicy(cfg, tmpDir, true)
+	if err != nil {
+		t.Fatalf("buildLandlockPolicy() error = %v", err)
+	}
+	if !slices.Equal(policy.ConnectPorts, []int{SandboxBridgePort, 80, 443}) {
+		t.Errorf("ConnectPorts = %v, want the bridge's and intercepted ports", policy.ConnectPorts)
+	}
+
+	cfg.Network = false
+	policy, err = buildLandlockPolicy(cfg, tmpDir, false)
+	if err != nil {
+		t.Fatalf("buildLandlockPolicy() error = %v", err)
+	}
+	if len(policy.ConnectPorts) != 0 {
+		t.Errorf("ConnectPorts = %v, want none offline", policy.ConnectPorts)
+	}
+}
+
+func TestRunLandlockHelper_usage(t *testing.T) {
+	if err := RunLandlockHelper([]string{"{}", "sh"}); err == nil {
+		t.Error("expected an error without --")
+	}
+}
+
+func TestLandlock_Execute(t *testing.T) {
+	sb := &Landlock{}
+	if !sb.Available() {
+		t.Skip("Landlock isn't available")
+	}
+
+	// A fake bun that runs the script with sh: "bun run script.sh" becomes
+	// "sh script.sh"
+	bunDir := t.TempDir()
+	writeFiles(t, bunDir, map[string]string{"bun": "#!/bin/sh\nshift\nexec /bin/sh \"$@\"\n"})
+	fakeBun := filepath.Join(bunDir, "bun")
+	if err := os.Chmod(fakeBun, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	secretDir := t.TempDir()
+	writeFiles(t, secretDir, map[string]string{"secret": "hunter2"})
+	outputDir := t.TempDir()
+
+	run := func(t *testing.T, script string, cfg *Config) *Result {
+		t.Helper()
+		scriptDir := t.TempDir()
+		writeFiles(t, scriptDir, map[string]string{"script.sh": script})
+		cfg.BunBinary = fakeBun
+		cfg.ScriptPath = filepath.Join(scriptDir, "script.sh")
+		cfg.Env = append(cfg.Env, "SECRET="+filepath.Join(secretDir, "secret"), "OUTPUT="+outputDir)
+		result, err := sb.Execute(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		return result
+	}
+
+	t.Run("reads outside the policy are denied", func(t *testing.T) {
+		result := run(t, `cat "$SECRET"`, &Config{})
+		if result.ExitCode == 0 || strings.Contains(result.Stdout, "hunter2") {
+			t.Errorf("secret was readable: exit %d, %q", result.ExitCode, result.Stdout)
+		}
+	})
+
+	t.Run("allowed paths are accessible", func(t *testing.T) {
+		result := run(t, `cat "$SECRET" && echo done > "$OUTPUT/result" && echo temp > "$TMPDIR/file"`, &Config{
+			ReadablePaths: []string{secretDir},
+			WritablePaths: []string{outputDir},
+		})
+		if result.ExitCode != 0 {
+			t.Fatalf("exit code = %d: %s", result.ExitCode, result.Stderr)
+		}
+		if result.Stdout != "hunter2" {
+			t.Errorf("stdout = %q", result.Stdout)
+		}
+		if got := readFile(t, filepath.Join(outputDir, "result")); got != "done\n" {
+			t.Errorf("output = %q", got)
+		}
+	})
+
+	t.Run("writes outside the policy are denied", func(t *testing.T) {
+		result := run(t, `echo x > "$OUTPUT/denied"`, &Config{})
+		if result.ExitCode == 0 {
+			t.Error("write should fail")
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "denied")); err == nil {
+			t.Error("file was written")
+		}
+	})
+
+	t.Run("network access only through the proxy", func(t *testing.T) {
+		if !userNamespacesAvailable() {
+			t.Skip("user namespaces aren't available")
+		}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello")
+		}))
+		defer upstream.Close()
+		mgr, err := proxy.NewManager(proxy.ManagerConfig{AllowedHosts: []string{"127.0.0.1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mgr.Stop()
+
+		// Stands in for a server elsewhere listening on one of the proxy's
+		// port numbers, which Landlock's rules alone would let through
+		other, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = other.Close() }()
+		otherPort := other.Addr().(*net.TCPAddr).Port
+
+		script := fmt.Sprintf(`exec /bin/bash -c '
+if (exec 3<>/dev/tcp/127.0.0.1/%d) 2>/dev/null; then echo reached other; fi
+exec 3<>/dev/tcp/127.0.0.1/%d && printf "GET %s/ HTTP/1.0\r\n\r\n" >&3 && cat <&3'`, otherPort, SandboxBridgePort, upstream.URL)
+		result := run(t, script, &Config{Network: true, ProxySocketPath: mgr.SocketPath(), ProxyPort: otherPort})
+		if result.ExitCode != 0 {
+			t.Fatalf("exit code = %d: %s", result.ExitCode, result.Stderr)
+		}
+		if strings.Contains(result.Stdout, "reached other") || !strings.HasSuffix(result.Stdout, "hello") {
+			t.Errorf("stdout = %q, want only the proxied response", result.Stdout)
+		}
+	})
+
+	t.Run("setup errors are reported", func(t *testing.T) {
+		scriptDir := t.TempDir()
+		writeFiles(t, scriptDir, map[string]string{"script.sh": "", "bun": ""}) // Not executable
+		result, err := sb.Execute(context.Background(), &Config{
+			BunBinary:  filepath.Join(scriptDir, "bun"),
+			ScriptPath: filepath.Join(scriptDir, "script.sh"),
+		})
+		if err == nil {
+			t.Fatal("expected a setup error")
+		}
+		if result.Termination != TerminationSetupFailed {
+			t.Errorf("termination = %s, want setup-failed", result.Termination)
+		}
+	})
+}
@@ -31,8 +31,12 @@ func (l *LinuxNetwork) Execute(ctx context.Context, cfg *Config) (*Result, error
 		// Full network isolation - no proxy needed
 		cmd = l.buildOfflineCommand(ctx, cfg)
 	} else if cfg.ProxySocketPath != "" {
-		// Network through proxy with socat bridge
-		cmd = l.buildProxyCommand(ctx, cfg)
+		// Network through proxy with buns' bridge
+		var err error
+		cmd, err = l.buildProxyCommand(ctx, cfg)
+		if err != nil {
+			return setupFailed(err)
+		}
 	} else {
 		// No isolation needed, fall back to direct execution
 		args := BuildBunArgs(cfg)
@@ -45,11 +49,24 @@ func (l *LinuxNetwork) Execute(ctx context.Context, cfg *Config) (*Result, error
 	// Add NODE_PATH
 	cmd.Env = BuildEnvWithNodePath(cmd.Env, cfg.NodeModules)
 
-	// Add memory limit hint (soft limit only - unshare doesn't support rlimits)
+	// Add memory limit hint, making Bun's GC work within the cgroup's limit
 	cmd.Env = BuildEnvWithMemoryLimit(cmd.Env, cfg.MemoryMB)
 
-	err := cmd.Run()
-	return BuildResult(err, cfg, stdout, stderr)
+	// The bridge helper reports setup errors on a pipe
+	setupErr := func() error { return nil }
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		cmd.Env = bridgeEnv(cmd.Env)
+		var err error
+		if setupErr, err = setupErrorPipe(cmd); err != nil {
+			return setupFailed(err)
+		}
+	}
+
+	result, err := runInCgroup(ctx, cmd, cfg, stdout, stderr)
+	if err := setupErr(); err != nil {
+		return setupFailed(err)
+	}
+	return result, err
 }
 
 // buildOfflineCommand creates a command for completely offline execution
@@ -64,12 +81,15 @@ func (l *LinuxNetwork) buildOfflineCommand(ctx context.Context, cfg *Config) *ex
 }
 
 // buildProxyCommand creates a command with network isolation but proxy access
-func (l *LinuxNetwork) buildProxyCommand(ctx context.Context, cfg *Config) *exec.Cmd {
-	bunCmd := BuildBunCommand(cfg)
-	script := BuildSocatBridgeCommand(cfg.ProxySocketPath, bunCmd)
+func (l *LinuxNetwork) buildProxyCommand(ctx context.Context, cfg *Config) (*exec.Cmd, error) {
+	self, err := bunsExecutable()
+	if err != nil {
+		return nil, err
+	}
 
-	// Use unshare to create isolated network, then run the bridge script
-	args := []string{"--net", "--map-root-user", "sh", "-c", script}
+	// Use unshare to create isolated network, then run bun under buns' bridge helper
+	args := []string{"--net", "--map-root-user", "--"}
+	args = append(args, BridgeArgs(self, cfg.ProxySocketPath, cfg.InterceptPorts, BuildBunArgs(cfg))...)
 
-	return exec.CommandContext(ctx, "unshare", args...)
+	return exec.CommandContext(ctx, "unshare", args...), nil
 }
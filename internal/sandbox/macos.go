@@ -35,13 +35,13 @@ func (m *MacOS) Execute(ctx context.Context, cfg *Config) (*Result, error) {
 	// Write profile to temp file
 	profileFile, err := os.CreateTemp("", "buns-sandbox-*.sb")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create sandbox profile: %w", err)
+		return setupFailed(fmt.Errorf("failed to create sandbox profile: %w", err))
 	}
 	defer func() { _ = os.Remove(profileFile.Name()) }()
 
 	if _, err := profileFile.WriteString(profile); err != nil {
 		_ = profileFile.Close()
-		return nil, fmt.Errorf("failed to write sandbox profile: %w", err)
+		return setupFailed(fmt.Errorf("failed to write sandbox profile: %w", err))
 	}
 	_ = profileFile.Close()
 
@@ -61,8 +61,7 @@ func (m *MacOS) Execute(ctx context.Context, cfg *Config) (*Result, error) {
 
 	cmd.Dir = cfg.WorkDir
 
-	err = cmd.Run()
-	return BuildResult(err, cfg, stdout, stderr)
+	return RunCommand(ctx, cmd, cfg, stdout, stderr)
 }
 
 // generateProfile creates a minimal Seatbelt sandbox profile.
@@ -35,13 +35,13 @@ func (m *MacOSNetwork) Execute(ctx context.Context, cfg *Config) (*Result, error
 	// Write profile to temp file
 	profileFile, err := os.CreateTemp("", "buns-network-*.sb")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create sandbox profile: %w", err)
+		return setupFailed(fmt.Errorf("failed to create sandbox profile: %w", err))
 	}
 	defer func() { _ = os.Remove(profileFile.Name()) }()
 
 	if _, err := profileFile.WriteString(profile); err != nil {
 		_ = profileFile.Close()
-		return nil, fmt.Errorf("failed to write sandbox profile: %w", err)
+		return setupFailed(fmt.Errorf("failed to write sandbox profile: %w", err))
 	}
 	_ = profileFile.Close()
 
@@ -59,8 +59,7 @@ func (m *MacOSNetwork) Execute(ctx context.Context, cfg *Config) (*Result, error
 	// Add memory limit hint (soft limit only - Seatbelt doesn't support resource limits)
 	cmd.Env = BuildEnvWithMemoryLimit(cmd.Env, cfg.MemoryMB)
 
-	err = cmd.Run()
-	return BuildResult(err, cfg, stdout, stderr)
+	return RunCommand(ctx, cmd, cfg, stdout, stderr)
 }
 
 // generateProfile creates a Seatbelt profile that only restricts network.
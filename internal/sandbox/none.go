@@ -57,6 +57,5 @@ func (n *None) Execute(ctx context.Context, cfg *Config) (*Result, error) {
 
 	cmd.Dir = cfg.WorkDir
 
-	err := cmd.Run()
-	return BuildResult(err, cfg, &stdout, &stderr)
+	return RunCommand(ctx, cmd, cfg, &stdout, &stderr)
 }
@@ -3,6 +3,7 @@ package sandbox
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,9 +30,19 @@ func (n *Nsjail) Available() bool {
 
 // Execute runs the script within nsjail sandbox
 func (n *Nsjail) Execute(ctx context.Context, cfg *Config) (*Result, error) {
-	args, err := n.buildArgs(cfg)
+	// Through the bridge, the script resolves names with buns rather than the host
+	var resolvConf string
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		var err error
+		if resolvConf, err = writeResolvConf(); err != nil {
+			return setupFailed(err)
+		}
+		defer func() { _ = os.Remove(resolvConf) }()
+	}
+
+	args, err := n.buildArgs(cfg, resolvConf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build nsjail args: %w", err)
+		return setupFailed(fmt.Errorf("failed to build nsjail args: %w", err))
 	}
 
 	cmd := exec.CommandContext(ctx, "nsjail", args...)
@@ -42,12 +53,28 @@ func (n *Nsjail) Execute(ctx context.Context, cfg *Config) (*Result, error) {
 	// Add NODE_PATH
 	cmd.Env = BuildEnvWithNodePath(cmd.Env, cfg.NodeModules)
 
-	err = cmd.Run()
-	return BuildResult(err, cfg, stdout, stderr)
+	// The bridge helper reports setup errors on a pipe
+	setupErr := func() error { return nil }
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		if setupErr, err = setupErrorPipe(cmd); err != nil {
+			return setupFailed(err)
+		}
+	}
+
+	// nsjail's rlimits are per process, and RLIMIT_NPROC counts every
+	// process of the user the script runs as, so the process limit and CPU
+	// bandwidth come from a cgroup, as under bubblewrap
+	result, err := runInCgroup(ctx, cmd, cfg, stdout, stderr)
+	if err := setupErr(); err != nil {
+		return setupFailed(err)
+	}
+	signalFromExitStatus(result)
+	return result, err
 }
 
-// buildArgs constructs nsjail command arguments
-func (n *Nsjail) buildArgs(cfg *Config) ([]string, error) {
+// buildArgs constructs nsjail command arguments. resolvConf, if set, is
+// mounted in place of the host's /etc/resolv.conf.
+func (n *Nsjail) buildArgs(cfg *Config, resolvConf string) ([]string, error) {
 	var args []string
 
 	// Mode: once (run once and exit)
@@ -62,7 +89,10 @@ func (n *Nsjail) buildArgs(cfg *Config) ([]string, error) {
 
 	// Resource limits
 	if cfg.Timeout > 0 {
-		args = append(args, "--time_limit", strconv.Itoa(int(cfg.Timeout.Seconds())))
+		// A backstop only: buns' terminator sends SIGTERM at the timeout and
+		// SIGKILL after the grace period, which nsjail must not cut short
+		limit := cfg.Timeout + cfg.GracePeriod
+		args = append(args, "--time_limit", strconv.Itoa(int(math.Ceil(limit.Seconds()))))
 	}
 	if cfg.MemoryMB > 0 {
 		args = append(args, "--rlimit_as", strconv.Itoa(cfg.MemoryMB))
@@ -75,17 +105,12 @@ func (n *Nsjail) buildArgs(cfg *Config) ([]string, error) {
 	args = append(args,
 		"--rlimit_fsize", "50", // Max file size 50MB
 		"--rlimit_nofile", "128", // Max open files
-		"--rlimit_nproc", "10", // Max processes
 	)
 
-	// Network isolation
+	// Network isolation: the script always gets an empty network namespace,
+	// with the proxy reachable only through buns' bridge helper
 	if !cfg.Network {
-		// Full network isolation
 		args = append(args, "--clone_newnet")
-	} else if cfg.ProxySocketPath != "" {
-		// Need network access through proxy
-		// Disable network namespace cloning to allow proxy access
-		args = append(args, "--disable_clone_newnet")
 	}
 
 	// System directories (read-only)
@@ -133,6 +158,10 @@ func (n *Nsjail) buildArgs(cfg *Config) ([]string, error) {
 			"/etc/nsswitch.conf",
 		}
 		for _, path := range dnsFiles {
+			if path == "/etc/resolv.conf" && resolvConf != "" {
+				args = append(args, "-R", resolvConf+":"+path)
+				continue
+			}
 			if _, err := os.Stat(path); err == nil {
 				args = append(args, "-R", path)
 			}
@@ -216,13 +245,29 @@ func (n *Nsjail) buildArgs(cfg *Config) ([]string, error) {
 	env := FilterEnv(cfg.AllowedEnvVars)
 	env = append(env, cfg.Env...)
 	env = BuildEnvWithNodePath(env, cfg.NodeModules)
+
+	// Add the command to run
+	// If we need network through proxy, mount the proxy socket and buns, and
+	// run bun under buns' bridge helper
+	command := BuildBunArgs(cfg)
+	if cfg.Network && cfg.ProxySocketPath != "" {
+		self, err := bunsExecutable()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-R", cfg.ProxySocketPath+":/tmp/proxy.sock", "-R", self)
+		// Keep the bridge helper's error pipe open, and the capabilities it
+		// needs to route every address to itself to intercept connections
+		args = append(args, "--pass_fd", "3", "--cap", "CAP_NET_ADMIN", "--cap", "CAP_NET_BIND_SERVICE")
+		env = bridgeEnv(env)
+		command = BridgeArgs(self, "/tmp/proxy.sock", cfg.InterceptPorts, command)
+	}
+
 	for _, e := range env {
 		args = append(args, "-E", e)
 	}
-
-	// Add the command to run
 	args = append(args, "--")
-	args = append(args, BuildBunArgs(cfg)...)
+	args = append(args, command...)
 
 	return args, nil
 }
@@ -2,8 +2,10 @@ package sandbox
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
 	"runtime"
+	"strings"
 )
 
 // Sandbox is the interface for script execution isolation
@@ -26,6 +28,11 @@ type Result struct {
 	ExitCode int
 	Stdout   string
 	Stderr   string
+
+	Termination Termination // Why the run ended
+	Signal      int         // Signal that killed the script, for TerminationSignal
+
+	Usage Usage // Resources the run used
 }
 
 // Detect returns the best available sandbox for the platform
@@ -47,7 +54,8 @@ func detectFullSandbox() Sandbox {
 			return sb
 		}
 	case "linux":
-		// Try bubblewrap first, then nsjail
+		// Try bubblewrap first, then nsjail, then Landlock, which needs no
+		// binaries but isolates less
 		bwrap := &Bubblewrap{}
 		if bwrap.Available() {
 			return bwrap
@@ -56,6 +64,10 @@ func detectFullSandbox() Sandbox {
 		if nsjail.Available() {
 			return nsjail
 		}
+		landlock := &Landlock{}
+		if landlock.Available() {
+			return landlock
+		}
 	}
 	return &None{}
 }
@@ -77,6 +89,35 @@ func detectNetworkSandbox() Sandbox {
 	return &None{}
 }
 
+// Backends are the sandboxes that can be chosen by name with
+// --sandbox-backend, in the order Detect prefers them on Linux
+var Backends = []string{"bwrap", "nsjail", "landlock", "unshare"}
+
+// Backend returns the sandbox with a name from Backends
+func Backend(name string) (Sandbox, error) {
+	switch name {
+	case "bwrap":
+		return &Bubblewrap{}, nil
+	case "nsjail":
+		return &Nsjail{}, nil
+	case "landlock":
+		return &Landlock{}, nil
+	case "unshare":
+		return &LinuxNetwork{}, nil
+	}
+	return nil, fmt.Errorf("unknown sandbox backend '%s' (expected %s)", name, strings.Join(Backends, ", "))
+}
+
+// FullIsolation reports whether sb isolates the filesystem and processes, not
+// just the network
+func FullIsolation(sb Sandbox) bool {
+	switch sb.(type) {
+	case *LinuxNetwork, *MacOSNetwork, *None:
+		return false
+	}
+	return true
+}
+
 // commandExists checks if a command is available in PATH
 func commandExists(name string) bool {
 	_, err := exec.LookPath(name)
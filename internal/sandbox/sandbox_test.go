@@ -1,8 +1,15 @@
 package sandbox
 
 import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestDetect_fullSandbox(t *testing.T) {
@@ -28,8 +35,8 @@ func TestDetect_fullSandbox(t *testing.T) {
 			t.Errorf("expected macos or none sandbox on darwin, got %s", sb.Name())
 		}
 	case "linux":
-		// On Linux, should return bubblewrap, nsjail, or none
-		validNames := map[string]bool{"bubblewrap": true, "nsjail": true, "none": true}
+		// On Linux, should return bubblewrap, nsjail, landlock, or none
+		validNames := map[string]bool{"bubblewrap": true, "nsjail": true, "landlock": true, "none": true}
 		if !validNames[sb.Name()] {
 			t.Errorf("unexpected sandbox name on linux: %s", sb.Name())
 		}
@@ -100,3 +107,111 @@ func TestCommandExists(t *testing.T) {
 		t.Error("non-existent command should not exist")
 	}
 }
+
+func TestNsjail_timeLimit(t *testing.T) {
+	for _, tt := range []struct {
+		timeout, grace time.Duration
+		want           string
+	}{
+		{30 * time.Second, 5 * time.Second, "35"},
+		{30 * time.Second, 1500 * time.Millisecond, "32"},
+		{30 * time.Second, 0, "30"},
+	} {
+		cfg := &Config{BunBinary: os.Args[0], ScriptPath: os.Args[0], Timeout: tt.timeout, GracePeriod: tt.grace}
+		args, err := (&Nsjail{}).buildArgs(cfg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// nsjail kills the jail at its limit, which must leave buns' grace period
+		i := slices.Index(args, "--time_limit")
+		if i == -1 || args[i+1] != tt.want {
+			t.Errorf("timeout %v, grace period %v: args = %v, want --time_limit %s", tt.timeout, tt.grace, args, tt.want)
+		}
+	}
+}
+
+func TestNsjail_leaves_the_process_limit_to_the_cgroup(t *testing.T) {
+	// RLIMIT_NPROC would count every process of the nobody user on the host
+	cfg := &Config{BunBinary: os.Args[0], ScriptPath: os.Args[0], MaxProcs: 512}
+	args, err := (&Nsjail{}).buildArgs(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(args, "--rlimit_nproc") {
+		t.Errorf("args = %v, want no --rlimit_nproc", args)
+	}
+}
+
+func TestBackends_directConnectionsBlocked(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the backends are Linux only")
+	}
+
+	// A fake bun that runs the script with bash, for its /dev/tcp
+	bunDir := t.TempDir()
+	writeFiles(t, bunDir, map[string]string{"bun": "#!/bin/sh\nshift\nexec /bin/bash \"$@\"\n"})
+	fakeBun := filepath.Join(bunDir, "bun")
+	if err := os.Chmod(fakeBun, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand-ins for the proxy, on its TCP port and Unix socket, and a
+	// server the script shouldn't reach
+	listen := func(network, address string) net.Listener {
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.Close()
+			}
+		}()
+		return ln
+	}
+	proxyPort := listen("tcp", "127.0.0.1:0").Addr().(*net.TCPAddr).Port
+	directPort := listen("tcp", "127.0.0.1:0").Addr().(*net.TCPAddr).Port
+	socketPath := echoServer(t)
+
+	scriptDir := t.TempDir()
+	writeFiles(t, scriptDir, map[string]string{"script.sh": `
+proxy=${HTTP_PROXY#http://}
+if (exec 3<>"/dev/tcp/${proxy%:*}/${proxy##*:}"); then echo proxy; fi
+if (exec 3<>"/dev/tcp/127.0.0.1/$DIRECT_PORT"); then echo direct; fi
+`})
+
+	for _, name := range Backends {
+		t.Run(name, func(t *testing.T) {
+			sb, _ := Backend(name)
+			if !sb.Available() {
+				t.Skipf("%s isn't available", name)
+			}
+
+			result, err := sb.Execute(context.Background(), &Config{
+				Network:         true,
+				ProxySocketPath: socketPath,
+				ProxyPort:       proxyPort,
+				BunBinary:       fakeBun,
+				ScriptPath:      filepath.Join(scriptDir, "script.sh"),
+				Env: []string{
+					"HTTP_PROXY=http://127.0.0.1:" + strconv.Itoa(proxyPort),
+					"DIRECT_PORT=" + strconv.Itoa(directPort),
+				},
+			})
+			if err != nil {
+				if result != nil && result.Termination == TerminationSetupFailed {
+					t.Skipf("%s can't run here: %v", name, err)
+				}
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if result.Stdout != "proxy\n" {
+				t.Errorf("stdout = %q, want only the proxy reachable (stderr: %s)", result.Stdout, result.Stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ForwardedSignals are relayed from buns to the script's processes
+var ForwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// Wrappers that start the script as a child and take it down with them when
+// they get a signal. Signals for the script skip them, and they exit once the
+// script does. unshare isn't one, as it replaces itself with the script.
+var wrappers = map[string]bool{"bwrap": true, "nsjail": true}
+
+// terminator stops a run whose context is done: SIGTERM to the script's
+// processes first, then SIGKILL to every process once the grace period has
+// passed
+type terminator struct {
+	procs   func() []int // Processes of the run
+	targets func() []int // Processes of the run other than a wrapper
+	grace   time.Duration
+
+	mu     sync.Mutex
+	pids   []int // Processes sent SIGTERM, killed with the rest
+	timer  *time.Timer
+	exited bool
+}
+
+func newTerminator(procs, targets func() []int, grace time.Duration) *terminator {
+	return &terminator{procs: procs, targets: targets, grace: grace}
+}
+
+// cancel is the command's Cancel function
+func (t *terminator) cancel() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.grace <= 0 {
+		signalAll(t.procs(), syscall.SIGKILL)
+		return nil
+	}
+	t.pids = t.targets()
+	signalAll(t.pids, syscall.SIGTERM)
+	t.timer = time.AfterFunc(t.grace, t.kill)
+	return nil
+}
+
+func (t *terminator) kill() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.exited {
+		signalAll(append(t.pids, t.procs()...), syscall.SIGKILL)
+	}
+}
+
+// stop is called once the command has exited, so pids that may be reused
+// aren't signalled
+func (t *terminator) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exited = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// handleCancel makes cmd stop its processes, listed by procs, gracefully when
+// its context is done, rather than killing only its own process. It returns
+// the processes signals for the script go to.
+func handleCancel(cmd *exec.Cmd, procs func() []int, grace time.Duration) (*terminator, func() []int) {
+	targets := procs
+	if wrappers[filepath.Base(cmd.Path)] {
+		targets = func() []int {
+			var pids []int
+			for _, pid := range procs() {
+				if pid != cmd.Process.Pid {
+					pids = append(pids, pid)
+				}
+			}
+			return pids
+		}
+	}
+
+	t := newTerminator(procs, targets, grace)
+	cmd.Cancel = t.cancel
+	return t, targets
+}
+
+// exitErr is the error of cmd's Wait with the context error dropped. Wait
+// reports that for a script that exits successfully after the timeout's
+// SIGTERM, which still ran rather than failing to start.
+func exitErr(cmd *exec.Cmd, err error) error {
+	if cmd.ProcessState != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)) {
+		return nil
+	}
+	return err
+}
+
+// ForwardSignals relays ForwardedSignals sent to buns to the processes procs
+// returns, until the returned function is called. Signals from the terminal
+// (SIGINT and SIGQUIT) already reach processes in buns' own process group, so
+// only processes that have left it, such as those under nsjail, get them.
+func ForwardSignals(procs func() []int) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, ForwardedSignals...)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				forward(procs(), sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func forward(pids []int, sig syscall.Signal) {
+	if sig == syscall.SIGINT || sig == syscall.SIGQUIT {
+		own := syscall.Getpgrp()
+		var others []int
+		for _, pid := range pids {
+			if pgid, err := syscall.Getpgid(pid); err == nil && pgid != own {
+				others = append(others, pid)
+			}
+		}
+		pids = others
+	}
+	signalAll(pids, sig)
+}
+
+func signalAll(pids []int, sig syscall.Signal) {
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, sig)
+	}
+}
+
+// ProcessTree returns a function listing the process with pid and all its
+// descendants
+func ProcessTree(pid int) func() []int {
+	return func() []int {
+		return descendants(pid, parentPIDs())
+	}
+}
+
+// descendants returns root and every process below it in parents, a map of
+// process to parent
+func descendants(root int, parents map[int]int) []int {
+	children := make(map[int][]int)
+	for pid, ppid := range parents {
+		children[ppid] = append(children[ppid], pid)
+	}
+
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
+
+// parentPIDs maps every process to its parent
+func parentPIDs() map[int]int {
+	if runtime.GOOS == "linux" {
+		return procParentPIDs("/proc")
+	}
+
+	parents := make(map[int]int)
+	out, err := exec.Command("ps", "-Ao", "pid=,ppid=").Output()
+	if err != nil {
+		return parents
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			parents[pid] = ppid
+		}
+	}
+	return parents
+}
+
+// procParentPIDs reads process parents from /proc/<pid>/stat
+func procParentPIDs(proc string) map[int]int {
+	parents := make(map[int]int)
+	entries, err := os.ReadDir(proc)
+	if err != nil {
+		return parents
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(proc, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// "pid (comm) state ppid ...", where comm may contain spaces and parentheses
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			parents[pid] = ppid
+		}
+	}
+	return parents
+}
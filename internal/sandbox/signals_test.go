@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitForFile waits for a script to signal it is ready by creating path
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was never created", path)
+}
+
+func TestRunCommand_gracePeriod(t *testing.T) {
+	// The trap is only run once sleep exits, so sleep in the background and wait
+	script := `trap 'echo cleaned > "$DIR/cleanup"; exit 0' TERM; sleep 5 & wait`
+
+	t.Run("SIGTERM lets the script clean up", func(t *testing.T) {
+		dir := t.TempDir()
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		cmd.Env = append(os.Environ(), "DIR="+dir)
+		result, err := RunCommand(ctx, cmd, &Config{GracePeriod: 5 * time.Second}, nil, nil)
+		if err != nil {
+			t.Fatalf("RunCommand() error = %v", err)
+		}
+		if result.Termination != TerminationTimeout {
+			t.Errorf("termination = %s, want timeout", result.Termination)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "cleanup")); err != nil {
+			t.Error("script didn't get to clean up")
+		}
+	})
+
+	t.Run("SIGKILL once the grace period has passed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		cmd := exec.CommandContext(ctx, "sh", "-c", `trap '' TERM; while :; do sleep 0.05; done`)
+		result, err := RunCommand(ctx, cmd, &Config{GracePeriod: 200 * time.Millisecond}, nil, nil)
+		if err != nil {
+			t.Fatalf("RunCommand() error = %v", err)
+		}
+		if result.Termination != TerminationTimeout {
+			t.Errorf("termination = %s, want timeout", result.Termination)
+		}
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("took %v, want the process tree killed after the grace period", elapsed)
+		}
+	})
+}
+
+func TestForwardSignals(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-c", `trap 'echo hup > "$DIR/got"; exit 0' HUP; sleep 5 & touch "$DIR/ready"; wait`)
+	cmd.Env = append(os.Environ(), "DIR="+dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stop := ForwardSignals(ProcessTree(cmd.Process.Pid))
+	defer stop()
+	waitForFile(t, filepath.Join(dir, "ready"))
+
+	// The test binary would die of SIGHUP if it weren't being relayed
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("script failed: %v %s", err, stderr.String())
+		}
+	case <-time.After(3 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("SIGHUP wasn't forwarded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "got")); err != nil {
+		t.Error("script didn't handle the forwarded signal")
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	parents := map[int]int{1: 0, 10: 1, 11: 10, 12: 10, 13: 12, 20: 1}
+	got := descendants(10, parents)
+	sort.Ints(got)
+	if want := []int{10, 11, 12, 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descendants() = %v, want %v", got, want)
+	}
+}
+
+func TestProcParentPIDs(t *testing.T) {
+	proc := t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"1/stat":   "1 (init) S 0 1 1 0 -1\n",
+		"42/stat":  "42 (odd) name) R 1 42 42 0 -1\n",
+		"self/x":   "",
+		"uptime/x": "",
+	})
+
+	got := procParentPIDs(proc)
+	if want := map[int]int{1: 0, 42: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("procParentPIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Termination is why a run ended
+type Termination int
+
+const (
+	TerminationNormal      Termination = iota // The script exited by itself
+	TerminationTimeout                        // Killed when the timeout expired
+	TerminationCPULimit                       // Killed for exceeding the CPU time limit
+	TerminationMemoryLimit                    // Killed for exceeding the memory limit
+	TerminationSignal                         // Killed by a signal, see Result.Signal
+	TerminationSetupFailed                    // The sandbox couldn't be set up, so the script never ran
+)
+
+func (t Termination) String() string {
+	switch t {
+	case TerminationTimeout:
+		return "timeout"
+	case TerminationCPULimit:
+		return "cpu-limit"
+	case TerminationMemoryLimit:
+		return "memory-limit"
+	case TerminationSignal:
+		return "signal"
+	case TerminationSetupFailed:
+		return "setup-failed"
+	default:
+		return "normal"
+	}
+}
+
+// ProcessTermination works out why an exited process ended, returning the
+// signal that killed it for TerminationSignal. ctx is the context the process
+// ran under, whose deadline is the run's timeout.
+func ProcessTermination(ctx context.Context, state *os.ProcessState) (Termination, int) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return TerminationTimeout, 0
+	}
+	if state == nil {
+		return TerminationNormal, 0
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return TerminationNormal, 0
+	}
+	if status.Signal() == syscall.SIGXCPU {
+		// Sent by the kernel once RLIMIT_CPU is used up
+		return TerminationCPULimit, 0
+	}
+	return TerminationSignal, int(status.Signal())
+}
+
+// setupFailed is the result of a run whose sandbox couldn't be set up
+func setupFailed(err error) (*Result, error) {
+	return &Result{ExitCode: -1, Termination: TerminationSetupFailed}, err
+}
+
+// signalFromExitStatus reads the 128+N exit status that bwrap and nsjail use
+// for a script killed by signal N as that signal. A script exiting with such a
+// status itself can't be told apart.
+func signalFromExitStatus(result *Result) {
+	if result == nil || result.Termination != TerminationNormal {
+		return
+	}
+	if signal := result.ExitCode - 128; signal > 0 && signal < 65 {
+		result.Termination = TerminationSignal
+		result.Signal = signal
+		if syscall.Signal(signal) == syscall.SIGXCPU {
+			result.Termination, result.Signal = TerminationCPULimit, 0
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func run(ctx context.Context, t *testing.T, script string) (*Result, error) {
+	t.Helper()
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	return RunCommand(ctx, cmd, &Config{}, &stdout, &stderr)
+}
+
+func TestRunCommand_termination(t *testing.T) {
+	t.Run("normal exit", func(t *testing.T) {
+		result, err := run(context.Background(), t, "exit 3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Termination != TerminationNormal || result.ExitCode != 3 {
+			t.Errorf("termination = %s, exit code = %d", result.Termination, result.ExitCode)
+		}
+	})
+
+	t.Run("signal", func(t *testing.T) {
+		result, err := run(context.Background(), t, "kill -TERM $$")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Termination != TerminationSignal || result.Signal != 15 {
+			t.Errorf("termination = %s, signal = %d", result.Termination, result.Signal)
+		}
+	})
+
+	t.Run("rlimit cpu", func(t *testing.T) {
+		result, err := run(context.Background(), t, "kill -XCPU $$")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Termination != TerminationCPULimit {
+			t.Errorf("termination = %s", result.Termination)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		result, err := run(ctx, t, "sleep 5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Termination != TerminationTimeout {
+			t.Errorf("termination = %s", result.Termination)
+		}
+	})
+
+	t.Run("command missing", func(t *testing.T) {
+		cmd := exec.CommandContext(context.Background(), "/nonexistent/buns-test")
+		result, err := RunCommand(context.Background(), cmd, &Config{}, nil, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if result.Termination != TerminationSetupFailed {
+			t.Errorf("termination = %s", result.Termination)
+		}
+	})
+}
+
+func TestSignalFromExitStatus(t *testing.T) {
+	tests := []struct {
+		exitCode    int
+		termination Termination
+		signal      int
+	}{
+		{0, TerminationNormal, 0},
+		{1, TerminationNormal, 0},
+		{128, TerminationNormal, 0},
+		{137, TerminationSignal, 9},
+		{143, TerminationSignal, 15},
+		{152, TerminationCPULimit, 0},
+		{255, TerminationNormal, 0},
+	}
+	for _, tt := range tests {
+		result := &Result{ExitCode: tt.exitCode}
+		signalFromExitStatus(result)
+		if result.Termination != tt.termination || result.Signal != tt.signal {
+			t.Errorf("exit %d: termination = %s, signal = %d", tt.exitCode, result.Termination, result.Signal)
+		}
+	}
+
+	// A reason already known isn't replaced
+	result := &Result{ExitCode: 137, Termination: TerminationTimeout}
+	signalFromExitStatus(result)
+	if result.Termination != TerminationTimeout {
+		t.Errorf("termination = %s", result.Termination)
+	}
+}
@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Usage is what a run consumed. Disk and network figures are zero where they
+// couldn't be measured.
+type Usage struct {
+	WallTime     time.Duration
+	UserCPU      time.Duration
+	SystemCPU    time.Duration
+	PeakRSS      int64 // Peak resident memory in bytes
+	ReadBytes    int64 // Bytes read from storage (Linux only)
+	WrittenBytes int64 // Bytes written to storage (Linux only)
+
+	// Traffic through the network proxy, filled in by whoever runs the proxy
+	NetSentBytes     int64
+	NetReceivedBytes int64
+}
+
+// RunCommand runs cmd, created with ctx, and builds its Result, including
+// why it ended and the resources it used. Signals sent to buns are forwarded
+// to cmd's process tree, which is stopped with cfg's grace period when ctx is
+// done.
+func RunCommand(ctx context.Context, cmd *exec.Cmd, cfg *Config, stdout, stderr *bytes.Buffer) (*Result, error) {
+	// cmd.Process is set before anything calls tree
+	tree := func() []int { return ProcessTree(cmd.Process.Pid)() }
+	term, targets := handleCancel(cmd, tree, cfg.GracePeriod)
+
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		stopForwarding := ForwardSignals(targets)
+		err = exitErr(cmd, cmd.Wait())
+		stopForwarding()
+	}
+	wall := time.Since(start)
+	term.stop()
+
+	result, err := BuildResult(err, cfg, stdout, stderr)
+	if err == nil {
+		result.Termination, result.Signal = ProcessTermination(ctx, cmd.ProcessState)
+		result.Usage = ProcessUsage(cmd.ProcessState, wall)
+	}
+	return result, err
+}
+
+// ProcessUsage converts the resource usage of an exited process. It covers
+// the process and the descendants it waited for.
+func ProcessUsage(state *os.ProcessState, wall time.Duration) Usage {
+	usage := Usage{WallTime: wall}
+	if state == nil {
+		return usage
+	}
+
+	usage.UserCPU = state.UserTime()
+	usage.SystemCPU = state.SystemTime()
+
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return usage
+	}
+	if runtime.GOOS == "linux" {
+		// Kilobytes and 512-byte blocks on Linux
+		usage.PeakRSS = int64(rusage.Maxrss) * 1024
+		usage.ReadBytes = int64(rusage.Inblock) * 512
+		usage.WrittenBytes = int64(rusage.Oublock) * 512
+	} else {
+		// Bytes on macOS, which counts block operations rather than blocks
+		usage.PeakRSS = int64(rusage.Maxrss)
+	}
+	return usage
+}
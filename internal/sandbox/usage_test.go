@@ -0,0 +1,44 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestRunCommand_usage(t *testing.T) {
+	// Burn a little CPU and memory so there's something to measure
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result, err := RunCommand(context.Background(), cmd, &Config{}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code = %d", result.ExitCode)
+	}
+
+	u := result.Usage
+	if u.WallTime <= 0 {
+		t.Errorf("WallTime = %v", u.WallTime)
+	}
+	if u.UserCPU+u.SystemCPU <= 0 {
+		t.Errorf("no CPU time recorded: %+v", u)
+	}
+	if u.PeakRSS < 1024*1024 {
+		// Any shell uses more than a megabyte; a smaller figure means the unit is wrong
+		t.Errorf("PeakRSS = %d on %s", u.PeakRSS, runtime.GOOS)
+	}
+}
+
+func TestProcessUsage_notStarted(t *testing.T) {
+	u := ProcessUsage(nil, 0)
+	if u != (Usage{}) {
+		t.Errorf("ProcessUsage(nil) = %+v", u)
+	}
+}